@@ -4,12 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"gatekeeper/internal"
+	"gatekeeper/internal/entity"
+	"gatekeeper/internal/server"
 	"log/slog"
 	"os"
 	"time"
 
 	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
 	"github.com/go-co-op/gocron"
+	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/samber/do"
 )
 
@@ -21,14 +25,30 @@ func exitOnErr(msg string, err error) {
 }
 
 func main() {
-	i := internal.NewInjector()
+	var cfg server.Config
+	err := cleanenv.ReadEnv(&cfg)
+	exitOnErr("failed to read server config from env: %s", err)
+
+	i := internal.NewInjector(cfg)
 	defer i.Shutdown()
 
 	s := gocron.NewScheduler(time.UTC)
 
-	_, err := s.Every(30).Minutes().Name("DeleteExpiredChallengesJob").Do(DeleteExpiredChallengesJob, i)
+	_, err = s.Every(30).Minutes().Name("DeleteExpiredChallengesJob").Do(DeleteExpiredChallengesJob, i)
 	exitOnErr("failed to schedule DeleteExpiredChallengesJob", err)
 
+	_, err = s.Every(1).Day().Name("DeleteExpiredSessionsJob").Do(DeleteExpiredSessionsJob, i)
+	exitOnErr("failed to schedule DeleteExpiredSessionsJob", err)
+
+	_, err = s.Every(1).Hour().Name("DeleteExpiredRevokedTokensJob").Do(DeleteExpiredRevokedTokensJob, i)
+	exitOnErr("failed to schedule DeleteExpiredRevokedTokensJob", err)
+
+	_, err = s.Every(30).Minutes().Name("DeleteExpiredAuthorizationRequestsJob").Do(DeleteExpiredAuthorizationRequestsJob, i)
+	exitOnErr("failed to schedule DeleteExpiredAuthorizationRequestsJob", err)
+
+	_, err = s.Every(1).Hour().Name("EraseDeletedAccountsJob").Do(EraseDeletedAccountsJob, i, cfg)
+	exitOnErr("failed to schedule EraseDeletedAccountsJob", err)
+
 	s.RegisterEventListeners(
 		gocron.WhenJobReturnsError(func(jobName string, err error) {
 			slog.With("job", jobName).Error(err.Error())
@@ -48,3 +68,77 @@ func DeleteExpiredChallengesJob(i *do.Injector) error {
 
 	return nil
 }
+
+func DeleteExpiredSessionsJob(i *do.Injector) error {
+	db := do.MustInvoke[*sql.DB](i)
+	now := time.Now().UTC()
+
+	_, err := db.ExecContext(context.Background(),
+		"DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE expired_at <= ?)", now,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to delete refresh tokens of expired sessions: %w", err)
+	}
+
+	_, err = db.ExecContext(context.Background(), "DELETE FROM sessions WHERE expired_at <= ?", now)
+	if err != nil {
+		return errtrace.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	return nil
+}
+
+// Revoked proof tokens are rejected by their expiry anyway once expired
+func DeleteExpiredRevokedTokensJob(i *do.Injector) error {
+	db := do.MustInvoke[*sql.DB](i)
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM revoked_tokens WHERE expired_at <= ?", time.Now().UTC())
+	if err != nil {
+		return errtrace.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteExpiredAuthorizationRequestsJob(i *do.Injector) error {
+	db := do.MustInvoke[*sql.DB](i)
+
+	_, err := db.ExecContext(context.Background(), "DELETE FROM authorization_requests WHERE expired_at <= ?", time.Now().UTC())
+	if err != nil {
+		return errtrace.Errorf("failed to delete expired authorization requests: %w", err)
+	}
+
+	return nil
+}
+
+// Deleted accounts are erased once their grace period is over
+func EraseDeletedAccountsJob(i *do.Injector, config server.Config) error {
+	db := do.MustInvoke[*sql.DB](i)
+	ctx := context.Background()
+
+	var accounts []entity.Account
+	err := sqlscan.Select(ctx, db, &accounts,
+		"SELECT company_id, wallet_address FROM accounts WHERE erase_at <= ?", time.Now().UTC(),
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to get accounts to erase: %w", err)
+	}
+
+	for _, account := range accounts {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return errtrace.Errorf("failed to begin transaction: %w", err)
+		}
+		err = server.EraseAccount(ctx, tx, config.WalletAddressPepper, account.CompanyId, account.WalletAddress)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return errtrace.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+
+	return nil
+}
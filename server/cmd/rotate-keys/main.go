@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"gatekeeper/internal"
+	"gatekeeper/pkg/jwt_provider"
+	"log/slog"
+	"os"
+	"time"
+)
+
+func exitOnErr(msg string, err error) {
+	if err != nil {
+		slog.With("error", err.Error()).Error(msg)
+		os.Exit(1)
+	}
+}
+
+// Adds a new token signing key and deletes the keys replaced more than retention ago.
+// Running servers keep signing with the previous key until they reload the keys, at most a minute later.
+func main() {
+	retention := flag.Duration("retention", 24*time.Hour, "how long replaced keys are kept to verify the tokens they signed")
+	flag.Parse()
+
+	// The legacy key must be kept to verify the tokens it signed if the server did not import it yet
+	_, _, err := jwt_provider.ImportLegacyKey(internal.SigningKeysDir, internal.LegacySigningKeyFile)
+	exitOnErr("failed to import legacy signing key", err)
+
+	newKey, deletedKeys, err := jwt_provider.RotateKeys(internal.SigningKeysDir, *retention)
+	exitOnErr("failed to rotate signing keys", err)
+
+	slog.With("kid", newKey.Id).Info("created signing key")
+	for _, key := range deletedKeys {
+		slog.With("kid", key.Id).Info("deleted signing key")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"gatekeeper/internal"
 	"gatekeeper/internal/server"
 	"log/slog"
@@ -17,14 +18,18 @@ func exitOnErr(msg string, err error) {
 }
 
 func main() {
-	i := internal.NewInjector()
-	defer i.Shutdown()
-
 	var cfg server.Config
 	err := cleanenv.ReadEnv(&cfg)
 	exitOnErr("failed to read server config from env: %s", err)
 
+	i := internal.NewInjector(cfg)
+	defer i.Shutdown()
+
 	s := server.NewServer(i, cfg)
+
+	err = server.HashLegacyApiKeys(context.Background(), s.ApiKeyCtrl.DB, cfg.ApiKeyPepper)
+	exitOnErr("failed to hash legacy api keys", err)
+
 	err = s.Serve()
 	exitOnErr("failed to serve http server", err)
 }
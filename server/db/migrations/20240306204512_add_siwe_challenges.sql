@@ -0,0 +1,29 @@
+-- migrate:up
+ALTER TABLE companies ADD COLUMN domain VARCHAR(255);
+ALTER TABLE companies ADD COLUMN uri VARCHAR(255);
+
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address CHAR(42) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL
+);
+
+-- migrate:down
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  wallet_address CHAR(42) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  expired_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE companies DROP COLUMN uri;
+ALTER TABLE companies DROP COLUMN domain;
@@ -0,0 +1,57 @@
+-- migrate:up
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address VARCHAR(64) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE accounts_new (
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  metadata JSON,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  PRIMARY KEY (company_id, wallet_address)
+);
+INSERT INTO accounts_new (company_id, wallet_address, created_at, metadata)
+SELECT company_id, wallet_address, created_at, metadata FROM accounts;
+DROP TABLE accounts;
+ALTER TABLE accounts_new RENAME TO accounts;
+
+-- migrate:down
+CREATE TABLE accounts_old (
+  company_id INTEGER NOT NULL,
+  wallet_address CHAR(42) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  metadata JSON,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  PRIMARY KEY (company_id, wallet_address)
+);
+INSERT INTO accounts_old (company_id, wallet_address, created_at, metadata)
+SELECT company_id, wallet_address, created_at, metadata FROM accounts WHERE chain_namespace = 'eip155';
+DROP TABLE accounts;
+ALTER TABLE accounts_old RENAME TO accounts;
+
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address CHAR(42) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,33 @@
+-- migrate:up
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address VARCHAR(64) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+-- migrate:down
+DROP TABLE challenges;
+CREATE TABLE challenges (
+  id INTEGER PRIMARY KEY,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address VARCHAR(64) NOT NULL,
+  token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL
+);
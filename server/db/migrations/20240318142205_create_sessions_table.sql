@@ -0,0 +1,34 @@
+-- migrate:up
+ALTER TABLE companies ADD COLUMN proof_token_lifetime INTEGER NOT NULL DEFAULT 300;
+ALTER TABLE companies ADD COLUMN refresh_token_lifetime INTEGER NOT NULL DEFAULT 604800;
+ALTER TABLE companies ADD COLUMN session_lifetime INTEGER NOT NULL DEFAULT 2592000;
+
+CREATE TABLE sessions (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX sessions_company_id_wallet_address_idx ON sessions (company_id, wallet_address);
+
+CREATE TABLE refresh_tokens (
+  id INTEGER PRIMARY KEY,
+  session_id INTEGER NOT NULL,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+
+-- migrate:down
+DROP TABLE refresh_tokens;
+DROP TABLE sessions;
+
+ALTER TABLE companies DROP COLUMN session_lifetime;
+ALTER TABLE companies DROP COLUMN refresh_token_lifetime;
+ALTER TABLE companies DROP COLUMN proof_token_lifetime;
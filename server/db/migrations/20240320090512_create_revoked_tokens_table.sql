@@ -0,0 +1,9 @@
+-- migrate:up
+CREATE TABLE revoked_tokens (
+  jti CHAR(36) PRIMARY KEY,
+  revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL
+);
+
+-- migrate:down
+DROP TABLE revoked_tokens;
@@ -0,0 +1,30 @@
+-- migrate:up
+CREATE TABLE redirect_uris (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  uri VARCHAR(2048) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, uri)
+);
+
+CREATE TABLE authorization_requests (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  token CHAR(32) NOT NULL UNIQUE,
+  redirect_uri VARCHAR(2048) NOT NULL,
+  scope VARCHAR(255) NOT NULL,
+  state VARCHAR(255),
+  nonce VARCHAR(255),
+  code_challenge VARCHAR(128) NOT NULL,
+  wallet_address VARCHAR(64),
+  code_hash CHAR(64) UNIQUE,
+  authenticated_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+-- migrate:down
+DROP TABLE authorization_requests;
+DROP TABLE redirect_uris;
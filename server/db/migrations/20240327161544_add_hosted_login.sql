@@ -0,0 +1,16 @@
+-- migrate:up
+ALTER TABLE companies ADD COLUMN name VARCHAR(255);
+ALTER TABLE companies ADD COLUMN logo_url VARCHAR(2048);
+ALTER TABLE companies ADD COLUMN primary_color CHAR(7);
+ALTER TABLE companies ADD COLUMN background_color CHAR(7);
+
+-- Scope and code challenge are empty for flows other than OpenID Connect
+ALTER TABLE authorization_requests ADD COLUMN flow VARCHAR(16) NOT NULL DEFAULT 'oidc';
+
+-- migrate:down
+ALTER TABLE authorization_requests DROP COLUMN flow;
+
+ALTER TABLE companies DROP COLUMN background_color;
+ALTER TABLE companies DROP COLUMN primary_color;
+ALTER TABLE companies DROP COLUMN logo_url;
+ALTER TABLE companies DROP COLUMN name;
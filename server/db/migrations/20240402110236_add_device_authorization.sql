@@ -0,0 +1,13 @@
+-- migrate:up
+ALTER TABLE authorization_requests ADD COLUMN user_code CHAR(8);
+ALTER TABLE authorization_requests ADD COLUMN device_code_hash CHAR(64);
+ALTER TABLE authorization_requests ADD COLUMN last_polled_at TIMESTAMP;
+CREATE UNIQUE INDEX authorization_requests_user_code_idx ON authorization_requests (user_code);
+CREATE UNIQUE INDEX authorization_requests_device_code_hash_idx ON authorization_requests (device_code_hash);
+
+-- migrate:down
+DROP INDEX authorization_requests_device_code_hash_idx;
+DROP INDEX authorization_requests_user_code_idx;
+ALTER TABLE authorization_requests DROP COLUMN last_polled_at;
+ALTER TABLE authorization_requests DROP COLUMN device_code_hash;
+ALTER TABLE authorization_requests DROP COLUMN user_code;
@@ -0,0 +1,32 @@
+-- migrate:up
+CREATE TABLE api_keys (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  prefix CHAR(32) NOT NULL UNIQUE,
+  secret_hash CHAR(64),
+  legacy_secret VARCHAR(32),
+  label VARCHAR(255),
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMP,
+  expired_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX api_keys_company_id_idx ON api_keys (company_id);
+
+-- Existing keys keep working, their secrets are hashed by the server at startup as SQLite can't hash them
+INSERT INTO api_keys (company_id, prefix, legacy_secret, label, created_at)
+SELECT id, substr(api_key, 1, 32), substr(api_key, 33), 'Default', created_at FROM companies;
+
+ALTER TABLE companies DROP COLUMN api_key;
+
+-- migrate:down
+-- Only keys which are not hashed yet can be restored
+ALTER TABLE companies ADD COLUMN api_key CHAR(48) NOT NULL DEFAULT '';
+UPDATE companies SET api_key = (
+  SELECT prefix || legacy_secret FROM api_keys
+  WHERE company_id = companies.id AND legacy_secret IS NOT NULL AND revoked_at IS NULL
+  ORDER BY id LIMIT 1
+) WHERE EXISTS (SELECT 1 FROM api_keys WHERE company_id = companies.id AND legacy_secret IS NOT NULL AND revoked_at IS NULL);
+
+DROP TABLE api_keys;
@@ -0,0 +1,8 @@
+-- migrate:up
+ALTER TABLE api_keys ADD COLUMN scopes VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Existing keys keep access to everything
+UPDATE api_keys SET scopes = 'challenges:issue challenges:verify accounts:read accounts:write sessions:write tokens:introspect admin';
+
+-- migrate:down
+ALTER TABLE api_keys DROP COLUMN scopes;
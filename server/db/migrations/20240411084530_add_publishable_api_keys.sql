@@ -0,0 +1,16 @@
+-- migrate:up
+ALTER TABLE api_keys ADD COLUMN publishable BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE allowed_origins (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  origin VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, origin)
+);
+CREATE INDEX allowed_origins_origin_idx ON allowed_origins (origin);
+
+-- migrate:down
+DROP TABLE allowed_origins;
+ALTER TABLE api_keys DROP COLUMN publishable;
@@ -0,0 +1,13 @@
+-- migrate:up
+CREATE TABLE company_owners (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, wallet_address)
+);
+
+-- migrate:down
+DROP TABLE company_owners;
@@ -0,0 +1,5 @@
+-- migrate:up
+ALTER TABLE accounts ADD COLUMN metadata_version INTEGER NOT NULL DEFAULT 1;
+
+-- migrate:down
+ALTER TABLE accounts DROP COLUMN metadata_version;
@@ -0,0 +1,26 @@
+-- migrate:up
+ALTER TABLE accounts ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE accounts ADD COLUMN deleted_by VARCHAR(16);
+ALTER TABLE accounts ADD COLUMN erase_at TIMESTAMP;
+CREATE INDEX accounts_erase_at_idx ON accounts (erase_at);
+
+-- Erased accounts only leave a hash of their wallet address, enough to answer whether a wallet was erased
+CREATE TABLE account_tombstones (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL,
+  wallet_address_hash CHAR(64) NOT NULL,
+  account_created_at TIMESTAMP NOT NULL,
+  deleted_at TIMESTAMP NOT NULL,
+  deleted_by VARCHAR(16) NOT NULL,
+  erased_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX account_tombstones_company_id_wallet_address_hash_idx ON account_tombstones (company_id, wallet_address_hash);
+
+-- migrate:down
+DROP TABLE account_tombstones;
+DROP INDEX accounts_erase_at_idx;
+ALTER TABLE accounts DROP COLUMN erase_at;
+ALTER TABLE accounts DROP COLUMN deleted_by;
+ALTER TABLE accounts DROP COLUMN deleted_at;
@@ -0,0 +1,15 @@
+-- migrate:up
+CREATE TABLE metadata_schemas (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  schema BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, version)
+);
+ALTER TABLE accounts ADD COLUMN metadata_schema_version INTEGER;
+
+-- migrate:down
+ALTER TABLE accounts DROP COLUMN metadata_schema_version;
+DROP TABLE metadata_schemas;
@@ -0,0 +1,5 @@
+-- migrate:up
+ALTER TABLE challenges ADD COLUMN purpose VARCHAR(16) NOT NULL DEFAULT 'login';
+
+-- migrate:down
+ALTER TABLE challenges DROP COLUMN purpose;
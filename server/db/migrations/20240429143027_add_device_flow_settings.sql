@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE companies ADD COLUMN device_flow_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE authorization_requests ADD COLUMN device_info VARCHAR(255);
+ALTER TABLE authorization_requests ADD COLUMN confirmed_at TIMESTAMP;
+
+-- migrate:down
+ALTER TABLE authorization_requests DROP COLUMN confirmed_at;
+ALTER TABLE authorization_requests DROP COLUMN device_info;
+ALTER TABLE companies DROP COLUMN device_flow_enabled;
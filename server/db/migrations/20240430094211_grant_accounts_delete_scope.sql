@@ -0,0 +1,7 @@
+-- migrate:up
+-- Existing secret keys keep access to everything, publishable keys can't delete accounts
+UPDATE api_keys SET scopes = scopes || ' accounts:delete'
+WHERE NOT publishable AND ' ' || scopes || ' ' NOT LIKE '% accounts:delete %';
+
+-- migrate:down
+UPDATE api_keys SET scopes = TRIM(REPLACE(' ' || scopes || ' ', ' accounts:delete ', ' '));
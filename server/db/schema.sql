@@ -1,25 +1,157 @@
 CREATE TABLE IF NOT EXISTS "schema_migrations" (version varchar(128) primary key);
+CREATE TABLE companies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  domain VARCHAR(255), uri VARCHAR(255), proof_token_lifetime INTEGER NOT NULL DEFAULT 300, refresh_token_lifetime INTEGER NOT NULL DEFAULT 604800, session_lifetime INTEGER NOT NULL DEFAULT 2592000, name VARCHAR(255), logo_url VARCHAR(2048), primary_color CHAR(7), background_color CHAR(7), device_flow_enabled BOOLEAN NOT NULL DEFAULT FALSE);
+CREATE TABLE IF NOT EXISTS "accounts" (
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  metadata JSON, metadata_version INTEGER NOT NULL DEFAULT 1, deleted_at TIMESTAMP, deleted_by VARCHAR(16), erase_at TIMESTAMP, metadata_schema_version INTEGER,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  PRIMARY KEY (company_id, wallet_address)
+);
 CREATE TABLE challenges (
   id INTEGER PRIMARY KEY,
-  wallet_address CHAR(42) NOT NULL,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  wallet_address VARCHAR(64) NOT NULL,
   token CHAR(16) NOT NULL UNIQUE,
+  domain VARCHAR(255),
+  uri VARCHAR(255),
+  chain_id INTEGER,
+  issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  not_before TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL, purpose VARCHAR(16) NOT NULL DEFAULT 'login',
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE TABLE sessions (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX sessions_company_id_wallet_address_idx ON sessions (company_id, wallet_address);
+CREATE TABLE refresh_tokens (
+  id INTEGER PRIMARY KEY,
+  session_id INTEGER NOT NULL,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+CREATE TABLE revoked_tokens (
+  jti CHAR(36) PRIMARY KEY,
+  revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
   expired_at TIMESTAMP NOT NULL
 );
-CREATE TABLE companies (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
+CREATE TABLE redirect_uris (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  uri VARCHAR(2048) NOT NULL,
   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  api_key CHAR(48) NOT NULL
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, uri)
 );
-CREATE TABLE accounts (
+CREATE TABLE authorization_requests (
+  id INTEGER PRIMARY KEY,
   company_id INTEGER NOT NULL,
-  wallet_address CHAR(42) NOT NULL,
+  token CHAR(32) NOT NULL UNIQUE,
+  redirect_uri VARCHAR(2048) NOT NULL,
+  scope VARCHAR(255) NOT NULL,
+  state VARCHAR(255),
+  nonce VARCHAR(255),
+  code_challenge VARCHAR(128) NOT NULL,
+  wallet_address VARCHAR(64),
+  code_hash CHAR(64) UNIQUE,
+  authenticated_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMP NOT NULL, flow VARCHAR(16) NOT NULL DEFAULT 'oidc', user_code CHAR(8), device_code_hash CHAR(64), last_polled_at TIMESTAMP, device_info VARCHAR(255), confirmed_at TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE UNIQUE INDEX authorization_requests_user_code_idx ON authorization_requests (user_code);
+CREATE UNIQUE INDEX authorization_requests_device_code_hash_idx ON authorization_requests (device_code_hash);
+CREATE TABLE api_keys (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  prefix CHAR(32) NOT NULL UNIQUE,
+  secret_hash CHAR(64),
+  legacy_secret VARCHAR(32),
+  label VARCHAR(255),
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMP,
+  expired_at TIMESTAMP,
+  revoked_at TIMESTAMP, scopes VARCHAR(255) NOT NULL DEFAULT '', publishable BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX api_keys_company_id_idx ON api_keys (company_id);
+CREATE TABLE allowed_origins (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  origin VARCHAR(255) NOT NULL,
   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  metadata JSON,
   FOREIGN KEY (company_id) REFERENCES companies(id),
-  PRIMARY KEY (company_id, wallet_address)
+  UNIQUE (company_id, origin)
+);
+CREATE INDEX allowed_origins_origin_idx ON allowed_origins (origin);
+CREATE TABLE company_owners (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL DEFAULT 'eip155',
+  wallet_address VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, wallet_address)
+);
+CREATE INDEX accounts_erase_at_idx ON accounts (erase_at);
+CREATE TABLE account_tombstones (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  chain_namespace VARCHAR(16) NOT NULL,
+  wallet_address_hash CHAR(64) NOT NULL,
+  account_created_at TIMESTAMP NOT NULL,
+  deleted_at TIMESTAMP NOT NULL,
+  deleted_by VARCHAR(16) NOT NULL,
+  erased_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+CREATE INDEX account_tombstones_company_id_wallet_address_hash_idx ON account_tombstones (company_id, wallet_address_hash);
+CREATE TABLE metadata_schemas (
+  id INTEGER PRIMARY KEY,
+  company_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  schema BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id),
+  UNIQUE (company_id, version)
 );
 -- Dbmate schema migrations
 INSERT INTO "schema_migrations" (version) VALUES
   ('20231122185055'),
   ('20240221213521'),
-  ('20240229221005');
+  ('20240229221005'),
+  ('20240306204512'),
+  ('20240312193040'),
+  ('20240315101230'),
+  ('20240318142205'),
+  ('20240320090512'),
+  ('20240325143018'),
+  ('20240327161544'),
+  ('20240402110236'),
+  ('20240405093127'),
+  ('20240408152204'),
+  ('20240411084530'),
+  ('20240415101842'),
+  ('20240418093015'),
+  ('20240422140533'),
+  ('20240426113407'),
+  ('20240429091512'),
+  ('20240429143027'),
+  ('20240430094211');
@@ -1,7 +1,24 @@
-INSERT INTO "companies" (id, api_key)
+INSERT INTO "companies" (id, domain, uri)
 VALUES (
   1,
-  "018df6ccab907592ae2da5c3dd9a79f3AFF3MAUaKHt9DVuBBi4Jzw"
+  "localhost:4000",
+  "http://localhost:4000"
+);
+
+-- Api key 018df6ccab907592ae2da5c3dd9a79f3AFF3MAUaKHt9DVuBBi4Jzw, hashed with the API_KEY_PEPPER gatekeeper-development
+INSERT INTO "api_keys" (company_id, prefix, secret_hash, label, scopes)
+VALUES (
+  1,
+  "018df6ccab907592ae2da5c3dd9a79f3",
+  "55fb548e13dcff01e50b26d1dc8b55fad2a7be069d212acf699b6c4f68316956",
+  "Default",
+  "challenges:issue challenges:verify accounts:read accounts:write accounts:delete sessions:write tokens:introspect admin"
+);
+
+INSERT INTO "company_owners" (company_id, wallet_address)
+VALUES (
+  1,
+  "0x25a3aaf7a4fF88A8aa53ff63CFE5e8C16ce93756"
 );
 
 INSERT INTO "accounts" (company_id, wallet_address, metadata)
@@ -9,4 +26,15 @@ VALUES (
   1,
   "0x25a3aaf7a4fF88A8aa53ff63CFE5e8C16ce93756",
   '{"email":"odor@gatekeeper.com"}'
-);
\ No newline at end of file
+);
+INSERT INTO "allowed_origins" (company_id, origin)
+VALUES (
+  1,
+  "http://localhost:4000"
+);
+
+INSERT INTO "redirect_uris" (company_id, uri)
+VALUES (
+  1,
+  "http://localhost:4000/callback"
+);
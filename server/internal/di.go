@@ -4,66 +4,78 @@ import (
 	"database/sql"
 	"fmt"
 	"gatekeeper/internal/helper"
+	"gatekeeper/internal/server"
+	"gatekeeper/pkg/eip1271"
 	"gatekeeper/pkg/fs"
 	"gatekeeper/pkg/jwt_provider"
+	"gatekeeper/pkg/verifier"
 	"os"
-	"testing"
 
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/samber/do"
-	"github.com/stretchr/testify/require"
 
 	_ "github.com/glebarez/go-sqlite"
 )
 
-func NewInjector() *do.Injector {
+var SigningKeysDir = fs.RelativePath("../secrets/keys")
+
+// Single key tokens were signed with before key sets, it is imported as the initial key of SigningKeysDir
+var LegacySigningKeyFile = fs.RelativePath("../secrets/ecdsa")
+
+func NewInjector(config server.Config) *do.Injector {
 	i := do.New()
 
+	do.ProvideValue(i, config)
+
 	do.Provide(i, func(_ *do.Injector) (*sql.DB, error) {
 		// TODO: Implement do.Shutdownable and do.Healthcheckable
 		return sql.Open("sqlite", helper.RelativePath("../db/database.sqlite"))
 	})
 
+	// Keys are created by cmd/rotate-keys, the server reloads them to pick up a rotation
 	do.Provide(i, func(i *do.Injector) (jwt_provider.Provider, error) {
-		privKeyFile, err := os.Open(fs.RelativePath("../secrets/ecdsa"))
+		config := do.MustInvoke[server.Config](i)
+		legacyKey, hasLegacyKey, err := jwt_provider.ImportLegacyKey(SigningKeysDir, LegacySigningKeyFile)
 		if err != nil {
-			return jwt_provider.Provider{}, fmt.Errorf("failed to open private key file: %w", err)
+			return jwt_provider.Provider{}, fmt.Errorf("failed to import legacy signing key: %w", err)
 		}
-		pubKeyFile, err := os.Open(fs.RelativePath("../secrets/ecdsa.pub"))
+		provider, err := jwt_provider.LoadProvider(SigningKeysDir, config.IssuerUrl)
 		if err != nil {
-			return jwt_provider.Provider{}, fmt.Errorf("failed to open public key file: %w", err)
+			return jwt_provider.Provider{}, fmt.Errorf("failed to load signing keys: %w", err)
 		}
-		return jwt_provider.NewProvider(privKeyFile, pubKeyFile)
-	})
 
-	return i
-}
-
-func NewTestInjector(t *testing.T) *do.Injector {
-	i := NewInjector()
+		// Tokens without kid were signed before the legacy key was imported, they are accepted until they expire
+		if hasLegacyKey {
+			importedAt, err := legacyKey.CreatedAt()
+			if err != nil {
+				return jwt_provider.Provider{}, fmt.Errorf("failed to get legacy signing key import time: %w", err)
+			}
+			provider.LegacyKeyId = legacyKey.Id
+			provider.LegacyKeyExpiry = importedAt.Add(config.LegacyTokensTransition)
+		}
+		return provider, nil
+	})
 
-	do.Override(i, func(_ *do.Injector) (*sql.DB, error) {
-		// TODO: Implement do.Shutdownable and do.Healthcheckable
-		db, err := sql.Open("sqlite", ":memory:")
+	// Smart contract wallet signatures are only verified when a json rpc endpoint is configured
+	do.Provide(i, func(_ *do.Injector) (eip1271.Caller, error) {
+		rpcUrl := os.Getenv("ETH_RPC_URL")
+		if rpcUrl == "" {
+			return nil, nil
+		}
+		client, err := ethclient.Dial(rpcUrl)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to dial ethereum json rpc endpoint: %w", err)
 		}
-
-		// Load schema
-		schemaSqlBytes, err := os.ReadFile(helper.RelativePath("../db/schema.sql"))
-		require.NoError(t, err)
-		_, err = db.Exec(string(schemaSqlBytes))
-		require.NoError(t, err)
-
-		// Load seed
-		seedSqlBytes, err := os.ReadFile(helper.RelativePath("../db/seed.sql"))
-		require.NoError(t, err)
-		_, err = db.Exec(string(seedSqlBytes))
-		require.NoError(t, err)
-
-		return db, nil
+		return client, nil
 	})
 
-	do.Override(i, jwt_provider.InjectTestProvider(t))
+	do.Provide(i, func(i *do.Injector) (*verifier.Registry, error) {
+		return verifier.NewRegistry(
+			verifier.NewEip155Verifier(do.MustInvoke[eip1271.Caller](i)),
+			verifier.NewSolanaVerifier(),
+			verifier.NewBip122Verifier(),
+		), nil
+	})
 
 	return i
 }
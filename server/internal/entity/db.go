@@ -5,22 +5,151 @@ import (
 )
 
 type Challenge struct {
-	Id            uint      `db:"id"`
-	WalletAddress string    `db:"wallet_address"`
-	Token         string    `db:"token"`
-	ExpiredAt     time.Time `db:"expired_at"`
+	Id             uint       `db:"id"`
+	CompanyId      uint       `db:"company_id"`
+	Purpose        string     `db:"purpose"`
+	ChainNamespace string     `db:"chain_namespace"`
+	Format         string     `db:"format"`
+	WalletAddress  string     `db:"wallet_address"`
+	Token          string     `db:"token"`
+	Domain         *string    `db:"domain"`
+	URI            *string    `db:"uri"`
+	ChainId        *uint64    `db:"chain_id"`
+	IssuedAt       time.Time  `db:"issued_at"`
+	NotBefore      *time.Time `db:"not_before"`
+	ExpiredAt      time.Time  `db:"expired_at"`
 }
 
 type Company struct {
+	Id        uint      `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	Domain    *string   `db:"domain"`
+	URI       *string   `db:"uri"`
+	// Lifetimes in seconds
+	ProofTokenLifetime   uint `db:"proof_token_lifetime"`
+	RefreshTokenLifetime uint `db:"refresh_token_lifetime"`
+	SessionLifetime      uint `db:"session_lifetime"`
+	// Branding of the hosted login page
+	Name            *string `db:"name"`
+	LogoUrl         *string `db:"logo_url"`
+	PrimaryColor    *string `db:"primary_color"`
+	BackgroundColor *string `db:"background_color"`
+	// Devices can only sign in to companies which enabled the device authorization flow
+	DeviceFlowEnabled bool `db:"device_flow_enabled"`
+}
+
+// CompanyOwner is a wallet managing the company through the owner api
+type CompanyOwner struct {
 	Id             uint      `db:"id"`
+	CompanyId      uint      `db:"company_id"`
+	ChainNamespace string    `db:"chain_namespace"`
+	WalletAddress  string    `db:"wallet_address"`
 	CreatedAt      time.Time `db:"created_at"`
-	ApiKey         string    `db:"api_key"`
-	AdminAccountId uint      `db:"admin_account_id"`
 }
 
 type Account struct {
-	CompanyId     uint      `db:"company_id"`
-	WalletAddress string    `db:"wallet_address"`
-	CreatedAt     time.Time `db:"created_at"`
-	Metadata      []byte    `db:"metadata"`
+	CompanyId      uint      `db:"company_id"`
+	ChainNamespace string    `db:"chain_namespace"`
+	WalletAddress  string    `db:"wallet_address"`
+	CreatedAt      time.Time `db:"created_at"`
+	Metadata       []byte    `db:"metadata"`
+	// Incremented on every change of the metadata, used as its ETag
+	MetadataVersion uint `db:"metadata_version"`
+	// Version of the metadata schema of the company which the metadata was last validated against
+	MetadataSchemaVersion *uint `db:"metadata_schema_version"`
+	// Deleted accounts are hidden until they are erased at the end of the grace period, the deletion can be undone until then
+	DeletedAt *time.Time `db:"deleted_at"`
+	DeletedBy *string    `db:"deleted_by"`
+	EraseAt   *time.Time `db:"erase_at"`
+}
+
+// MetadataSchema is a JSON Schema which the account metadata of the company must conform to, accounts are validated against the latest version
+type MetadataSchema struct {
+	Id        uint      `db:"id"`
+	CompanyId uint      `db:"company_id"`
+	Version   uint      `db:"version"`
+	Schema    []byte    `db:"schema"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// AccountTombstone is what is left of an erased account for audit
+type AccountTombstone struct {
+	Id                uint      `db:"id"`
+	CompanyId         uint      `db:"company_id"`
+	ChainNamespace    string    `db:"chain_namespace"`
+	WalletAddressHash string    `db:"wallet_address_hash"`
+	AccountCreatedAt  time.Time `db:"account_created_at"`
+	DeletedAt         time.Time `db:"deleted_at"`
+	DeletedBy         string    `db:"deleted_by"`
+	ErasedAt          time.Time `db:"erased_at"`
+}
+
+type Session struct {
+	Id            uint       `db:"id"`
+	CompanyId     uint       `db:"company_id"`
+	WalletAddress string     `db:"wallet_address"`
+	CreatedAt     time.Time  `db:"created_at"`
+	ExpiredAt     time.Time  `db:"expired_at"`
+	RevokedAt     *time.Time `db:"revoked_at"`
+}
+
+type RefreshToken struct {
+	Id        uint       `db:"id"`
+	SessionId uint       `db:"session_id"`
+	TokenHash string     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiredAt time.Time  `db:"expired_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+type RedirectUri struct {
+	Id        uint      `db:"id"`
+	CompanyId uint      `db:"company_id"`
+	Uri       string    `db:"uri"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// AuthorizationRequest is started by an OpenID Connect client or the hosted login page, once the wallet is authenticated it holds the authorization code
+type AuthorizationRequest struct {
+	Id          uint   `db:"id"`
+	CompanyId   uint   `db:"company_id"`
+	Flow        string `db:"flow"`
+	Token       string `db:"token"`
+	RedirectUri string `db:"redirect_uri"`
+	// OpenID Connect only, scope and code challenge are empty for other flows
+	Scope         string  `db:"scope"`
+	State         *string `db:"state"`
+	Nonce         *string `db:"nonce"`
+	CodeChallenge string  `db:"code_challenge"`
+	// Device authorization only
+	UserCode       *string `db:"user_code"`
+	DeviceCodeHash *string `db:"device_code_hash"`
+	// User agent of the device, shown to the wallet before it signs in
+	DeviceInfo *string `db:"device_info"`
+	// Set once the wallet confirmed it is signing in the device
+	ConfirmedAt     *time.Time `db:"confirmed_at"`
+	LastPolledAt    *time.Time `db:"last_polled_at"`
+	WalletAddress   *string    `db:"wallet_address"`
+	CodeHash        *string    `db:"code_hash"`
+	AuthenticatedAt *time.Time `db:"authenticated_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+	ExpiredAt       time.Time  `db:"expired_at"`
+}
+
+type ApiKey struct {
+	Id         uint    `db:"id"`
+	CompanyId  uint    `db:"company_id"`
+	Prefix     string  `db:"prefix"`
+	SecretHash *string `db:"secret_hash"`
+	// Secret of a key created before secrets were hashed, until the server hashes it at startup or on its first use
+	LegacySecret *string `db:"legacy_secret"`
+	Label        *string `db:"label"`
+	// Space separated scopes
+	Scopes string `db:"scopes"`
+	// Publishable keys are embedded in web pages, they are only accepted from the allowed origins of the company
+	Publishable bool       `db:"publishable"`
+	CreatedAt   time.Time  `db:"created_at"`
+	LastUsedAt  *time.Time `db:"last_used_at"`
+	ExpiredAt   *time.Time `db:"expired_at"`
+	RevokedAt   *time.Time `db:"revoked_at"`
 }
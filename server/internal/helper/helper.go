@@ -1,8 +1,11 @@
 package helper
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -17,6 +20,8 @@ func RelativePath(relativePath string) string {
 	return folderPath + "/" + relativePath
 }
 
+// Api keys are a public prefix used to look them up, followed by a secret which is only stored hashed
+const ApiKeyPrefixLength = 32
 const ApiKeySuffixLength = 16
 
 func GenerateApiKey() (string, error) {
@@ -31,3 +36,19 @@ func GenerateApiKey() (string, error) {
 	}
 	return strings.ReplaceAll(uuid.String(), "-", "") + strings.ToLower(base64.URLEncoding.EncodeToString(b)), nil
 }
+
+// SplitApiKey splits an api key in its prefix and its secret
+func SplitApiKey(apiKey string) (prefix string, secret string, ok bool) {
+	if len(apiKey) <= ApiKeyPrefixLength {
+		return "", "", false
+	}
+	return apiKey[:ApiKeyPrefixLength], apiKey[ApiKeyPrefixLength:], true
+}
+
+// Hmac returns the hex encoded HMAC-SHA256 of the value, secrets are hashed with a pepper of the server as key
+// so that they can't be found by hashing guesses without the pepper
+func Hmac(key string, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,12 +1,25 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/internal/helper"
+	"gatekeeper/pkg/json_ext"
 	"gatekeeper/pkg/jwt_provider"
 	"gatekeeper/pkg/sqlite_ext"
+	"gatekeeper/pkg/verifier"
+	"io"
+	"mime"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"braces.dev/errtrace"
 	"github.com/georgysavva/scany/sqlscan"
@@ -16,32 +29,83 @@ import (
 )
 
 const (
-	MsgMetadataIsInvalid    = "Metadata is invalid"
-	MsgAccountAlreadyExists = "Account already exists"
-	MsgAccountDoesNotExist  = "Account does not exist"
+	MsgMetadataIsInvalid         = "Metadata is invalid"
+	MsgMetadataIsTooLarge        = "Metadata is too large"
+	MsgMetadataIsTooDeep         = "Metadata is too deeply nested"
+	MsgMetadataVersionIsOutdated = "Metadata was changed since the version of If-Match"
+	MsgAccountAlreadyExists      = "Account already exists"
+	MsgAccountDoesNotExist       = "Account does not exist"
+	MsgAccountIsDeleted          = "Account is deleted, it can be restored until it is erased"
+	MsgAccountsQueryIsInvalid    = "Accounts query is invalid"
+	MsgAccountsCursorIsInvalid   = "Accounts cursor is invalid"
+)
+
+// Bodies of metadata requests can be formatted, so they can be this many times larger than the size limit of the metadata
+const MetadataBodySizeFactor = 4
+
+// Number of accounts returned by a list request, unless it sets its own limit
+const (
+	AccountsDefaultPageSize uint = 50
+	AccountsMaxPageSize     uint = 100
+)
+
+// Content-Type parameter of the create request telling that its metadata is encoded in base64 like before metadata was sent as a JSON object,
+// e.g. Content-Type: application/json; metadata=base64
+const (
+	MetadataEncodingParam   = "metadata"
+	MetadataEncoding_Base64 = "base64"
+)
+
+// Query params starting with this prefix filter accounts by a metadata field, e.g. metadata.email=odor@gatekeeper.com
+const AccountsMetadataFilterPrefix = "metadata."
+
+// Format of the timestamps saved by CURRENT_TIMESTAMP, which accounts are paginated by
+const sqliteTimestampFormat = "2006-01-02 15:04:05"
+
+var metadataFieldRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// AccountDeletedBy tells who requested the deletion of an account
+type AccountDeletedBy string
+
+const (
+	AccountDeletedBy_Wallet AccountDeletedBy = "wallet"
+	AccountDeletedBy_ApiKey AccountDeletedBy = "api_key"
 )
 
 type AccountController struct {
+	Config      Config
 	DB          *sql.DB
 	JwtProvider jwt_provider.Provider
+	Verifiers   *verifier.Registry
 }
 
 func NewAccountController(echoGrp *echo.Group, i *do.Injector) AccountController {
 	ct := AccountController{
+		Config:      do.MustInvoke[Config](i),
 		DB:          do.MustInvoke[*sql.DB](i),
 		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+		Verifiers:   do.MustInvoke[*verifier.Registry](i),
 	}
 
-	accounts := echoGrp.Group("/accounts", NewApiKeyMiddleware(i), NewProofTokenMiddleware(i))
-	accounts.POST("", ct.Create)
-	accounts.GET("/:walletAddress/metadata", ct.GetMetadata)
+	accounts := echoGrp.Group("/accounts")
+	accounts.GET("", ct.List, NewApiKeyMiddleware(i, ApiKeyScope_AccountsRead))
+	accounts.GET("/:walletAddress", ct.Get, NewApiKeyMiddleware(i, ApiKeyScope_AccountsRead))
+	accounts.POST("", ct.Create, NewApiKeyMiddleware(i, ApiKeyScope_AccountsWrite), NewProofTokenMiddleware(i))
+	accounts.GET("/:walletAddress/metadata", ct.GetMetadata, NewApiKeyMiddleware(i, ApiKeyScope_AccountsRead), NewProofTokenMiddleware(i))
+	accounts.PATCH("/:walletAddress/metadata", ct.PatchMetadata, NewApiKeyMiddleware(i, ApiKeyScope_AccountsWrite), NewProofTokenMiddleware(i))
+	accounts.PUT("/:walletAddress/metadata", ct.PutMetadata, NewApiKeyMiddleware(i, ApiKeyScope_AccountsWrite), NewProofTokenMiddleware(i))
+	accounts.POST("/:walletAddress/metadata/migrate", ct.MigrateMetadata, NewApiKeyMiddleware(i, ApiKeyScope_AccountsWrite))
+	accounts.DELETE("/:walletAddress", ct.Delete, NewApiKeyMiddleware(i, ApiKeyScope_AccountsWrite), NewProofTokenMiddleware(i))
+	accounts.POST("/:walletAddress/deletion", ct.ScheduleDeletion, NewApiKeyMiddleware(i, ApiKeyScope_AccountsDelete))
+	accounts.DELETE("/:walletAddress/deletion", ct.CancelDeletion, NewApiKeyMiddleware(i, ApiKeyScope_AccountsDelete))
 
 	return ct
 }
 
 type AccountController_CreateRequest struct {
 	WalletAddress string `json:"walletAddress" validate:"required"`
-	Metadata      []byte `json:"metadata" validate:"-"`
+	// JSON object, or a base64 string of the JSON object with the metadata=base64 Content-Type parameter
+	Metadata json.RawMessage `json:"metadata" validate:"-"`
 }
 
 func (ct AccountController) Create(c echo.Context) error {
@@ -50,28 +114,61 @@ func (ct AccountController) Create(c echo.Context) error {
 		return err
 	}
 
+	companyId := getContextValue[uint](c, ContextKey_CompanyId)
+
 	// Unmarshal metadata
 	metadataOpt := sql.Null[[]byte]{}
-	if req.Metadata != nil {
-		metadata := map[string]any{}
-		err := json.Unmarshal(req.Metadata, &metadata)
-		if err != nil {
+	metadata := map[string]any{}
+	if len(req.Metadata) > 0 && string(req.Metadata) != "null" {
+		metadataBytes := []byte(req.Metadata)
+		if isBase64MetadataRequest(c) {
+			// Base64 strings are decoded by unmarshalling them into bytes
+			err := json.Unmarshal(req.Metadata, &metadataBytes)
+			if err != nil {
+				return NewHTTPError(http.StatusBadRequest, MsgMetadataIsInvalid)
+			}
+		}
+		err := decodeJson(metadataBytes, &metadata)
+		if err != nil || metadata == nil {
 			return NewHTTPError(http.StatusBadRequest, MsgMetadataIsInvalid)
 		}
-		metadataOpt = sql.Null[[]byte]{Valid: true, V: req.Metadata}
+		// Encode it again like updates do, so that whitespace doesn't count towards the size limit and isn't stored
+		metadataBytes, err = json.Marshal(metadata)
+		if err != nil {
+			return errtrace.Errorf("failed to marshal metadata: %w", err)
+		}
+		err = checkMetadataLimits(ct.Config, metadataBytes, metadata)
+		if err != nil {
+			return err
+		}
+		metadataOpt = sql.Null[[]byte]{Valid: true, V: metadataBytes}
+	}
+	metadataSchemaVersion, err := validateMetadata(c.Request().Context(), ct.DB, companyId, metadata)
+	if err != nil {
+		return err
 	}
 
-	// Create account
-	if getContextValue[string](c, ContextKey_WalletAddress) != req.WalletAddress {
+	// Create account, proof tokens are issued to the normalized address
+	v, walletAddress, ok := ct.Verifiers.Detect(req.WalletAddress)
+	if !ok {
+		return NewHTTPError(http.StatusBadRequest, MsgWalletAddressIsInvalid)
+	}
+	if getContextValue[string](c, ContextKey_WalletAddress) != walletAddress {
 		return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
 	}
-	companyId := getContextValue[uint](c, ContextKey_CompanyId)
 	_, err = ct.DB.ExecContext(c.Request().Context(),
-		"INSERT INTO accounts (company_id, wallet_address, metadata) VALUES (?, ?, ?)",
-		companyId, req.WalletAddress, metadataOpt,
+		"INSERT INTO accounts (company_id, chain_namespace, wallet_address, metadata, metadata_schema_version) VALUES (?, ?, ?, ?, ?)",
+		companyId, v.ChainNamespace(), walletAddress, metadataOpt, metadataSchemaVersion,
 	)
 	if err != nil {
 		if sqlite_ext.HasErrCode(err, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+			var deleted bool
+			err = sqlscan.Get(c.Request().Context(), ct.DB, &deleted,
+				"SELECT deleted_at IS NOT NULL FROM accounts WHERE company_id = ? AND wallet_address = ?", companyId, walletAddress,
+			)
+			if err == nil && deleted {
+				return NewHTTPError(http.StatusBadRequest, MsgAccountIsDeleted)
+			}
 			return NewHTTPError(http.StatusBadRequest, MsgAccountAlreadyExists)
 		}
 		return errtrace.Errorf("failed to create account: %w", err)
@@ -84,30 +181,628 @@ type AccountController_GetMetadataResponse struct {
 	Metadata map[string]any `json:"metadata"`
 }
 
+// GetMetadata returns the metadata of the account with its version in the ETag header
 func (ct AccountController) GetMetadata(c echo.Context) error {
-	walletAddress := c.Param("walletAddress")
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
 
 	if getContextValue[string](c, ContextKey_WalletAddress) != walletAddress {
 		return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
 	}
 	companyId := getContextValue[uint](c, ContextKey_CompanyId)
 
-	var metadataBytes []byte
-	err := sqlscan.Get(c.Request().Context(), ct.DB, &metadataBytes,
-		"SELECT metadata FROM accounts WHERE company_id = ? AND wallet_address = ? LIMIT 1", companyId, walletAddress,
+	account, err := getAccountMetadata(c.Request().Context(), ct.DB, companyId, walletAddress)
+	if err != nil {
+		return err
+	}
+	metadata, err := decodeMetadata(account.Metadata)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("ETag", metadataETag(account.MetadataVersion))
+	return errtrace.Wrap(c.JSON(http.StatusOK, AccountController_GetMetadataResponse{Metadata: metadata}))
+}
+
+type AccountController_PatchMetadataResponse = AccountController_GetMetadataResponse
+
+// PatchMetadata applies the JSON merge patch of the request body to the metadata, keys set to null are removed
+func (ct AccountController) PatchMetadata(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+	if getContextValue[string](c, ContextKey_WalletAddress) != walletAddress {
+		return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
+	}
+	patchBytes, err := ct.readMetadataBody(c)
+	if err != nil {
+		return err
+	}
+	var patch map[string]any
+	err = decodeJson(patchBytes, &patch)
+	if err != nil || patch == nil {
+		return NewHTTPError(http.StatusBadRequest, MsgMetadataIsInvalid)
+	}
+
+	return ct.updateMetadata(c, walletAddress, func(metadata map[string]any) map[string]any {
+		return json_ext.MergePatch(metadata, patch).(map[string]any)
+	})
+}
+
+type AccountController_PutMetadataRequest struct {
+	Metadata map[string]any `json:"metadata" validate:"-"`
+}
+
+type AccountController_PutMetadataResponse = AccountController_GetMetadataResponse
+
+// PutMetadata replaces the metadata
+func (ct AccountController) PutMetadata(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+	if getContextValue[string](c, ContextKey_WalletAddress) != walletAddress {
+		return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
+	}
+	reqBytes, err := ct.readMetadataBody(c)
+	if err != nil {
+		return err
+	}
+	// Decoded like the metadata of the other requests, so that large numbers are not rounded
+	var req AccountController_PutMetadataRequest
+	err = decodeJson(reqBytes, &req)
+	if err != nil {
+		return ErrBadRequest
+	}
+	if req.Metadata == nil {
+		req.Metadata = map[string]any{}
+	}
+
+	return ct.updateMetadata(c, walletAddress, func(map[string]any) map[string]any {
+		return req.Metadata
+	})
+}
+
+type AccountController_MigrateMetadataResponse = AccountController_GetMetadataResponse
+
+// MigrateMetadata lets the backend of the company stamp the metadata of an account with the latest metadata schema once it conforms to it.
+// The metadata itself can't be changed without the consent of the wallet, it is validated again as is.
+func (ct AccountController) MigrateMetadata(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+
+	return ct.updateMetadata(c, walletAddress, func(metadata map[string]any) map[string]any {
+		return metadata
+	})
+}
+
+// updateMetadata saves the metadata returned by update as a new version, if the If-Match header is set it must match the current version.
+// The new metadata must conform to the latest metadata schema of the company.
+func (ct AccountController) updateMetadata(c echo.Context, walletAddress string, update func(metadata map[string]any) map[string]any) error {
+	companyId := getContextValue[uint](c, ContextKey_CompanyId)
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	account, err := getAccountMetadata(ctx, tx, companyId, walletAddress)
+	if err != nil {
+		return err
+	}
+	if !etagMatches(c.Request().Header.Get("If-Match"), metadataETag(account.MetadataVersion)) {
+		return NewHTTPError(http.StatusPreconditionFailed, MsgMetadataVersionIsOutdated)
+	}
+	metadata, err := decodeMetadata(account.Metadata)
+	if err != nil {
+		return err
+	}
+
+	metadata = update(metadata)
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return errtrace.Errorf("failed to marshal metadata: %w", err)
+	}
+	err = checkMetadataLimits(ct.Config, metadataBytes, metadata)
+	if err != nil {
+		return err
+	}
+	metadataSchemaVersion, err := validateMetadata(ctx, tx, companyId, metadata)
+	if err != nil {
+		return err
+	}
+	// Version is checked again in case the metadata was changed concurrently
+	res, err := tx.ExecContext(ctx,
+		`UPDATE accounts SET metadata = ?, metadata_version = metadata_version + 1, metadata_schema_version = ?
+		WHERE company_id = ? AND wallet_address = ? AND metadata_version = ?`,
+		metadataBytes, metadataSchemaVersion, companyId, walletAddress, account.MetadataVersion,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to update account metadata: %w", err)
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return NewHTTPError(http.StatusPreconditionFailed, MsgMetadataVersionIsOutdated)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	c.Response().Header().Set("ETag", metadataETag(account.MetadataVersion+1))
+	return errtrace.Wrap(c.JSON(http.StatusOK, AccountController_GetMetadataResponse{Metadata: metadata}))
+}
+
+type AccountController_DeleteResponse struct {
+	DeletedAt time.Time `json:"deletedAt"`
+	// Metadata is erased at this time, the deletion can be cancelled until then
+	EraseAt time.Time `json:"eraseAt"`
+}
+
+// Delete deletes the account of the wallet of the proof token, its sessions are revoked right away
+func (ct AccountController) Delete(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+
+	if getContextValue[string](c, ContextKey_WalletAddress) != walletAddress {
+		return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
+	}
+
+	res, err := deleteAccount(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), walletAddress,
+		AccountDeletedBy_Wallet, ct.Config.AccountDeletionGracePeriod, ct.Config.WalletAddressPepper,
+	)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusAccepted, res))
+}
+
+type AccountController_ScheduleDeletionRequest struct {
+	// Erases the account right away instead of at the end of the grace period
+	Immediate bool `json:"immediate"`
+}
+
+type AccountController_ScheduleDeletionResponse = AccountController_DeleteResponse
+
+// ScheduleDeletion deletes an account from the backend of the company, e.g. to handle an erasure request received by other means
+func (ct AccountController) ScheduleDeletion(c echo.Context) error {
+	req, err := bindAndValidate[AccountController_ScheduleDeletionRequest](c)
+	if err != nil {
+		return err
+	}
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+	gracePeriod := ct.Config.AccountDeletionGracePeriod
+	if req.Immediate {
+		gracePeriod = 0
+	}
+
+	res, err := deleteAccount(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), walletAddress,
+		AccountDeletedBy_ApiKey, gracePeriod, ct.Config.WalletAddressPepper,
+	)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusAccepted, res))
+}
+
+// CancelDeletion restores a deleted account which has not been erased yet, its revoked sessions stay revoked
+func (ct AccountController) CancelDeletion(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+
+	res, err := ct.DB.ExecContext(c.Request().Context(),
+		`UPDATE accounts SET deleted_at = NULL, deleted_by = NULL, erase_at = NULL
+		WHERE company_id = ? AND wallet_address = ? AND deleted_at IS NOT NULL`,
+		getContextValue[uint](c, ContextKey_CompanyId), walletAddress,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to cancel account deletion: %w", err)
+	}
+	if restored, err := res.RowsAffected(); err != nil || restored == 0 {
+		return ErrNotFound
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+// deleteAccount hides the account and revokes its sessions, the account is erased once the grace period is over
+func deleteAccount(
+	ctx context.Context, db *sql.DB, companyId uint, walletAddress string, deletedBy AccountDeletedBy, gracePeriod time.Duration, pepper string,
+) (AccountController_DeleteResponse, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccountController_DeleteResponse{}, errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	deletion := AccountController_DeleteResponse{DeletedAt: now, EraseAt: now.Add(gracePeriod)}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE accounts SET deleted_at = ?, deleted_by = ?, erase_at = ?
+		WHERE company_id = ? AND wallet_address = ? AND deleted_at IS NULL`,
+		deletion.DeletedAt, deletedBy, deletion.EraseAt, companyId, walletAddress,
+	)
+	if err != nil {
+		return AccountController_DeleteResponse{}, errtrace.Errorf("failed to delete account: %w", err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return AccountController_DeleteResponse{}, ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = ? WHERE company_id = ? AND wallet_address = ? AND revoked_at IS NULL",
+		now, companyId, walletAddress,
+	)
+	if err != nil {
+		return AccountController_DeleteResponse{}, errtrace.Errorf("failed to revoke sessions: %w", err)
+	}
+	if gracePeriod <= 0 {
+		err = EraseAccount(ctx, tx, pepper, companyId, walletAddress)
+		if err != nil {
+			return AccountController_DeleteResponse{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return AccountController_DeleteResponse{}, errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+	return deletion, nil
+}
+
+// EraseAccount replaces a deleted account with a tombstone.
+// Revoked sessions of the wallet are kept until they expire, so that their proof tokens are still rejected.
+func EraseAccount(ctx context.Context, tx *sql.Tx, pepper string, companyId uint, walletAddress string) error {
+	var account entity.Account
+	err := sqlscan.Get(ctx, tx, &account,
+		`SELECT company_id, chain_namespace, wallet_address, created_at, deleted_at, deleted_by FROM accounts
+		WHERE company_id = ? AND wallet_address = ? AND deleted_at IS NOT NULL LIMIT 1`,
+		companyId, walletAddress,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to get deleted account: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO account_tombstones (company_id, chain_namespace, wallet_address_hash, account_created_at, deleted_at, deleted_by, erased_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		account.CompanyId, account.ChainNamespace, HashWalletAddress(pepper, account.WalletAddress), account.CreatedAt,
+		account.DeletedAt, account.DeletedBy, time.Now().UTC(),
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to create account tombstone: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM accounts WHERE company_id = ? AND wallet_address = ?", companyId, walletAddress,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to erase account: %w", err)
+	}
+	return nil
+}
+
+// HashWalletAddress is how erased accounts refer to their wallet, it is keyed by the pepper of the server so that it can't be reversed
+// by hashing known wallet addresses
+func HashWalletAddress(pepper string, walletAddress string) string {
+	return helper.Hmac(pepper, walletAddress)
+}
+
+// AccountResponse describes an account to the backend of its company
+type AccountResponse struct {
+	ChainNamespace string         `json:"chainNamespace"`
+	WalletAddress  string         `json:"walletAddress"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	Metadata       map[string]any `json:"metadata"`
+	// Version of the metadata schema which the metadata was last validated against, nil if it was never validated
+	MetadataSchemaVersion *uint `json:"metadataSchemaVersion"`
+	// Set while the account is deleted but not erased yet
+	DeletedAt *time.Time `json:"deletedAt"`
+	EraseAt   *time.Time `json:"eraseAt"`
+}
+
+// Accounts are listed from the oldest, metadata filters are passed as extra query params
+type AccountController_ListRequest struct {
+	Limit  uint   `query:"limit"`
+	Cursor string `query:"cursor"`
+	// RFC 3339 bounds of the creation time, the lower bound is inclusive and the upper bound exclusive
+	CreatedAfter   string `query:"createdAfter"`
+	CreatedBefore  string `query:"createdBefore"`
+	IncludeDeleted bool   `query:"includeDeleted"`
+	// Only accounts whose metadata was not validated against this metadata schema version or a later one, e.g. to migrate them
+	MetadataSchemaVersionBelow uint `query:"metadataSchemaVersionBelow"`
+	// Metadata field paths with the value they must be equal to
+	Metadata map[string]string `query:"-"`
+}
+
+type AccountController_ListResponse struct {
+	Accounts []AccountResponse `json:"accounts"`
+	// Cursor of the next page, empty on the last page
+	NextCursor string `json:"nextCursor"`
+}
+
+// List returns a page of the accounts of the company without the consent of their wallets, e.g. for support tooling
+func (ct AccountController) List(c echo.Context) error {
+	req, err := bindListAccountsRequest(c)
+	if err != nil {
+		return err
+	}
+	res, err := listAccounts(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type AccountController_GetResponse = AccountResponse
+
+// Get returns an account of the company, including accounts deleted but not erased yet
+func (ct AccountController) Get(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+	res, err := getAccount(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), walletAddress)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+func bindListAccountsRequest(c echo.Context) (AccountController_ListRequest, error) {
+	req, err := bindAndValidate[AccountController_ListRequest](c)
+	if err != nil {
+		return req, err
+	}
+	req.Metadata = map[string]string{}
+	for key, values := range c.QueryParams() {
+		if field, ok := strings.CutPrefix(key, AccountsMetadataFilterPrefix); ok && len(values) > 0 {
+			req.Metadata[field] = values[0]
+		}
+	}
+	return req, nil
+}
+
+// listAccounts returns a page of accounts ordered by creation time, the cursor is the last account of the previous page
+func listAccounts(ctx context.Context, db *sql.DB, companyId uint, req AccountController_ListRequest) (AccountController_ListResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = AccountsDefaultPageSize
+	}
+	if req.Limit > AccountsMaxPageSize {
+		return AccountController_ListResponse{}, NewHTTPError(http.StatusBadRequest, MsgAccountsQueryIsInvalid)
+	}
+
+	query := strings.Builder{}
+	query.WriteString("SELECT company_id, chain_namespace, wallet_address, created_at, metadata, metadata_schema_version, deleted_at, erase_at FROM accounts WHERE company_id = ?")
+	args := []any{companyId}
+	if !req.IncludeDeleted {
+		query.WriteString(" AND deleted_at IS NULL")
+	}
+	if req.MetadataSchemaVersionBelow > 0 {
+		query.WriteString(" AND (metadata_schema_version IS NULL OR metadata_schema_version < ?)")
+		args = append(args, req.MetadataSchemaVersionBelow)
+	}
+	for _, bound := range []struct {
+		value    string
+		operator string
+	}{{req.CreatedAfter, ">="}, {req.CreatedBefore, "<"}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return AccountController_ListResponse{}, NewHTTPError(http.StatusBadRequest, MsgAccountsQueryIsInvalid)
+		}
+		query.WriteString(" AND created_at " + bound.operator + " ?")
+		args = append(args, t.UTC().Format(sqliteTimestampFormat))
+	}
+	for field, value := range req.Metadata {
+		if !metadataFieldRegexp.MatchString(field) {
+			return AccountController_ListResponse{}, NewHTTPError(http.StatusBadRequest, MsgAccountsQueryIsInvalid)
+		}
+		// Strings match their value, numbers and booleans match their JSON representation
+		query.WriteString(" AND (metadata ->> ? = ? OR metadata -> ? = ?)")
+		args = append(args, "$."+field, value, "$."+field, value)
+	}
+	if req.Cursor != "" {
+		createdAt, walletAddress, err := decodeAccountsCursor(req.Cursor)
+		if err != nil {
+			return AccountController_ListResponse{}, err
+		}
+		query.WriteString(" AND (created_at > ? OR (created_at = ? AND wallet_address > ?))")
+		args = append(args, createdAt, createdAt, walletAddress)
+	}
+	query.WriteString(" ORDER BY created_at, wallet_address LIMIT ?")
+	args = append(args, req.Limit+1)
+
+	var accounts []entity.Account
+	err := sqlscan.Select(ctx, db, &accounts, query.String(), args...)
+	if err != nil {
+		return AccountController_ListResponse{}, errtrace.Errorf("failed to list accounts: %w", err)
+	}
+
+	res := AccountController_ListResponse{Accounts: make([]AccountResponse, 0, len(accounts))}
+	if uint(len(accounts)) > req.Limit {
+		accounts = accounts[:req.Limit]
+		last := accounts[len(accounts)-1]
+		res.NextCursor = encodeAccountsCursor(last.CreatedAt, last.WalletAddress)
+	}
+	for _, account := range accounts {
+		account, err := newAccountResponse(account)
+		if err != nil {
+			return AccountController_ListResponse{}, err
+		}
+		res.Accounts = append(res.Accounts, account)
+	}
+	return res, nil
+}
+
+// walletAddressParam normalizes the wallet address of the path like stored addresses, e.g. to the EIP-55 checksum of Ethereum addresses
+func walletAddressParam(c echo.Context, verifiers *verifier.Registry) (string, error) {
+	_, walletAddress, err := normalizeWalletAddress(verifiers, "", c.Param("walletAddress"))
+	return walletAddress, err
+}
+
+func getAccount(ctx context.Context, db *sql.DB, companyId uint, walletAddress string) (AccountResponse, error) {
+	var account entity.Account
+	err := sqlscan.Get(ctx, db, &account,
+		`SELECT company_id, chain_namespace, wallet_address, created_at, metadata, metadata_schema_version, deleted_at, erase_at FROM accounts
+		WHERE company_id = ? AND wallet_address = ? LIMIT 1`,
+		companyId, walletAddress,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return ErrNotFound
+			return AccountResponse{}, ErrNotFound
 		}
-		return errtrace.Errorf("failed to get account metadata: %w", err)
+		return AccountResponse{}, errtrace.Errorf("failed to get account: %w", err)
 	}
+	return newAccountResponse(account)
+}
+
+func newAccountResponse(account entity.Account) (AccountResponse, error) {
+	res := AccountResponse{
+		ChainNamespace:        account.ChainNamespace,
+		WalletAddress:         account.WalletAddress,
+		CreatedAt:             account.CreatedAt,
+		DeletedAt:             account.DeletedAt,
+		EraseAt:               account.EraseAt,
+		MetadataSchemaVersion: account.MetadataSchemaVersion,
+	}
+	if account.Metadata != nil {
+		metadata, err := decodeMetadata(account.Metadata)
+		if err != nil {
+			return AccountResponse{}, err
+		}
+		res.Metadata = metadata
+	}
+	return res, nil
+}
+
+// Cursors are opaque to clients, they hold the creation time and wallet address of the last account of a page
+func encodeAccountsCursor(createdAt time.Time, walletAddress string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(sqliteTimestampFormat) + "|" + walletAddress))
+}
 
+func decodeAccountsCursor(cursor string) (string, string, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", NewHTTPError(http.StatusBadRequest, MsgAccountsCursorIsInvalid)
+	}
+	createdAt, walletAddress, ok := strings.Cut(string(cursorBytes), "|")
+	if !ok {
+		return "", "", NewHTTPError(http.StatusBadRequest, MsgAccountsCursorIsInvalid)
+	}
+	if _, err := time.Parse(sqliteTimestampFormat, createdAt); err != nil {
+		return "", "", NewHTTPError(http.StatusBadRequest, MsgAccountsCursorIsInvalid)
+	}
+	return createdAt, walletAddress, nil
+}
+
+func getAccountMetadata(ctx context.Context, db sqlscan.Querier, companyId uint, walletAddress string) (entity.Account, error) {
+	var account entity.Account
+	err := sqlscan.Get(ctx, db, &account,
+		"SELECT metadata, metadata_version FROM accounts WHERE company_id = ? AND wallet_address = ? AND deleted_at IS NULL LIMIT 1",
+		companyId, walletAddress,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.Account{}, ErrNotFound
+		}
+		return entity.Account{}, errtrace.Errorf("failed to get account metadata: %w", err)
+	}
+	return account, nil
+}
+
+// decodeMetadata decodes the metadata column, accounts created without metadata have empty metadata
+func decodeMetadata(metadataBytes []byte) (map[string]any, error) {
 	metadata := map[string]any{}
-	err = json.Unmarshal(metadataBytes, &metadata)
+	if metadataBytes == nil {
+		return metadata, nil
+	}
+	err := decodeJson(metadataBytes, &metadata)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// isBase64MetadataRequest tells if the client opted in to the base64 metadata of older versions of the create request
+func isBase64MetadataRequest(c echo.Context) bool {
+	_, params, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return err == nil && params[MetadataEncodingParam] == MetadataEncoding_Base64
+}
+
+// readMetadataBody reads the body of a request carrying metadata, it is capped so that a large body is not read in full before the size limit
+// of the metadata is checked
+func (ct AccountController) readMetadataBody(c echo.Context) ([]byte, error) {
+	maxSize := int64(ct.Config.MetadataMaxSize) * MetadataBodySizeFactor
+	bodyBytes, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, NewHTTPError(http.StatusRequestEntityTooLarge, MsgMetadataIsTooLarge)
+		}
+		return nil, ErrBadRequest
+	}
+	return bodyBytes, nil
+}
+
+// checkMetadataLimits checks the size of the JSON encoding of the metadata and how deeply it is nested
+func checkMetadataLimits(config Config, metadataBytes []byte, metadata map[string]any) error {
+	if uint(len(metadataBytes)) > config.MetadataMaxSize {
+		return NewHTTPError(http.StatusRequestEntityTooLarge, MsgMetadataIsTooLarge)
+	}
+	if uint(json_ext.Depth(metadata)) > config.MetadataMaxDepth {
+		return NewHTTPError(http.StatusBadRequest, MsgMetadataIsTooDeep)
+	}
+	return nil
+}
+
+// decodeJson keeps numbers as json.Number so that large integers are not rounded when they are encoded again
+func decodeJson(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(v)
 	if err != nil {
-		return errtrace.Errorf("failed to unmarshal metadata: %w", err)
+		return errtrace.Wrap(err)
+	}
+	if decoder.More() {
+		return errtrace.New("unexpected data after json value")
 	}
+	return nil
+}
 
-	return c.JSON(http.StatusOK, AccountController_GetMetadataResponse{Metadata: metadata})
+// metadataETag returns the strong ETag of a metadata version
+func metadataETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// etagMatches checks an If-Match header against the current ETag, an empty header matches any version
+func etagMatches(ifMatch string, etag string) bool {
+	if ifMatch == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
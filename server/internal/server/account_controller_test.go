@@ -1,12 +1,15 @@
 package server_test
 
 import (
-	"gatekeeper/internal"
+	"encoding/json"
 	"gatekeeper/internal/server"
 	server_testing "gatekeeper/internal/server/testing"
 	"gatekeeper/pkg/echo_ext"
+	"gatekeeper/pkg/verifier"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,6 +25,7 @@ func TestAccountController_Create(t *testing.T) {
 	newProofToken := func(t *testing.T, i *do.Injector, walletAddress string) string {
 		return server_testing.GenerateProofToken(
 			t, i,
+			server_testing.CompanyId,
 			walletAddress,
 			time.Now().Add(time.Minute),
 		)
@@ -30,31 +34,112 @@ func TestAccountController_Create(t *testing.T) {
 	metadata := []byte("{\"email\": \"client@gatekeeper.com\"}")
 
 	newTest := func(testFn func(t *testing.T, i *do.Injector, s server.Server)) func(t *testing.T) {
-		i := internal.NewTestInjector(t)
-		s := server.NewServer(i, server.Config{Env: "test"})
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
 		return func(t *testing.T) { testFn(t, i, s) }
 	}
 	sendReq := func(t *testing.T, e *echo.Echo, proofToken string, walletAddress string, metadata []byte) *httptest.ResponseRecorder {
 		return echo_ext.SendTestRequest(
 			t, e, http.MethodPost, "/v1/accounts",
 			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": proofToken},
-			map[string]any{"walletAddress": walletAddress, "metadata": metadata},
+			map[string]any{"walletAddress": walletAddress, "metadata": json.RawMessage(metadata)},
 		)
 	}
+	getMetadata := func(t *testing.T, s server.Server, walletAddress string) string {
+		var metadata string
+		err := s.AccountCtrl.DB.QueryRow("SELECT metadata FROM accounts WHERE wallet_address = ?", walletAddress).Scan(&metadata)
+		require.NoError(t, err)
+		return metadata
+	}
 
 	t.Run("Success", newTest(
 		func(t *testing.T, i *do.Injector, s server.Server) {
 			res := sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, metadata)
 			require.Equal(t, http.StatusNoContent, res.Code)
+			assert.JSONEq(t, string(metadata), getMetadata(t, s, walletAddress))
+		},
+	))
+
+	t.Run("Base64Metadata", newTest(
+		func(t *testing.T, i *do.Injector, s server.Server) {
+			res := echo_ext.SendTestRequest(
+				t, s.Echo, http.MethodPost, "/v1/accounts",
+				map[string]string{
+					"Api-Key":              server_testing.ApiKey,
+					"Proof-Token":          newProofToken(t, i, walletAddress),
+					echo.HeaderContentType: "application/json; metadata=base64",
+				},
+				map[string]any{"walletAddress": walletAddress, "metadata": metadata},
+			)
+			require.Equal(t, http.StatusNoContent, res.Code)
+			assert.JSONEq(t, string(metadata), getMetadata(t, s, walletAddress))
+		},
+	))
+
+	t.Run("MetadataIsCompacted", newTest(
+		func(t *testing.T, i *do.Injector, s server.Server) {
+			// Raw JSON metadata is compacted by the test client, base64 metadata keeps its whitespace
+			res := echo_ext.SendTestRequest(
+				t, s.Echo, http.MethodPost, "/v1/accounts",
+				map[string]string{
+					"Api-Key":              server_testing.ApiKey,
+					"Proof-Token":          newProofToken(t, i, walletAddress),
+					echo.HeaderContentType: "application/json; metadata=base64",
+				},
+				map[string]any{"walletAddress": walletAddress, "metadata": []byte(`{"email": "client@gatekeeper.com"` + strings.Repeat(" ", 1024) + `}`)},
+			)
+			require.Equal(t, http.StatusNoContent, res.Code)
+			assert.Equal(t, `{"email":"client@gatekeeper.com"}`, getMetadata(t, s, walletAddress))
+		},
+	))
+
+	t.Run("SolanaWallet", newTest(
+		func(t *testing.T, i *do.Injector, s server.Server) {
+			solanaWalletAddress, _ := server_testing.GenerateSolanaWalletAddress(t)
+			res := sendReq(t, s.Echo, newProofToken(t, i, solanaWalletAddress), solanaWalletAddress, metadata)
+			require.Equal(t, http.StatusNoContent, res.Code)
+
+			var chainNamespace string
+			err := s.AccountCtrl.DB.QueryRow(
+				"SELECT chain_namespace FROM accounts WHERE wallet_address = ?", solanaWalletAddress,
+			).Scan(&chainNamespace)
+			require.NoError(t, err)
+			assert.Equal(t, string(verifier.ChainNamespace_Solana), chainNamespace)
 		},
 	))
 
 	t.Run("MetadataIsInvalid", newTest(
 		func(t *testing.T, i *do.Injector, s server.Server) {
-			res := sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, []byte("jiberish"))
+			// Base64 metadata is only accepted with the metadata=base64 Content-Type parameter
+			for _, metadata := range []string{`"jiberish"`, `[]`, `"eyJlbWFpbCI6ICJjbGllbnRAZ2F0ZWtlZXBlci5jb20ifQ=="`} {
+				res := sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, []byte(metadata))
+				require.Equal(t, http.StatusBadRequest, res.Code)
+				body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+				assert.Equal(t, server.MsgMetadataIsInvalid, body.Error)
+			}
+		},
+	))
+
+	t.Run("MetadataExceedsLimits", newTest(
+		func(t *testing.T, i *do.Injector, s server.Server) {
+			res := sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, []byte(`{"bio":"`+strings.Repeat("a", 1024)+`"}`))
+			require.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+			assert.Equal(t, server.MsgMetadataIsTooLarge, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+			res = sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, []byte(`{"a":{"b":{"c":{"d":{}}}}}`))
 			require.Equal(t, http.StatusBadRequest, res.Code)
-			body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
-			assert.Equal(t, server.MsgMetadataIsInvalid, body.Error)
+			assert.Equal(t, server.MsgMetadataIsTooDeep, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+			res = sendReq(t, s.Echo, newProofToken(t, i, walletAddress), walletAddress, []byte(`{"a":{"b":{"c":[1]}}}`))
+			require.Equal(t, http.StatusNoContent, res.Code)
+		},
+	))
+
+	t.Run("WalletAddressIsNormalized", newTest(
+		func(t *testing.T, i *do.Injector, s server.Server) {
+			res := sendReq(t, s.Echo, newProofToken(t, i, walletAddress), strings.ToLower(walletAddress), metadata)
+			require.Equal(t, http.StatusNoContent, res.Code)
+			assert.JSONEq(t, string(metadata), getMetadata(t, s, walletAddress))
 		},
 	))
 
@@ -77,3 +162,481 @@ func TestAccountController_Create(t *testing.T) {
 		},
 	))
 }
+
+func TestAccountController_Metadata(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, s server.Server, walletAddress string, headers map[string]string)) func(t *testing.T) {
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
+		account := server_testing.CreateAccount(t, i, server_testing.CompanyId, []byte(`{"email":"odor@gatekeeper.com","name":"Odor","id":9007199254740993}`))
+		headers := map[string]string{
+			"Api-Key":     server_testing.ApiKey,
+			"Proof-Token": server_testing.GenerateProofToken(t, i, server_testing.CompanyId, account.WalletAddress, time.Now().Add(time.Minute)),
+		}
+		return func(t *testing.T) { testFn(t, s, account.WalletAddress, headers) }
+	}
+	sendReq := func(t *testing.T, s server.Server, method string, walletAddress string, headers map[string]string, body any) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, method, "/v1/accounts/"+walletAddress+"/metadata", headers, body)
+	}
+	withHeader := func(headers map[string]string, key string, value string) map[string]string {
+		res := map[string]string{key: value}
+		for k, v := range headers {
+			res[k] = v
+		}
+		return res
+	}
+	requireMetadata := func(t *testing.T, res *httptest.ResponseRecorder, etag string, metadata map[string]any) {
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, etag, res.Header().Get("ETag"))
+		assert.Equal(t, metadata, echo_ext.ReadBody[server.AccountController_GetMetadataResponse](t, res.Body).Metadata)
+	}
+
+	t.Run("Patch", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		res := sendReq(t, s, http.MethodGet, walletAddress, headers, nil)
+		requireMetadata(t, res, `"1"`, map[string]any{"email": "odor@gatekeeper.com", "name": "Odor", "id": float64(9007199254740993)})
+
+		res = sendReq(t, s, http.MethodPatch, walletAddress, withHeader(headers, echo.HeaderContentType, "application/merge-patch+json"),
+			map[string]any{"email": "new@gatekeeper.com", "name": nil, "settings": map[string]any{"theme": "dark"}},
+		)
+		requireMetadata(t, res, `"2"`, map[string]any{"email": "new@gatekeeper.com", "id": float64(9007199254740993), "settings": map[string]any{"theme": "dark"}})
+
+		// Large integers are kept as is
+		var metadata string
+		err := s.AccountCtrl.DB.QueryRow("SELECT metadata FROM accounts WHERE wallet_address = ?", walletAddress).Scan(&metadata)
+		require.NoError(t, err)
+		assert.Contains(t, metadata, "9007199254740993")
+
+		res = sendReq(t, s, http.MethodGet, walletAddress, headers, nil)
+		requireMetadata(t, res, `"2"`, map[string]any{"email": "new@gatekeeper.com", "id": float64(9007199254740993), "settings": map[string]any{"theme": "dark"}})
+	}))
+
+	t.Run("Put", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		res := sendReq(t, s, http.MethodPut, walletAddress, withHeader(headers, "If-Match", `"1"`),
+			server.AccountController_PutMetadataRequest{Metadata: map[string]any{"name": "Odor"}},
+		)
+		requireMetadata(t, res, `"2"`, map[string]any{"name": "Odor"})
+
+		// Large integers are kept as is
+		res = sendReq(t, s, http.MethodPut, walletAddress, headers, json.RawMessage(`{"metadata": {"id": 9007199254740993}}`))
+		require.Equal(t, http.StatusOK, res.Code)
+		var metadata string
+		err := s.AccountCtrl.DB.QueryRow("SELECT metadata FROM accounts WHERE wallet_address = ?", walletAddress).Scan(&metadata)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":9007199254740993}`, metadata)
+	}))
+
+	t.Run("WalletAddressIsNormalized", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		res := sendReq(t, s, http.MethodPatch, strings.ToLower(walletAddress), headers, map[string]any{"name": "First"})
+		requireMetadata(t, res, `"2"`, map[string]any{"email": "odor@gatekeeper.com", "name": "First", "id": float64(9007199254740993)})
+
+		res = sendReq(t, s, http.MethodPut, strings.ToLower(walletAddress), headers,
+			server.AccountController_PutMetadataRequest{Metadata: map[string]any{"name": "Second"}},
+		)
+		requireMetadata(t, res, `"3"`, map[string]any{"name": "Second"})
+	}))
+
+	t.Run("IfMatch", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		res := sendReq(t, s, http.MethodPatch, walletAddress, withHeader(headers, "If-Match", `"1"`), map[string]any{"name": "First"})
+		requireMetadata(t, res, `"2"`, map[string]any{"email": "odor@gatekeeper.com", "name": "First", "id": float64(9007199254740993)})
+
+		// The second writer read the first version, its change is rejected
+		res = sendReq(t, s, http.MethodPatch, walletAddress, withHeader(headers, "If-Match", `"1"`), map[string]any{"name": "Second"})
+		require.Equal(t, http.StatusPreconditionFailed, res.Code)
+		assert.Equal(t, server.MsgMetadataVersionIsOutdated, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodPatch, walletAddress, withHeader(headers, "If-Match", `"1", "2"`), map[string]any{"name": "Second"})
+		assert.Equal(t, http.StatusOK, res.Code)
+		res = sendReq(t, s, http.MethodPatch, walletAddress, withHeader(headers, "If-Match", "*"), map[string]any{"name": "Third"})
+		assert.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("PatchIsInvalid", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		for _, patch := range []any{"jiberish", []string{"name"}, nil} {
+			res := sendReq(t, s, http.MethodPatch, walletAddress, headers, patch)
+			require.Equal(t, http.StatusBadRequest, res.Code)
+			assert.Equal(t, server.MsgMetadataIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+		}
+	}))
+
+	t.Run("MetadataExceedsLimits", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		// Patches are small but the patched metadata is not
+		for i := 0; i < 4; i++ {
+			res := sendReq(t, s, http.MethodPatch, walletAddress, headers, map[string]any{"bio" + strconv.Itoa(i): strings.Repeat("a", 200)})
+			require.Equal(t, http.StatusOK, res.Code)
+		}
+		res := sendReq(t, s, http.MethodPatch, walletAddress, headers, map[string]any{"bio4": strings.Repeat("a", 200)})
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+		assert.Equal(t, server.MsgMetadataIsTooLarge, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodPut, walletAddress, headers,
+			server.AccountController_PutMetadataRequest{Metadata: map[string]any{"a": []any{[]any{[]any{[]any{"b"}}}}}},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgMetadataIsTooDeep, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		// Bodies are not read past a multiple of the size limit
+		res = sendReq(t, s, http.MethodPatch, walletAddress, headers, map[string]any{"bio": strings.Repeat("a", 1024*server.MetadataBodySizeFactor)})
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+		assert.Equal(t, server.MsgMetadataIsTooLarge, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("ProofTokenWalletAddressDoesNotMatch", newTest(func(t *testing.T, s server.Server, walletAddress string, headers map[string]string) {
+		res := sendReq(t, s, http.MethodPatch, server_testing.WalletAddress, headers, map[string]any{"name": "Odor"})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgProofTokenIsInvalidOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+}
+
+func TestAccountController_Delete(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, i *do.Injector, s server.Server, walletAddress string)) func(t *testing.T) {
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
+		account := server_testing.CreateAccount(t, i, server_testing.CompanyId, []byte(`{"email":"odor@gatekeeper.com"}`))
+		return func(t *testing.T) { testFn(t, i, s, account.WalletAddress) }
+	}
+	walletHeaders := func(t *testing.T, i *do.Injector, walletAddress string) map[string]string {
+		return map[string]string{
+			"Api-Key":     server_testing.ApiKey,
+			"Proof-Token": server_testing.GenerateProofToken(t, i, server_testing.CompanyId, walletAddress, time.Now().Add(time.Minute)),
+		}
+	}
+	sendDeletionReq := func(t *testing.T, s server.Server, method string, walletAddress string, body any) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, method, "/v1/accounts/"+walletAddress+"/deletion",
+			map[string]string{"Api-Key": server_testing.ApiKey}, body,
+		)
+	}
+	getMetadata := func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/accounts/"+walletAddress+"/metadata", walletHeaders(t, i, walletAddress), nil)
+	}
+	countTombstones := func(t *testing.T, s server.Server, walletAddress string) int {
+		var count int
+		err := s.AccountCtrl.DB.QueryRow(
+			"SELECT COUNT(*) FROM account_tombstones WHERE wallet_address_hash = ?", server.HashWalletAddress(s.Config.WalletAddressPepper, walletAddress),
+		).Scan(&count)
+		require.NoError(t, err)
+		return count
+	}
+
+	t.Run("Wallet", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) {
+		_, err := s.AccountCtrl.DB.Exec(
+			"INSERT INTO sessions (company_id, wallet_address, expired_at) VALUES (?, ?, ?)",
+			server_testing.CompanyId, walletAddress, time.Now().Add(time.Hour),
+		)
+		require.NoError(t, err)
+
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodDelete, "/v1/accounts/"+walletAddress, walletHeaders(t, i, walletAddress), nil)
+		require.Equal(t, http.StatusAccepted, res.Code)
+		body := echo_ext.ReadBody[server.AccountController_DeleteResponse](t, res.Body)
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), body.EraseAt, 5*time.Second)
+
+		var revokedSessions int
+		err = s.AccountCtrl.DB.QueryRow(
+			"SELECT COUNT(*) FROM sessions WHERE wallet_address = ? AND revoked_at IS NOT NULL", walletAddress,
+		).Scan(&revokedSessions)
+		require.NoError(t, err)
+		assert.Equal(t, 1, revokedSessions)
+
+		// Deleted accounts are hidden until they are erased
+		assert.Equal(t, http.StatusNotFound, getMetadata(t, i, s, walletAddress).Code)
+		assert.Zero(t, countTombstones(t, s, walletAddress))
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/accounts", walletHeaders(t, i, walletAddress),
+			map[string]any{"walletAddress": walletAddress},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgAccountIsDeleted, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodDelete, "/v1/accounts/"+walletAddress, walletHeaders(t, i, walletAddress), nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("CancelDeletion", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) {
+		// Wallet addresses of the path are normalized
+		res := sendDeletionReq(t, s, http.MethodPost, strings.ToLower(walletAddress), server.AccountController_ScheduleDeletionRequest{})
+		require.Equal(t, http.StatusAccepted, res.Code)
+		assert.Equal(t, http.StatusNotFound, getMetadata(t, i, s, walletAddress).Code)
+
+		res = sendDeletionReq(t, s, http.MethodDelete, strings.ToLower(walletAddress), nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, http.StatusOK, getMetadata(t, i, s, walletAddress).Code)
+
+		res = sendDeletionReq(t, s, http.MethodDelete, walletAddress, nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("Erase", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) {
+		res := sendDeletionReq(t, s, http.MethodPost, walletAddress, server.AccountController_ScheduleDeletionRequest{Immediate: true})
+		require.Equal(t, http.StatusAccepted, res.Code)
+
+		var accountsCount int
+		err := s.AccountCtrl.DB.QueryRow("SELECT COUNT(*) FROM accounts WHERE wallet_address = ?", walletAddress).Scan(&accountsCount)
+		require.NoError(t, err)
+		assert.Zero(t, accountsCount)
+		assert.Equal(t, 1, countTombstones(t, s, walletAddress))
+
+		var deletedBy string
+		err = s.AccountCtrl.DB.QueryRow("SELECT deleted_by FROM account_tombstones").Scan(&deletedBy)
+		require.NoError(t, err)
+		assert.Equal(t, string(server.AccountDeletedBy_ApiKey), deletedBy)
+
+		// Erased accounts can't be restored but the wallet can create a new account
+		res = sendDeletionReq(t, s, http.MethodDelete, walletAddress, nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/accounts", walletHeaders(t, i, walletAddress),
+			map[string]any{"walletAddress": walletAddress},
+		)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+	}))
+
+	t.Run("ApiKeyScopeIsMissing", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) {
+		apiKey := server_testing.CreateApiKey(t, s.AccountCtrl.DB, server_testing.CompanyId, server.ApiKeyScope_AccountsWrite)
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/accounts/"+walletAddress+"/deletion",
+			map[string]string{"Api-Key": apiKey}, server.AccountController_ScheduleDeletionRequest{},
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	}))
+
+	t.Run("AccountDoesNotExist", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string) {
+		otherWalletAddress, _ := server_testing.GenerateWalletAddress(t)
+		res := sendDeletionReq(t, s, http.MethodPost, otherWalletAddress, server.AccountController_ScheduleDeletionRequest{})
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+}
+
+func TestAccountController_List(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		for _, account := range []struct {
+			walletAddress string
+			createdAt     string
+			metadata      string
+		}{
+			{"0x0000000000000000000000000000000000000001", "2024-01-01 00:00:00", `{"age":30,"verified":true}`},
+			{"0x0000000000000000000000000000000000000002", "2024-01-02 00:00:00", `{"profile":{"name":"Odor"}}`},
+			{"0x0000000000000000000000000000000000000003", "2024-01-02 00:00:00", `{"age":31}`},
+		} {
+			_, err := s.AccountCtrl.DB.Exec(
+				"INSERT INTO accounts (company_id, wallet_address, created_at, metadata) VALUES (?, ?, ?, ?)",
+				server_testing.CompanyId, account.walletAddress, account.createdAt, account.metadata,
+			)
+			require.NoError(t, err)
+		}
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	list := func(t *testing.T, s server.Server, query string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/accounts?"+query, map[string]string{"Api-Key": server_testing.ApiKey}, nil)
+	}
+	listWalletAddresses := func(t *testing.T, s server.Server, query string) ([]string, string) {
+		res := list(t, s, query)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.AccountController_ListResponse](t, res.Body)
+		walletAddresses := []string{}
+		for _, account := range body.Accounts {
+			walletAddresses = append(walletAddresses, account.WalletAddress)
+		}
+		return walletAddresses, body.NextCursor
+	}
+
+	t.Run("Pagination", newTest(func(t *testing.T, s server.Server) {
+		walletAddresses, cursor := listWalletAddresses(t, s, "limit=2")
+		assert.Equal(t, []string{"0x0000000000000000000000000000000000000001", "0x0000000000000000000000000000000000000002"}, walletAddresses)
+		require.NotEmpty(t, cursor)
+
+		walletAddresses, cursor = listWalletAddresses(t, s, "limit=2&cursor="+cursor)
+		assert.Equal(t, []string{"0x0000000000000000000000000000000000000003", server_testing.WalletAddress}, walletAddresses)
+		assert.Empty(t, cursor)
+	}))
+
+	t.Run("CreatedAt", newTest(func(t *testing.T, s server.Server) {
+		walletAddresses, _ := listWalletAddresses(t, s, "createdAfter=2024-01-02T00:00:00Z&createdBefore=2024-01-03T00:00:00Z")
+		assert.Equal(t, []string{"0x0000000000000000000000000000000000000002", "0x0000000000000000000000000000000000000003"}, walletAddresses)
+	}))
+
+	t.Run("Metadata", newTest(func(t *testing.T, s server.Server) {
+		for query, expected := range map[string][]string{
+			"metadata.email=odor@gatekeeper.com":      {server_testing.WalletAddress},
+			"metadata.age=31":                         {"0x0000000000000000000000000000000000000003"},
+			"metadata.verified=true":                  {"0x0000000000000000000000000000000000000001"},
+			"metadata.profile.name=Odor":              {"0x0000000000000000000000000000000000000002"},
+			"metadata.age=30&metadata.verified=false": {},
+		} {
+			walletAddresses, _ := listWalletAddresses(t, s, query)
+			assert.Equal(t, expected, walletAddresses, query)
+		}
+	}))
+
+	t.Run("Deleted", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.AccountCtrl.DB.Exec("UPDATE accounts SET deleted_at = ? WHERE wallet_address = ?", time.Now().UTC(), server_testing.WalletAddress)
+		require.NoError(t, err)
+
+		walletAddresses, _ := listWalletAddresses(t, s, "metadata.email=odor@gatekeeper.com")
+		assert.Empty(t, walletAddresses)
+		walletAddresses, _ = listWalletAddresses(t, s, "metadata.email=odor@gatekeeper.com&includeDeleted=true")
+		assert.Equal(t, []string{server_testing.WalletAddress}, walletAddresses)
+	}))
+
+	t.Run("QueryIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		for query, msg := range map[string]string{
+			"limit=1000":             server.MsgAccountsQueryIsInvalid,
+			"limit=-1":               http.StatusText(http.StatusBadRequest),
+			"createdAfter=yesterday": server.MsgAccountsQueryIsInvalid,
+			"metadata.$.email=x":     server.MsgAccountsQueryIsInvalid,
+			"cursor=jiberish":        server.MsgAccountsCursorIsInvalid,
+		} {
+			res := list(t, s, query)
+			require.Equal(t, http.StatusBadRequest, res.Code, query)
+			assert.Equal(t, msg, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error, query)
+		}
+	}))
+}
+
+func TestAccountController_Get(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	get := func(t *testing.T, s server.Server, apiKey string, walletAddress string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/accounts/"+walletAddress, map[string]string{"Api-Key": apiKey}, nil)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		res := get(t, s, server_testing.ApiKey, server_testing.WalletAddress)
+		require.Equal(t, http.StatusOK, res.Code)
+		account := echo_ext.ReadBody[server.AccountController_GetResponse](t, res.Body)
+		assert.Equal(t, "eip155", account.ChainNamespace)
+		assert.Equal(t, map[string]any{"email": "odor@gatekeeper.com"}, account.Metadata)
+		assert.Nil(t, account.DeletedAt)
+	}))
+
+	t.Run("WalletAddressIsNormalized", newTest(func(t *testing.T, s server.Server) {
+		res := get(t, s, server_testing.ApiKey, strings.ToLower(server_testing.WalletAddress))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, server_testing.WalletAddress, echo_ext.ReadBody[server.AccountController_GetResponse](t, res.Body).WalletAddress)
+	}))
+
+	t.Run("AccountDoesNotExist", newTest(func(t *testing.T, s server.Server) {
+		walletAddress, _ := server_testing.GenerateWalletAddress(t)
+		assert.Equal(t, http.StatusNotFound, get(t, s, server_testing.ApiKey, walletAddress).Code)
+	}))
+
+	t.Run("OtherCompany", newTest(func(t *testing.T, s server.Server) {
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.AccountCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.AccountCtrl.DB, otherCompanyId)
+
+		assert.Equal(t, http.StatusNotFound, get(t, s, otherApiKey, server_testing.WalletAddress).Code)
+	}))
+
+	t.Run("ApiKeyScopeIsMissing", newTest(func(t *testing.T, s server.Server) {
+		apiKey := server_testing.CreateApiKey(t, s.AccountCtrl.DB, server_testing.CompanyId, server.ApiKeyScope_AccountsWrite)
+		assert.Equal(t, http.StatusForbidden, get(t, s, apiKey, server_testing.WalletAddress).Code)
+	}))
+}
+
+func TestAccountController_MetadataSchema(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"email": {"type": "string", "format": "email"},
+			"age": {"type": "integer", "minimum": 0}
+		}
+	}`
+	newTest := func(testFn func(t *testing.T, i *do.Injector, s server.Server, walletAddress string, headers map[string]string)) func(t *testing.T) {
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
+		// Created before the company had a metadata schema
+		account := server_testing.CreateAccount(t, i, server_testing.CompanyId, []byte(`{"email":"odor@gatekeeper.com"}`))
+		headers := map[string]string{
+			"Api-Key":     server_testing.ApiKey,
+			"Proof-Token": server_testing.GenerateProofToken(t, i, server_testing.CompanyId, account.WalletAddress, time.Now().Add(time.Minute)),
+		}
+		return func(t *testing.T) { testFn(t, i, s, account.WalletAddress, headers) }
+	}
+	createSchema := func(t *testing.T, s server.Server, schema string) {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/admin/companies/1/metadata-schemas",
+			map[string]string{"Admin-Api-Key": server_testing.AdminApiKey},
+			server.AdminController_CreateMetadataSchemaRequest{Schema: []byte(schema)},
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+	}
+	requireValidationErrors := func(t *testing.T, res *httptest.ResponseRecorder, errs map[string][]string) {
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[map[string]map[string]map[string]string](t, res.Body)
+		require.Len(t, body["errors"], len(errs))
+		for field, keywords := range errs {
+			require.Contains(t, body["errors"], field)
+			for _, keyword := range keywords {
+				assert.Contains(t, body["errors"][field], keyword, field)
+			}
+		}
+	}
+	getAccount := func(t *testing.T, s server.Server, walletAddress string) server.AccountResponse {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/accounts/"+walletAddress, map[string]string{"Api-Key": server_testing.ApiKey}, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.AccountResponse](t, res.Body)
+	}
+
+	t.Run("Create", newTest(func(t *testing.T, i *do.Injector, s server.Server, _ string, _ map[string]string) {
+		createSchema(t, s, schema)
+		walletAddress, _ := server_testing.GenerateWalletAddress(t)
+		sendReq := func(metadata string) *httptest.ResponseRecorder {
+			return echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/accounts",
+				map[string]string{
+					"Api-Key":     server_testing.ApiKey,
+					"Proof-Token": server_testing.GenerateProofToken(t, i, server_testing.CompanyId, walletAddress, time.Now().Add(time.Minute)),
+				},
+				server.AccountController_CreateRequest{WalletAddress: walletAddress, Metadata: []byte(metadata)},
+			)
+		}
+
+		res := sendReq(`{"email":"jiberish","age":-1}`)
+		requireValidationErrors(t, res, map[string][]string{"metadata.email": {"format"}, "metadata.age": {"minimum"}})
+
+		res = sendReq(`{"email":"odor@gatekeeper.com","age":30}`)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, uint(1), *getAccount(t, s, walletAddress).MetadataSchemaVersion)
+	}))
+
+	t.Run("Update", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string, headers map[string]string) {
+		assert.Nil(t, getAccount(t, s, walletAddress).MetadataSchemaVersion)
+		createSchema(t, s, schema)
+
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPatch, "/v1/accounts/"+walletAddress+"/metadata", headers, map[string]any{"age": "30"})
+		requireValidationErrors(t, res, map[string][]string{"metadata.age": {"type"}})
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPatch, "/v1/accounts/"+walletAddress+"/metadata", headers, map[string]any{"age": 30})
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint(1), *getAccount(t, s, walletAddress).MetadataSchemaVersion)
+	}))
+
+	t.Run("Migrate", newTest(func(t *testing.T, i *do.Injector, s server.Server, walletAddress string, headers map[string]string) {
+		createSchema(t, s, schema)
+		createSchema(t, s, `{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}`)
+		listOutdated := func() []server.AccountResponse {
+			res := echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/accounts?metadataSchemaVersionBelow=3", map[string]string{"Api-Key": server_testing.ApiKey}, nil)
+			require.Equal(t, http.StatusOK, res.Code)
+			return echo_ext.ReadBody[server.AccountController_ListResponse](t, res.Body).Accounts
+		}
+		migrate := func(body any) *httptest.ResponseRecorder {
+			return echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/accounts/"+walletAddress+"/metadata/migrate",
+				map[string]string{"Api-Key": server_testing.ApiKey}, body,
+			)
+		}
+		require.Len(t, listOutdated(), 2)
+
+		// Current metadata does not conform to the latest version
+		res := migrate(nil)
+		requireValidationErrors(t, res, map[string][]string{"metadata": {"required"}})
+
+		createSchema(t, s, `{"type": "object", "properties": {"name": {"type": "string"}}}`)
+		// Metadata can't be replaced without the consent of the wallet
+		res = migrate(map[string]any{"metadata": map[string]any{"name": "Odor"}})
+		require.Equal(t, http.StatusOK, res.Code)
+		metadata := echo_ext.ReadBody[server.AccountController_MigrateMetadataResponse](t, res.Body).Metadata
+		assert.NotContains(t, metadata, "name")
+		assert.Equal(t, uint(3), *getAccount(t, s, walletAddress).MetadataSchemaVersion)
+
+		outdated := listOutdated()
+		require.Len(t, outdated, 1)
+		assert.Equal(t, server_testing.WalletAddress, outdated[0].WalletAddress)
+	}))
+}
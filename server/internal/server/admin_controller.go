@@ -0,0 +1,763 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/verifier"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+// Lifetimes in seconds of companies that don't set their own, same as the column defaults
+const (
+	CompanyDefaultProofTokenLifetime   uint = 300
+	CompanyDefaultRefreshTokenLifetime uint = 7 * 24 * 60 * 60
+	CompanyDefaultSessionLifetime      uint = 30 * 24 * 60 * 60
+)
+
+const (
+	MsgRedirectUriIsInvalid     = "Redirect uri is invalid"
+	MsgAllowedOriginIsInvalid   = "Allowed origin is invalid"
+	MsgCompaniesQueryIsInvalid  = "Companies query is invalid"
+	MsgCompaniesCursorIsInvalid = "Companies cursor is invalid"
+)
+
+// Number of companies returned by a list request, unless it sets its own limit
+const (
+	CompaniesDefaultPageSize uint = 50
+	CompaniesMaxPageSize     uint = 100
+)
+
+// AdminController is used by Gatekeeper operators to onboard companies and their owners, owners then manage their company through the owner api
+type AdminController struct {
+	Config    Config
+	DB        *sql.DB
+	Verifiers *verifier.Registry
+}
+
+func NewAdminController(echoGrp *echo.Group, i *do.Injector) AdminController {
+	ct := AdminController{
+		Config:    do.MustInvoke[Config](i),
+		DB:        do.MustInvoke[*sql.DB](i),
+		Verifiers: do.MustInvoke[*verifier.Registry](i),
+	}
+
+	companies := echoGrp.Group("/admin/companies", NewAdminApiKeyMiddleware(i))
+	companies.POST("", ct.CreateCompany)
+	companies.GET("", ct.ListCompanies)
+	companies.GET("/:companyId", ct.GetCompany)
+	companies.PUT("/:companyId", ct.UpdateCompany)
+	companies.DELETE("/:companyId", ct.DeleteCompany)
+	companies.GET("/:companyId/api-keys", ct.ListApiKeys)
+	companies.POST("/:companyId/api-keys", ct.CreateApiKey)
+	companies.DELETE("/:companyId/api-keys/:apiKeyId", ct.RevokeApiKey)
+	companies.GET("/:companyId/owners", ct.ListOwners)
+	companies.POST("/:companyId/owners", ct.AddOwner)
+	companies.DELETE("/:companyId/owners/:walletAddress", ct.RemoveOwner)
+	companies.GET("/:companyId/metadata-schemas", ct.ListMetadataSchemas)
+	companies.POST("/:companyId/metadata-schemas", ct.CreateMetadataSchema)
+
+	return ct
+}
+
+// Settings of a company, empty values are saved as null and lifetimes of 0 fall back to the defaults
+type AdminController_CompanyRequest struct {
+	Name                 string   `json:"name" validate:"maxLen:255"`
+	Domain               string   `json:"domain" validate:"maxLen:255"`
+	Uri                  string   `json:"uri" validate:"maxLen:255"`
+	LogoUrl              string   `json:"logoUrl" validate:"fullUrl|maxLen:2048"`
+	PrimaryColor         string   `json:"primaryColor" validate:"regex:^#[0-9a-fA-F]{6}$"`
+	BackgroundColor      string   `json:"backgroundColor" validate:"regex:^#[0-9a-fA-F]{6}$"`
+	ProofTokenLifetime   uint     `json:"proofTokenLifetime"`
+	RefreshTokenLifetime uint     `json:"refreshTokenLifetime"`
+	SessionLifetime      uint     `json:"sessionLifetime"`
+	RedirectUris         []string `json:"redirectUris" validate:"-"`
+	// Origins of the pages allowed to use the publishable api keys of the company, e.g. https://odor.com
+	AllowedOrigins []string `json:"allowedOrigins" validate:"-"`
+	// Lets devices sign in with the public client id of the company (RFC 8628), disabled by default
+	DeviceFlowEnabled bool `json:"deviceFlowEnabled"`
+}
+
+type AdminController_CompanyResponse struct {
+	Id                   uint      `json:"id"`
+	CreatedAt            time.Time `json:"createdAt"`
+	Name                 *string   `json:"name"`
+	Domain               *string   `json:"domain"`
+	Uri                  *string   `json:"uri"`
+	LogoUrl              *string   `json:"logoUrl"`
+	PrimaryColor         *string   `json:"primaryColor"`
+	BackgroundColor      *string   `json:"backgroundColor"`
+	ProofTokenLifetime   uint      `json:"proofTokenLifetime"`
+	RefreshTokenLifetime uint      `json:"refreshTokenLifetime"`
+	SessionLifetime      uint      `json:"sessionLifetime"`
+	RedirectUris         []string  `json:"redirectUris"`
+	AllowedOrigins       []string  `json:"allowedOrigins"`
+	DeviceFlowEnabled    bool      `json:"deviceFlowEnabled"`
+	// Wallet addresses of the owners
+	Owners        []string `json:"owners"`
+	AccountsCount int64    `json:"accountsCount"`
+	// First api key of the company with all scopes, only returned when the company is created
+	ApiKey string `json:"apiKey,omitempty"`
+}
+
+type AdminController_CreateCompanyRequest = AdminController_CompanyRequest
+
+type AdminController_CreateCompanyResponse = AdminController_CompanyResponse
+
+func (ct AdminController) CreateCompany(c echo.Context) error {
+	req, err := bindAndValidate[AdminController_CreateCompanyRequest](c)
+	if err != nil {
+		return err
+	}
+	err = validateRedirectUris(req.RedirectUris)
+	if err != nil {
+		return err
+	}
+	allowedOrigins, err := normalizeAllowedOrigins(req.AllowedOrigins)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO companies (name, domain, uri, logo_url, primary_color, background_color, proof_token_lifetime, refresh_token_lifetime, session_lifetime,
+		device_flow_enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullIfEmpty(req.Name), nullIfEmpty(req.Domain), nullIfEmpty(req.Uri), nullIfEmpty(req.LogoUrl),
+		nullIfEmpty(req.PrimaryColor), nullIfEmpty(req.BackgroundColor),
+		defaultIfZero(req.ProofTokenLifetime, CompanyDefaultProofTokenLifetime),
+		defaultIfZero(req.RefreshTokenLifetime, CompanyDefaultRefreshTokenLifetime),
+		defaultIfZero(req.SessionLifetime, CompanyDefaultSessionLifetime),
+		req.DeviceFlowEnabled,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to create company: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return errtrace.Errorf("failed to get company id: %w", err)
+	}
+	companyId := uint(id)
+	err = saveRedirectUris(ctx, tx, companyId, req.RedirectUris)
+	if err != nil {
+		return err
+	}
+	err = saveAllowedOrigins(ctx, tx, companyId, allowedOrigins)
+	if err != nil {
+		return err
+	}
+	apiKey, err := createApiKey(ctx, tx, ct.Config.ApiKeyPepper, companyId, ApiKeyController_CreateRequest{Label: DefaultApiKeyLabel, Scopes: ApiKeyScopes})
+	if err != nil {
+		return err
+	}
+
+	company, err := getCompanyResponse(ctx, tx, companyId)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	company.ApiKey = apiKey.ApiKey
+	return errtrace.Wrap(c.JSON(http.StatusCreated, company))
+}
+
+type AdminController_ListCompaniesRequest struct {
+	Limit  uint   `query:"limit"`
+	Cursor string `query:"cursor"`
+}
+
+type AdminController_ListCompaniesResponse struct {
+	Companies []AdminController_CompanyResponse `json:"companies"`
+	// Cursor of the next page, empty on the last page
+	NextCursor string `json:"nextCursor"`
+}
+
+// ListCompanies returns a page of the companies ordered by id, the cursor is the last company of the previous page
+func (ct AdminController) ListCompanies(c echo.Context) error {
+	req, err := bindAndValidate[AdminController_ListCompaniesRequest](c)
+	if err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = CompaniesDefaultPageSize
+	}
+	if req.Limit > CompaniesMaxPageSize {
+		return NewHTTPError(http.StatusBadRequest, MsgCompaniesQueryIsInvalid)
+	}
+	var after uint64
+	if req.Cursor != "" {
+		after, err = decodeCompaniesCursor(req.Cursor)
+		if err != nil {
+			return err
+		}
+	}
+	ctx := c.Request().Context()
+
+	var companies []entity.Company
+	err = sqlscan.Select(ctx, ct.DB, &companies,
+		`SELECT id, created_at, name, domain, uri, logo_url, primary_color, background_color,
+		proof_token_lifetime, refresh_token_lifetime, session_lifetime, device_flow_enabled FROM companies
+		WHERE id > ? ORDER BY id LIMIT ?`,
+		after, req.Limit+1,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to list companies: %w", err)
+	}
+
+	res := AdminController_ListCompaniesResponse{}
+	if uint(len(companies)) > req.Limit {
+		companies = companies[:req.Limit]
+		res.NextCursor = encodeCompaniesCursor(companies[len(companies)-1].Id)
+	}
+	res.Companies, err = newCompanyResponses(ctx, ct.DB, companies)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type AdminController_GetCompanyResponse = AdminController_CompanyResponse
+
+func (ct AdminController) GetCompany(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	company, err := getCompanyResponse(c.Request().Context(), ct.DB, companyId)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, company))
+}
+
+type AdminController_UpdateCompanyRequest = AdminController_CompanyRequest
+
+type AdminController_UpdateCompanyResponse = AdminController_CompanyResponse
+
+// UpdateCompany replaces all settings of the company, including its redirect uris and allowed origins
+func (ct AdminController) UpdateCompany(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[AdminController_UpdateCompanyRequest](c)
+	if err != nil {
+		return err
+	}
+	company, err := updateCompany(c.Request().Context(), ct.DB, companyId, req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, company))
+}
+
+// DeleteCompany deletes the company with its accounts, sessions and pending requests
+func (ct AdminController) DeleteCompany(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE company_id = ?)",
+		"DELETE FROM sessions WHERE company_id = ?",
+		"DELETE FROM challenges WHERE company_id = ?",
+		"DELETE FROM authorization_requests WHERE company_id = ?",
+		"DELETE FROM redirect_uris WHERE company_id = ?",
+		"DELETE FROM allowed_origins WHERE company_id = ?",
+		"DELETE FROM company_owners WHERE company_id = ?",
+		"DELETE FROM api_keys WHERE company_id = ?",
+		"DELETE FROM accounts WHERE company_id = ?",
+		"DELETE FROM account_tombstones WHERE company_id = ?",
+		"DELETE FROM metadata_schemas WHERE company_id = ?",
+	} {
+		_, err = tx.ExecContext(ctx, query, companyId)
+		if err != nil {
+			return errtrace.Errorf("failed to delete company data (id: %d): %w", companyId, err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM companies WHERE id = ?", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to delete company (id: %d): %w", companyId, err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return ErrNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+	compiledMetadataSchemas.Delete(companyId)
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type AdminController_ListApiKeysResponse = ApiKeyController_ListResponse
+
+func (ct AdminController) ListApiKeys(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	var exists bool
+	err = sqlscan.Get(ctx, ct.DB, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	apiKeys, err := listApiKeys(ctx, ct.DB, companyId)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, AdminController_ListApiKeysResponse{ApiKeys: apiKeys}))
+}
+
+type AdminController_CreateApiKeyRequest = ApiKeyController_CreateRequest
+
+type AdminController_CreateApiKeyResponse = ApiKeyController_CreateResponse
+
+func (ct AdminController) CreateApiKey(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[AdminController_CreateApiKeyRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = sqlscan.Get(ctx, tx, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	apiKey, err := createApiKey(ctx, tx, ct.Config.ApiKeyPepper, companyId, req)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, apiKey))
+}
+
+func (ct AdminController) RevokeApiKey(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	apiKeyId, err := strconv.ParseUint(c.Param("apiKeyId"), 10, 0)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = revokeApiKey(c.Request().Context(), ct.DB, companyId, uint(apiKeyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+// updateCompany replaces the settings of the company with its redirect uris and allowed origins
+func updateCompany(ctx context.Context, db *sql.DB, companyId uint, req AdminController_CompanyRequest) (AdminController_CompanyResponse, error) {
+	err := validateRedirectUris(req.RedirectUris)
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+	allowedOrigins, err := normalizeAllowedOrigins(req.AllowedOrigins)
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE companies SET name = ?, domain = ?, uri = ?, logo_url = ?, primary_color = ?, background_color = ?,
+		proof_token_lifetime = ?, refresh_token_lifetime = ?, session_lifetime = ?, device_flow_enabled = ? WHERE id = ?`,
+		nullIfEmpty(req.Name), nullIfEmpty(req.Domain), nullIfEmpty(req.Uri), nullIfEmpty(req.LogoUrl),
+		nullIfEmpty(req.PrimaryColor), nullIfEmpty(req.BackgroundColor),
+		defaultIfZero(req.ProofTokenLifetime, CompanyDefaultProofTokenLifetime),
+		defaultIfZero(req.RefreshTokenLifetime, CompanyDefaultRefreshTokenLifetime),
+		defaultIfZero(req.SessionLifetime, CompanyDefaultSessionLifetime),
+		req.DeviceFlowEnabled, companyId,
+	)
+	if err != nil {
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to update company (id: %d): %w", companyId, err)
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return AdminController_CompanyResponse{}, ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM redirect_uris WHERE company_id = ?", companyId)
+	if err != nil {
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to delete redirect uris: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM allowed_origins WHERE company_id = ?", companyId)
+	if err != nil {
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to delete allowed origins: %w", err)
+	}
+	err = saveRedirectUris(ctx, tx, companyId, req.RedirectUris)
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+	err = saveAllowedOrigins(ctx, tx, companyId, allowedOrigins)
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+
+	company, err := getCompanyResponse(ctx, tx, companyId)
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return company, nil
+}
+
+type AdminController_ListOwnersResponse = OwnerController_ListOwnersResponse
+
+func (ct AdminController) ListOwners(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	var exists bool
+	err = sqlscan.Get(ctx, ct.DB, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	owners, err := listCompanyOwners(ctx, ct.DB, companyId)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, AdminController_ListOwnersResponse{Owners: owners}))
+}
+
+type AdminController_AddOwnerRequest = OwnerController_AddOwnerRequest
+
+type AdminController_AddOwnerResponse = CompanyOwnerResponse
+
+// AddOwner lets the wallet manage the company, it is how operators hand over a new company
+func (ct AdminController) AddOwner(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[AdminController_AddOwnerRequest](c)
+	if err != nil {
+		return err
+	}
+	owner, err := addCompanyOwner(c.Request().Context(), ct.DB, ct.Verifiers, companyId, req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, owner))
+}
+
+func (ct AdminController) RemoveOwner(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+
+	err = removeCompanyOwner(c.Request().Context(), ct.DB, ct.Verifiers, companyId, c.Param("walletAddress"))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type AdminController_ListMetadataSchemasResponse = MetadataSchemasResponse
+
+func (ct AdminController) ListMetadataSchemas(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	var exists bool
+	err = sqlscan.Get(ctx, ct.DB, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	schemas, err := listMetadataSchemas(ctx, ct.DB, companyId)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, AdminController_ListMetadataSchemasResponse{MetadataSchemas: schemas}))
+}
+
+type AdminController_CreateMetadataSchemaRequest = MetadataSchemaRequest
+
+type AdminController_CreateMetadataSchemaResponse = MetadataSchemaResponse
+
+func (ct AdminController) CreateMetadataSchema(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[AdminController_CreateMetadataSchemaRequest](c)
+	if err != nil {
+		return err
+	}
+	schema, err := createMetadataSchema(c.Request().Context(), ct.DB, companyId, req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, schema))
+}
+
+func parseCompanyIdParam(c echo.Context) (uint, error) {
+	companyId, err := strconv.ParseUint(c.Param("companyId"), 10, 0)
+	if err != nil || companyId == 0 {
+		return 0, ErrNotFound
+	}
+	return uint(companyId), nil
+}
+
+// getCompanyResponse returns the settings of the company with its redirect uris, allowed origins, owners and number of accounts
+func getCompanyResponse(ctx context.Context, db sqlscan.Querier, companyId uint) (AdminController_CompanyResponse, error) {
+	var company entity.Company
+	err := sqlscan.Get(ctx, db, &company,
+		`SELECT id, created_at, name, domain, uri, logo_url, primary_color, background_color,
+		proof_token_lifetime, refresh_token_lifetime, session_lifetime, device_flow_enabled FROM companies WHERE id = ?`,
+		companyId,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AdminController_CompanyResponse{}, ErrNotFound
+		}
+		return AdminController_CompanyResponse{}, errtrace.Errorf("failed to get company (id: %d): %w", companyId, err)
+	}
+
+	res, err := newCompanyResponses(ctx, db, []entity.Company{company})
+	if err != nil {
+		return AdminController_CompanyResponse{}, err
+	}
+	return res[0], nil
+}
+
+// newCompanyResponses adds the settings stored in other tables to companies ordered by id,
+// every table is queried once for the range of ids of the companies
+func newCompanyResponses(ctx context.Context, db sqlscan.Querier, companies []entity.Company) ([]AdminController_CompanyResponse, error) {
+	res := make([]AdminController_CompanyResponse, 0, len(companies))
+	if len(companies) == 0 {
+		return res, nil
+	}
+	firstId, lastId := companies[0].Id, companies[len(companies)-1].Id
+
+	redirectUris, err := selectCompanyValues(ctx, db,
+		"SELECT company_id, uri AS value FROM redirect_uris WHERE company_id BETWEEN ? AND ? ORDER BY id", firstId, lastId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to get redirect uris: %w", err)
+	}
+	allowedOrigins, err := selectCompanyValues(ctx, db,
+		"SELECT company_id, origin AS value FROM allowed_origins WHERE company_id BETWEEN ? AND ? ORDER BY id", firstId, lastId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to get allowed origins: %w", err)
+	}
+	owners, err := selectCompanyValues(ctx, db,
+		"SELECT company_id, wallet_address AS value FROM company_owners WHERE company_id BETWEEN ? AND ? ORDER BY id", firstId, lastId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to get owners: %w", err)
+	}
+	var accountsCounts []struct {
+		CompanyId uint  `db:"company_id"`
+		Count     int64 `db:"count"`
+	}
+	err = sqlscan.Select(ctx, db, &accountsCounts,
+		"SELECT company_id, COUNT(*) AS count FROM accounts WHERE company_id BETWEEN ? AND ? GROUP BY company_id", firstId, lastId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to count accounts: %w", err)
+	}
+	accountsCountByCompanyId := make(map[uint]int64, len(accountsCounts))
+	for _, accountsCount := range accountsCounts {
+		accountsCountByCompanyId[accountsCount.CompanyId] = accountsCount.Count
+	}
+
+	for _, company := range companies {
+		res = append(res, AdminController_CompanyResponse{
+			Id:                   company.Id,
+			CreatedAt:            company.CreatedAt,
+			Name:                 company.Name,
+			Domain:               company.Domain,
+			Uri:                  company.URI,
+			LogoUrl:              company.LogoUrl,
+			PrimaryColor:         company.PrimaryColor,
+			BackgroundColor:      company.BackgroundColor,
+			ProofTokenLifetime:   company.ProofTokenLifetime,
+			RefreshTokenLifetime: company.RefreshTokenLifetime,
+			SessionLifetime:      company.SessionLifetime,
+			RedirectUris:         append([]string{}, redirectUris[company.Id]...),
+			AllowedOrigins:       append([]string{}, allowedOrigins[company.Id]...),
+			DeviceFlowEnabled:    company.DeviceFlowEnabled,
+			Owners:               append([]string{}, owners[company.Id]...),
+			AccountsCount:        accountsCountByCompanyId[company.Id],
+		})
+	}
+	return res, nil
+}
+
+// selectCompanyValues groups the values selected from a table of company settings by company id
+func selectCompanyValues(ctx context.Context, db sqlscan.Querier, query string, args ...any) (map[uint][]string, error) {
+	var rows []struct {
+		CompanyId uint   `db:"company_id"`
+		Value     string `db:"value"`
+	}
+	err := sqlscan.Select(ctx, db, &rows, query, args...)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	values := map[uint][]string{}
+	for _, row := range rows {
+		values[row.CompanyId] = append(values[row.CompanyId], row.Value)
+	}
+	return values, nil
+}
+
+func encodeCompaniesCursor(companyId uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(companyId), 10)))
+}
+
+func decodeCompaniesCursor(cursor string) (uint64, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, NewHTTPError(http.StatusBadRequest, MsgCompaniesCursorIsInvalid)
+	}
+	companyId, err := strconv.ParseUint(string(cursorBytes), 10, 64)
+	if err != nil {
+		return 0, NewHTTPError(http.StatusBadRequest, MsgCompaniesCursorIsInvalid)
+	}
+	return companyId, nil
+}
+
+// validateRedirectUris only accepts absolute uris, they are compared as is by the authorization endpoints
+func validateRedirectUris(redirectUris []string) error {
+	for _, redirectUri := range redirectUris {
+		u, err := url.Parse(redirectUri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || len(redirectUri) > 2048 {
+			return NewHTTPError(http.StatusBadRequest, MsgRedirectUriIsInvalid)
+		}
+	}
+	return nil
+}
+
+func saveRedirectUris(ctx context.Context, tx *sql.Tx, companyId uint, redirectUris []string) error {
+	for _, redirectUri := range redirectUris {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO redirect_uris (company_id, uri) VALUES (?, ?) ON CONFLICT (company_id, uri) DO NOTHING",
+			companyId, redirectUri,
+		)
+		if err != nil {
+			return errtrace.Errorf("failed to save redirect uri: %w", err)
+		}
+	}
+	return nil
+}
+
+// normalizeAllowedOrigins only accepts http(s) origins without path, in the lowercase form browsers send them
+func normalizeAllowedOrigins(allowedOrigins []string) ([]string, error) {
+	normalized := make([]string, 0, len(allowedOrigins))
+	for _, allowedOrigin := range allowedOrigins {
+		u, err := url.Parse(allowedOrigin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, NewHTTPError(http.StatusBadRequest, MsgAllowedOriginIsInvalid)
+		}
+		normalized = append(normalized, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return normalized, nil
+}
+
+func saveAllowedOrigins(ctx context.Context, tx *sql.Tx, companyId uint, allowedOrigins []string) error {
+	for _, allowedOrigin := range allowedOrigins {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO allowed_origins (company_id, origin) VALUES (?, ?) ON CONFLICT (company_id, origin) DO NOTHING",
+			companyId, allowedOrigin,
+		)
+		if err != nil {
+			return errtrace.Errorf("failed to save allowed origin: %w", err)
+		}
+	}
+	return nil
+}
+
+func defaultIfZero(v uint, defaultValue uint) uint {
+	if v == 0 {
+		return defaultValue
+	}
+	return v
+}
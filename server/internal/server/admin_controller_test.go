@@ -0,0 +1,229 @@
+package server_test
+
+import (
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminController(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	sendReq := func(t *testing.T, s server.Server, method string, path string, body any) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, method, "/v1/admin/companies"+path,
+			map[string]string{"Admin-Api-Key": server_testing.AdminApiKey}, body,
+		)
+	}
+	companyPath := func(companyId uint) string {
+		return "/" + strconv.FormatUint(uint64(companyId), 10)
+	}
+	createCompany := func(t *testing.T, s server.Server, req server.AdminController_CreateCompanyRequest) server.AdminController_CreateCompanyResponse {
+		res := sendReq(t, s, http.MethodPost, "", req)
+		require.Equal(t, http.StatusCreated, res.Code)
+		return echo_ext.ReadBody[server.AdminController_CreateCompanyResponse](t, res.Body)
+	}
+	requireApiKeyIsValid := func(t *testing.T, s server.Server, apiKey string, valid bool) {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": apiKey},
+			server.SessionController_RevokeRequest{WalletAddress: server_testing.WalletAddress},
+		)
+		if valid {
+			assert.Equal(t, http.StatusOK, res.Code)
+		} else {
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		}
+	}
+
+	t.Run("CreateCompany", newTest(func(t *testing.T, s server.Server) {
+		company := createCompany(t, s, server.AdminController_CreateCompanyRequest{
+			Name:               "Odor",
+			Domain:             "odor.com",
+			PrimaryColor:       "#ff0000",
+			ProofTokenLifetime: 60,
+			RedirectUris:       []string{"https://odor.com/callback"},
+			AllowedOrigins:     []string{"HTTPS://Odor.com/"},
+		})
+		assert.NotEqual(t, server_testing.CompanyId, company.Id)
+		assert.Equal(t, "Odor", *company.Name)
+		assert.Equal(t, "odor.com", *company.Domain)
+		assert.Nil(t, company.Uri)
+		assert.Equal(t, uint(60), company.ProofTokenLifetime)
+		assert.Equal(t, server.CompanyDefaultSessionLifetime, company.SessionLifetime)
+		assert.Equal(t, []string{"https://odor.com/callback"}, company.RedirectUris)
+		assert.Equal(t, []string{"https://odor.com"}, company.AllowedOrigins)
+		assert.False(t, company.DeviceFlowEnabled)
+		assert.Zero(t, company.AccountsCount)
+		requireApiKeyIsValid(t, s, company.ApiKey, true)
+
+		res := sendReq(t, s, http.MethodGet, companyPath(company.Id), nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.AdminController_GetCompanyResponse](t, res.Body)
+		assert.Equal(t, "Odor", *body.Name)
+		// Api keys are only shown once
+		assert.Empty(t, body.ApiKey)
+	}))
+
+	t.Run("CreateCompanyIsValidated", newTest(func(t *testing.T, s server.Server) {
+		res := sendReq(t, s, http.MethodPost, "", server.AdminController_CreateCompanyRequest{PrimaryColor: "red"})
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+
+		res = sendReq(t, s, http.MethodPost, "", server.AdminController_CreateCompanyRequest{RedirectUris: []string{"/callback"}})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgRedirectUriIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodPost, "", server.AdminController_CreateCompanyRequest{AllowedOrigins: []string{"https://odor.com/login"}})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgAllowedOriginIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("ListCompanies", newTest(func(t *testing.T, s server.Server) {
+		company := createCompany(t, s, server.AdminController_CreateCompanyRequest{Name: "Odor"})
+
+		res := sendReq(t, s, http.MethodGet, "", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		companies := echo_ext.ReadBody[server.AdminController_ListCompaniesResponse](t, res.Body).Companies
+		require.Len(t, companies, 2)
+		assert.Equal(t, server_testing.CompanyId, companies[0].Id)
+		assert.Equal(t, int64(1), companies[0].AccountsCount)
+		assert.Equal(t, []string{"http://localhost:4000/callback"}, companies[0].RedirectUris)
+		assert.Equal(t, company.Id, companies[1].Id)
+		assert.Equal(t, "Odor", *companies[1].Name)
+		assert.Empty(t, companies[1].RedirectUris)
+	}))
+
+	t.Run("ListCompaniesPagination", newTest(func(t *testing.T, s server.Server) {
+		company := createCompany(t, s, server.AdminController_CreateCompanyRequest{Name: "Odor"})
+
+		res := sendReq(t, s, http.MethodGet, "?limit=1", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.AdminController_ListCompaniesResponse](t, res.Body)
+		require.Len(t, body.Companies, 1)
+		assert.Equal(t, server_testing.CompanyId, body.Companies[0].Id)
+		require.NotEmpty(t, body.NextCursor)
+
+		res = sendReq(t, s, http.MethodGet, "?limit=1&cursor="+body.NextCursor, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		body = echo_ext.ReadBody[server.AdminController_ListCompaniesResponse](t, res.Body)
+		require.Len(t, body.Companies, 1)
+		assert.Equal(t, company.Id, body.Companies[0].Id)
+		assert.Empty(t, body.NextCursor)
+
+		for query, msg := range map[string]string{
+			"?limit=1000":      server.MsgCompaniesQueryIsInvalid,
+			"?cursor=jiberish": server.MsgCompaniesCursorIsInvalid,
+		} {
+			res := sendReq(t, s, http.MethodGet, query, nil)
+			require.Equal(t, http.StatusBadRequest, res.Code, query)
+			assert.Equal(t, msg, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error, query)
+		}
+	}))
+
+	t.Run("UpdateCompany", newTest(func(t *testing.T, s server.Server) {
+		res := sendReq(t, s, http.MethodPut, companyPath(server_testing.CompanyId), server.AdminController_UpdateCompanyRequest{
+			Name:              "Odor",
+			SessionLifetime:   3600,
+			RedirectUris:      []string{"https://odor.com/callback"},
+			DeviceFlowEnabled: true,
+		})
+		require.Equal(t, http.StatusOK, res.Code)
+		company := echo_ext.ReadBody[server.AdminController_UpdateCompanyResponse](t, res.Body)
+		assert.Equal(t, "Odor", *company.Name)
+		assert.Nil(t, company.Domain)
+		assert.Equal(t, uint(3600), company.SessionLifetime)
+		assert.Equal(t, server.CompanyDefaultProofTokenLifetime, company.ProofTokenLifetime)
+		assert.Equal(t, []string{"https://odor.com/callback"}, company.RedirectUris)
+		assert.True(t, company.DeviceFlowEnabled)
+	}))
+
+	t.Run("DeleteCompany", newTest(func(t *testing.T, s server.Server) {
+		res := sendReq(t, s, http.MethodDelete, companyPath(server_testing.CompanyId), nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, false)
+
+		var accountsCount int
+		err := s.AdminCtrl.DB.QueryRow("SELECT COUNT(*) FROM accounts WHERE company_id = ?", server_testing.CompanyId).Scan(&accountsCount)
+		require.NoError(t, err)
+		assert.Zero(t, accountsCount)
+
+		res = sendReq(t, s, http.MethodGet, companyPath(server_testing.CompanyId), nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("RotateApiKey", newTest(func(t *testing.T, s server.Server) {
+		res := sendReq(t, s, http.MethodPost, companyPath(server_testing.CompanyId)+"/api-keys", server.AdminController_CreateApiKeyRequest{Label: "Rotated", Scopes: server.ApiKeyScopes})
+		require.Equal(t, http.StatusCreated, res.Code)
+		apiKey := echo_ext.ReadBody[server.AdminController_CreateApiKeyResponse](t, res.Body)
+		assert.Equal(t, "Rotated", *apiKey.Label)
+
+		// Both keys work until the old one is revoked
+		requireApiKeyIsValid(t, s, apiKey.ApiKey, true)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+
+		res = sendReq(t, s, http.MethodGet, companyPath(server_testing.CompanyId)+"/api-keys", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		apiKeys := echo_ext.ReadBody[server.AdminController_ListApiKeysResponse](t, res.Body).ApiKeys
+		require.Len(t, apiKeys, 2)
+		oldApiKey := apiKeys[0]
+		assert.Equal(t, server_testing.ApiKey[:32], oldApiKey.Prefix)
+		assert.NotNil(t, oldApiKey.LastUsedAt)
+
+		res = sendReq(t, s, http.MethodDelete, companyPath(server_testing.CompanyId)+"/api-keys/"+strconv.FormatUint(uint64(oldApiKey.Id), 10), nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		requireApiKeyIsValid(t, s, apiKey.ApiKey, true)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, false)
+	}))
+
+	t.Run("Owners", newTest(func(t *testing.T, s server.Server) {
+		company := createCompany(t, s, server.AdminController_CreateCompanyRequest{Name: "Odor"})
+		assert.Empty(t, company.Owners)
+		ownerWalletAddress, _ := server_testing.GenerateWalletAddress(t)
+
+		res := sendReq(t, s, http.MethodPost, companyPath(company.Id)+"/owners", server.AdminController_AddOwnerRequest{WalletAddress: ownerWalletAddress})
+		require.Equal(t, http.StatusCreated, res.Code)
+
+		res = sendReq(t, s, http.MethodGet, companyPath(company.Id)+"/owners", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		owners := echo_ext.ReadBody[server.AdminController_ListOwnersResponse](t, res.Body).Owners
+		require.Len(t, owners, 1)
+		assert.Equal(t, ownerWalletAddress, owners[0].WalletAddress)
+
+		res = sendReq(t, s, http.MethodPost, companyPath(company.Id)+"/owners", server.AdminController_AddOwnerRequest{WalletAddress: "invalid"})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgWalletAddressIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodDelete, companyPath(company.Id)+"/owners/"+ownerWalletAddress, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	}))
+
+	t.Run("CompanyDoesNotExist", newTest(func(t *testing.T, s server.Server) {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			res := sendReq(t, s, method, companyPath(999), server.AdminController_UpdateCompanyRequest{})
+			assert.Equal(t, http.StatusNotFound, res.Code, method)
+		}
+		res := sendReq(t, s, http.MethodPost, companyPath(999)+"/api-keys", server.AdminController_CreateApiKeyRequest{Scopes: server.ApiKeyScopes})
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		res = sendReq(t, s, http.MethodGet, companyPath(999)+"/api-keys", nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		res = sendReq(t, s, http.MethodPost, companyPath(999)+"/owners", server.AdminController_AddOwnerRequest{WalletAddress: server_testing.WalletAddress})
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("AdminApiKeyIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		for _, apiKey := range []string{"", "invalid", server_testing.ApiKey} {
+			res := echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/v1/admin/companies",
+				map[string]string{"Admin-Api-Key": apiKey}, nil,
+			)
+			require.Equal(t, http.StatusUnauthorized, res.Code)
+			assert.Equal(t, server.MsgAdminApiKeyIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+		}
+	}))
+}
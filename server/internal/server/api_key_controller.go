@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/internal/helper"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+// Last use of api keys is saved at most once per minute to avoid a write on every request
+const ApiKeyLastUsedAtPrecision = time.Minute
+
+// Label of the api key created with a company
+const DefaultApiKeyLabel = "Default"
+
+// ApiKeyScope restricts the routes an api key can call
+type ApiKeyScope string
+
+const (
+	ApiKeyScope_ChallengesIssue  ApiKeyScope = "challenges:issue"
+	ApiKeyScope_ChallengesVerify ApiKeyScope = "challenges:verify"
+	ApiKeyScope_AccountsRead     ApiKeyScope = "accounts:read"
+	ApiKeyScope_AccountsWrite    ApiKeyScope = "accounts:write"
+	// Deletes accounts without the consent of their wallet, e.g. to handle erasure requests
+	ApiKeyScope_AccountsDelete   ApiKeyScope = "accounts:delete"
+	ApiKeyScope_SessionsWrite    ApiKeyScope = "sessions:write"
+	ApiKeyScope_TokensIntrospect ApiKeyScope = "tokens:introspect"
+	// Manages the company itself, e.g. its api keys
+	ApiKeyScope_Admin ApiKeyScope = "admin"
+)
+
+var ApiKeyScopes = []ApiKeyScope{
+	ApiKeyScope_ChallengesIssue,
+	ApiKeyScope_ChallengesVerify,
+	ApiKeyScope_AccountsRead,
+	ApiKeyScope_AccountsWrite,
+	ApiKeyScope_AccountsDelete,
+	ApiKeyScope_SessionsWrite,
+	ApiKeyScope_TokensIntrospect,
+	ApiKeyScope_Admin,
+}
+
+// Publishable keys are public, so they can only start sessions from the browser
+var PublishableApiKeyScopes = []ApiKeyScope{
+	ApiKeyScope_ChallengesIssue,
+	ApiKeyScope_ChallengesVerify,
+}
+
+const (
+	MsgApiKeyExpiryIsInvalid           = "Api key expiry must be in the future"
+	MsgApiKeyScopeIsInvalid            = "Api key scope is invalid"
+	MsgPublishableApiKeyScopeIsInvalid = "Publishable api keys can only issue and verify challenges"
+)
+
+// ApiKeyController lets companies manage their api keys, e.g. to rotate a key by creating a new one before revoking the old one
+type ApiKeyController struct {
+	Config Config
+	DB     *sql.DB
+}
+
+func NewApiKeyController(echoGrp *echo.Group, i *do.Injector) ApiKeyController {
+	ct := ApiKeyController{
+		Config: do.MustInvoke[Config](i),
+		DB:     do.MustInvoke[*sql.DB](i),
+	}
+
+	apiKeys := echoGrp.Group("/api-keys", NewApiKeyMiddleware(i, ApiKeyScope_Admin))
+	apiKeys.GET("", ct.List)
+	apiKeys.POST("", ct.Create)
+	apiKeys.DELETE("/:apiKeyId", ct.Revoke)
+
+	return ct
+}
+
+// ApiKeyResponse describes an api key, its secret is never returned after creation
+type ApiKeyResponse struct {
+	Id          uint          `json:"id"`
+	Prefix      string        `json:"prefix"`
+	Label       *string       `json:"label"`
+	Scopes      []ApiKeyScope `json:"scopes"`
+	Publishable bool          `json:"publishable"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	LastUsedAt  *time.Time    `json:"lastUsedAt"`
+	ExpiredAt   *time.Time    `json:"expiredAt"`
+	RevokedAt   *time.Time    `json:"revokedAt"`
+}
+
+type ApiKeyController_ListResponse struct {
+	ApiKeys []ApiKeyResponse `json:"apiKeys"`
+}
+
+func (ct ApiKeyController) List(c echo.Context) error {
+	apiKeys, err := listApiKeys(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, ApiKeyController_ListResponse{ApiKeys: apiKeys}))
+}
+
+type ApiKeyController_CreateRequest struct {
+	Label  string        `json:"label" validate:"maxLen:255"`
+	Scopes []ApiKeyScope `json:"scopes" validate:"required"`
+	// Publishable keys can be embedded in web pages, they only work from the allowed origins of the company
+	Publishable bool `json:"publishable"`
+	// Api key never expires when empty
+	ExpiredAt *time.Time `json:"expiredAt" validate:"-"`
+}
+
+type ApiKeyController_CreateResponse struct {
+	ApiKeyResponse
+	// Only returned once, it can't be recovered
+	ApiKey string `json:"apiKey"`
+}
+
+func (ct ApiKeyController) Create(c echo.Context) error {
+	req, err := bindAndValidate[ApiKeyController_CreateRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	apiKey, err := createApiKey(ctx, tx, ct.Config.ApiKeyPepper, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, apiKey))
+}
+
+// Revoke revokes an api key of the company, it is rejected right away
+func (ct ApiKeyController) Revoke(c echo.Context) error {
+	apiKeyId, err := strconv.ParseUint(c.Param("apiKeyId"), 10, 0)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = revokeApiKey(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), uint(apiKeyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+// authenticateApiKey returns the active api key matching the secret, or false if it does not exist, has expired or was revoked
+func authenticateApiKey(ctx context.Context, db *sql.DB, pepper string, apiKey string) (entity.ApiKey, bool, error) {
+	prefix, secret, ok := helper.SplitApiKey(apiKey)
+	if !ok {
+		return entity.ApiKey{}, false, nil
+	}
+
+	var key entity.ApiKey
+	err := sqlscan.Get(ctx, db, &key,
+		`SELECT id, company_id, secret_hash, legacy_secret, scopes, publishable, last_used_at, expired_at, revoked_at
+		FROM api_keys WHERE prefix = ?`, prefix,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ApiKey{}, false, nil
+		}
+		return entity.ApiKey{}, false, errtrace.Errorf("failed to get api key: %w", err)
+	}
+
+	switch {
+	case key.SecretHash != nil:
+		ok = subtle.ConstantTimeCompare([]byte(*key.SecretHash), []byte(helper.Hmac(pepper, secret))) == 1
+	case key.LegacySecret != nil:
+		ok = subtle.ConstantTimeCompare([]byte(*key.LegacySecret), []byte(secret)) == 1
+	default:
+		ok = false
+	}
+	now := time.Now().UTC()
+	if !ok || key.RevokedAt != nil || (key.ExpiredAt != nil && !key.ExpiredAt.After(now)) {
+		return entity.ApiKey{}, false, nil
+	}
+
+	// Legacy secrets left by a server which did not start yet are hashed on their first use
+	if key.LegacySecret != nil {
+		err = hashLegacyApiKey(ctx, db, pepper, key.Id, *key.LegacySecret)
+		if err != nil {
+			return entity.ApiKey{}, false, err
+		}
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= ApiKeyLastUsedAtPrecision {
+		_, err = db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.Id)
+		if err != nil {
+			return entity.ApiKey{}, false, errtrace.Errorf("failed to save api key last use: %w", err)
+		}
+	}
+
+	return key, true, nil
+}
+
+// HashLegacyApiKeys hashes the secrets of the api keys created before secrets were hashed, the server runs it at startup
+// as the migration of the api keys can only copy their secrets in plaintext
+func HashLegacyApiKeys(ctx context.Context, db *sql.DB, pepper string) error {
+	var apiKeys []entity.ApiKey
+	err := sqlscan.Select(ctx, db, &apiKeys, "SELECT id, legacy_secret FROM api_keys WHERE legacy_secret IS NOT NULL")
+	if err != nil {
+		return errtrace.Errorf("failed to get legacy api keys: %w", err)
+	}
+
+	for _, apiKey := range apiKeys {
+		err = hashLegacyApiKey(ctx, db, pepper, apiKey.Id, *apiKey.LegacySecret)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hashLegacyApiKey(ctx context.Context, db *sql.DB, pepper string, id uint, legacySecret string) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE api_keys SET secret_hash = ?, legacy_secret = NULL WHERE id = ? AND legacy_secret IS NOT NULL",
+		helper.Hmac(pepper, legacySecret), id,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to hash legacy api key (id: %d): %w", id, err)
+	}
+	return nil
+}
+
+// createApiKey creates an api key of the company and returns it with its secret
+func createApiKey(ctx context.Context, tx *sql.Tx, pepper string, companyId uint, req ApiKeyController_CreateRequest) (ApiKeyController_CreateResponse, error) {
+	if len(req.Scopes) == 0 {
+		return ApiKeyController_CreateResponse{}, NewHTTPError(http.StatusBadRequest, MsgApiKeyScopeIsInvalid)
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(ApiKeyScopes, scope) {
+			return ApiKeyController_CreateResponse{}, NewHTTPError(http.StatusBadRequest, MsgApiKeyScopeIsInvalid)
+		}
+		if req.Publishable && !slices.Contains(PublishableApiKeyScopes, scope) {
+			return ApiKeyController_CreateResponse{}, NewHTTPError(http.StatusBadRequest, MsgPublishableApiKeyScopeIsInvalid)
+		}
+	}
+	now := time.Now().UTC()
+	expiredAt := req.ExpiredAt
+	if expiredAt != nil {
+		if !expiredAt.After(now) {
+			return ApiKeyController_CreateResponse{}, NewHTTPError(http.StatusBadRequest, MsgApiKeyExpiryIsInvalid)
+		}
+		utc := expiredAt.UTC()
+		expiredAt = &utc
+	}
+
+	apiKey, err := helper.GenerateApiKey()
+	if err != nil {
+		return ApiKeyController_CreateResponse{}, errtrace.Errorf("failed to generate api key: %w", err)
+	}
+	prefix, secret, _ := helper.SplitApiKey(apiKey)
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO api_keys (company_id, prefix, secret_hash, label, scopes, publishable, created_at, expired_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		companyId, prefix, helper.Hmac(pepper, secret), nullIfEmpty(req.Label), formatApiKeyScopes(req.Scopes), req.Publishable, now, expiredAt,
+	)
+	if err != nil {
+		return ApiKeyController_CreateResponse{}, errtrace.Errorf("failed to create api key: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ApiKeyController_CreateResponse{}, errtrace.Errorf("failed to get api key id: %w", err)
+	}
+
+	return ApiKeyController_CreateResponse{
+		ApiKeyResponse: ApiKeyResponse{
+			Id:          uint(id),
+			Prefix:      prefix,
+			Label:       nullIfEmpty(req.Label),
+			Scopes:      parseApiKeyScopes(formatApiKeyScopes(req.Scopes)),
+			Publishable: req.Publishable,
+			CreatedAt:   now,
+			ExpiredAt:   expiredAt,
+		},
+		ApiKey: apiKey,
+	}, nil
+}
+
+func listApiKeys(ctx context.Context, db sqlscan.Querier, companyId uint) ([]ApiKeyResponse, error) {
+	var keys []entity.ApiKey
+	err := sqlscan.Select(ctx, db, &keys,
+		"SELECT id, prefix, label, scopes, publishable, created_at, last_used_at, expired_at, revoked_at FROM api_keys WHERE company_id = ? ORDER BY id",
+		companyId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to list api keys: %w", err)
+	}
+
+	apiKeys := make([]ApiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		apiKeys = append(apiKeys, ApiKeyResponse{
+			Id:          key.Id,
+			Prefix:      key.Prefix,
+			Label:       key.Label,
+			Scopes:      parseApiKeyScopes(key.Scopes),
+			Publishable: key.Publishable,
+			CreatedAt:   key.CreatedAt,
+			LastUsedAt:  key.LastUsedAt,
+			ExpiredAt:   key.ExpiredAt,
+			RevokedAt:   key.RevokedAt,
+		})
+	}
+	return apiKeys, nil
+}
+
+func revokeApiKey(ctx context.Context, db *sql.DB, companyId uint, apiKeyId uint) error {
+	res, err := db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND company_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), apiKeyId, companyId,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to revoke api key (id: %d): %w", apiKeyId, err)
+	}
+	if revoked, err := res.RowsAffected(); err != nil || revoked == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// hasApiKeyScope checks if the space separated scopes of an api key include the scope
+func hasApiKeyScope(scopes string, scope ApiKeyScope) bool {
+	return slices.Contains(parseApiKeyScopes(scopes), scope)
+}
+
+func parseApiKeyScopes(scopes string) []ApiKeyScope {
+	parsed := []ApiKeyScope{}
+	for _, scope := range strings.Fields(scopes) {
+		parsed = append(parsed, ApiKeyScope(scope))
+	}
+	return parsed
+}
+
+// formatApiKeyScopes removes duplicated scopes and sorts them in the order of ApiKeyScopes
+func formatApiKeyScopes(scopes []ApiKeyScope) string {
+	formatted := []string{}
+	for _, scope := range ApiKeyScopes {
+		if slices.Contains(scopes, scope) {
+			formatted = append(formatted, string(scope))
+		}
+	}
+	return strings.Join(formatted, " ")
+}
@@ -0,0 +1,170 @@
+package server_test
+
+import (
+	"context"
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKeyController(t *testing.T) {
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	sendReqWithApiKey := func(t *testing.T, s server.Server, apiKey string, method string, path string, body any) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, method, "/v1/api-keys"+path, map[string]string{"Api-Key": apiKey}, body)
+	}
+	sendReq := func(t *testing.T, s server.Server, method string, path string, body any) *httptest.ResponseRecorder {
+		return sendReqWithApiKey(t, s, server_testing.ApiKey, method, path, body)
+	}
+	createApiKey := func(t *testing.T, s server.Server, req server.ApiKeyController_CreateRequest) server.ApiKeyController_CreateResponse {
+		res := sendReq(t, s, http.MethodPost, "", req)
+		require.Equal(t, http.StatusCreated, res.Code)
+		return echo_ext.ReadBody[server.ApiKeyController_CreateResponse](t, res.Body)
+	}
+	requireApiKeyIsValid := func(t *testing.T, s server.Server, apiKey string, valid bool) {
+		res := sendReqWithApiKey(t, s, apiKey, http.MethodGet, "", nil)
+		if valid {
+			assert.Equal(t, http.StatusOK, res.Code)
+		} else {
+			require.Equal(t, http.StatusBadRequest, res.Code)
+			assert.Equal(t, server.MsgApiKeyIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+		}
+	}
+	apiKeyPath := func(apiKeyId uint) string {
+		return "/" + strconv.FormatUint(uint64(apiKeyId), 10)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		apiKey := createApiKey(t, s, server.ApiKeyController_CreateRequest{Label: "Billing", Scopes: []server.ApiKeyScope{server.ApiKeyScope_AccountsRead}})
+		assert.Equal(t, apiKey.ApiKey[:32], apiKey.Prefix)
+		assert.Equal(t, []server.ApiKeyScope{server.ApiKeyScope_AccountsRead}, apiKey.Scopes)
+
+		// Only api keys with the admin scope can manage api keys
+		res := sendReqWithApiKey(t, s, apiKey.ApiKey, http.MethodGet, "", nil)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		assert.Equal(t, server.MsgApiKeyScopeIsMissing, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodGet, "", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		apiKeys := echo_ext.ReadBody[server.ApiKeyController_ListResponse](t, res.Body).ApiKeys
+		require.Len(t, apiKeys, 2)
+		assert.Equal(t, server.ApiKeyScopes, apiKeys[0].Scopes)
+		assert.Equal(t, server.DefaultApiKeyLabel, *apiKeys[0].Label)
+		assert.Equal(t, "Billing", *apiKeys[1].Label)
+
+		// Secrets are only stored hashed
+		var secretHash string
+		err := s.ApiKeyCtrl.DB.QueryRow("SELECT secret_hash FROM api_keys WHERE id = ?", apiKey.Id).Scan(&secretHash)
+		require.NoError(t, err)
+		assert.NotContains(t, apiKey.ApiKey, secretHash)
+		assert.Len(t, secretHash, 64)
+	}))
+
+	t.Run("Revoke", newTest(func(t *testing.T, s server.Server) {
+		apiKey := createApiKey(t, s, server.ApiKeyController_CreateRequest{Scopes: server.ApiKeyScopes})
+
+		res := sendReq(t, s, http.MethodDelete, apiKeyPath(apiKey.Id), nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		requireApiKeyIsValid(t, s, apiKey.ApiKey, false)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+
+		res = sendReq(t, s, http.MethodDelete, apiKeyPath(apiKey.Id), nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("Expired", newTest(func(t *testing.T, s server.Server) {
+		expiredAt := time.Now().Add(time.Hour)
+		apiKey := createApiKey(t, s, server.ApiKeyController_CreateRequest{Scopes: server.ApiKeyScopes, ExpiredAt: &expiredAt})
+		requireApiKeyIsValid(t, s, apiKey.ApiKey, true)
+
+		_, err := s.ApiKeyCtrl.DB.Exec("UPDATE api_keys SET expired_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Minute), apiKey.Id)
+		require.NoError(t, err)
+		requireApiKeyIsValid(t, s, apiKey.ApiKey, false)
+	}))
+
+	t.Run("ExpiryIsInThePast", newTest(func(t *testing.T, s server.Server) {
+		expiredAt := time.Now().Add(-time.Hour)
+		res := sendReq(t, s, http.MethodPost, "", server.ApiKeyController_CreateRequest{Scopes: server.ApiKeyScopes, ExpiredAt: &expiredAt})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgApiKeyExpiryIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("ScopeIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		res := sendReq(t, s, http.MethodPost, "", server.ApiKeyController_CreateRequest{Scopes: []server.ApiKeyScope{"accounts:destroy"}})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgApiKeyScopeIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, http.MethodPost, "", server.ApiKeyController_CreateRequest{})
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	}))
+
+	t.Run("Publishable", newTest(func(t *testing.T, s server.Server) {
+		apiKey := createApiKey(t, s, server.ApiKeyController_CreateRequest{Scopes: server.PublishableApiKeyScopes, Publishable: true})
+		assert.True(t, apiKey.Publishable)
+
+		res := sendReq(t, s, http.MethodPost, "", server.ApiKeyController_CreateRequest{Scopes: server.ApiKeyScopes, Publishable: true})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgPublishableApiKeyScopeIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("SecretIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		requireApiKeyIsValid(t, s, server_testing.ApiKey[:32]+"invalid", false)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey[:32], false)
+	}))
+
+	t.Run("LegacySecret", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.ApiKeyCtrl.DB.Exec("UPDATE api_keys SET secret_hash = NULL, legacy_secret = ?", server_testing.ApiKey[32:])
+		require.NoError(t, err)
+
+		requireApiKeyIsValid(t, s, server_testing.ApiKey[:32]+"invalid", false)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+
+		// Secret is hashed on its first use
+		var legacySecret *string
+		err = s.ApiKeyCtrl.DB.QueryRow("SELECT legacy_secret FROM api_keys").Scan(&legacySecret)
+		require.NoError(t, err)
+		assert.Nil(t, legacySecret)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+	}))
+
+	t.Run("HashLegacyApiKeys", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.ApiKeyCtrl.DB.Exec("UPDATE api_keys SET secret_hash = NULL, legacy_secret = ?", server_testing.ApiKey[32:])
+		require.NoError(t, err)
+
+		err = server.HashLegacyApiKeys(context.Background(), s.ApiKeyCtrl.DB, s.Config.ApiKeyPepper)
+		require.NoError(t, err)
+
+		var legacySecret *string
+		err = s.ApiKeyCtrl.DB.QueryRow("SELECT legacy_secret FROM api_keys").Scan(&legacySecret)
+		require.NoError(t, err)
+		assert.Nil(t, legacySecret)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+	}))
+
+	t.Run("OtherCompany", newTest(func(t *testing.T, s server.Server) {
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.ApiKeyCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.ApiKeyCtrl.DB, otherCompanyId)
+
+		res := sendReqWithApiKey(t, s, otherApiKey, http.MethodGet, "", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		apiKeys := echo_ext.ReadBody[server.ApiKeyController_ListResponse](t, res.Body).ApiKeys
+		require.Len(t, apiKeys, 1)
+
+		// Api keys of other companies can't be revoked
+		res = sendReqWithApiKey(t, s, otherApiKey, http.MethodDelete, apiKeyPath(1), nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		requireApiKeyIsValid(t, s, server_testing.ApiKey, true)
+	}))
+}
@@ -1,53 +1,90 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"gatekeeper/internal/entity"
 	"gatekeeper/pkg/jwt_provider"
+	"gatekeeper/pkg/siwe"
+	"gatekeeper/pkg/verifier"
+	"math/big"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	"braces.dev/errtrace"
-	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/georgysavva/scany/sqlscan"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/samber/do"
 )
 
 const ChallengeTokenLength uint = 16
 const ChallengeMessagePrefix = "Authentication request\n"
+const ChallengeOwnerMessagePrefix = "Company owner authentication request\n"
 const ChallengeValidDuration = 5 * time.Minute
+const ChallengeSiweStatement = "Authentication request"
+const ChallengeOwnerSiweStatement = "Company owner authentication request"
+const ChallengeDefaultChainId uint64 = 1
+
+// Chain ids are stored as signed 64 bit integers
+const ChallengeMaxChainId uint64 = 1<<63 - 1
+const ChallengeEip712DomainVersion = "1"
+const ChallengeEip712PrimaryType = "Authentication"
+
+type ChallengeFormat string
+
+const (
+	ChallengeFormat_Plain  ChallengeFormat = "plain"
+	ChallengeFormat_Siwe   ChallengeFormat = "siwe"
+	ChallengeFormat_Eip712 ChallengeFormat = "eip712"
+)
+
+// ChallengePurpose tells which api a challenge signs in to, a challenge is only accepted by the api it was issued for
+type ChallengePurpose string
+
+const (
+	ChallengePurpose_Login ChallengePurpose = "login"
+	ChallengePurpose_Owner ChallengePurpose = "owner"
+)
 
 type ChallengeController struct {
 	DB          *sql.DB
 	JwtProvider jwt_provider.Provider
+	Verifiers   *verifier.Registry
 }
 
 func NewChallengeController(echoGrp *echo.Group, i *do.Injector) ChallengeController {
 	ct := ChallengeController{
 		DB:          do.MustInvoke[*sql.DB](i),
 		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+		Verifiers:   do.MustInvoke[*verifier.Registry](i),
 	}
 
-	challenges := echoGrp.Group("/challenges", NewApiKeyMiddleware(i))
-	challenges.POST("/issue", ct.Issue)
-	challenges.POST("/verify", ct.Verify)
+	// Challenges can be issued and verified from the browser with a publishable api key
+	challenges := echoGrp.Group("/challenges", NewCorsMiddleware(i))
+	challenges.POST("/issue", ct.Issue, NewApiKeyMiddleware(i, ApiKeyScope_ChallengesIssue))
+	challenges.POST("/verify", ct.Verify, NewApiKeyMiddleware(i, ApiKeyScope_ChallengesVerify))
 
 	return ct
 }
 
 type ChallengeController_IssueRequest struct {
-	WalletAddress string `json:"walletAddress" validate:"required"`
+	WalletAddress  string                  `json:"walletAddress" validate:"required"`
+	ChainNamespace verifier.ChainNamespace `json:"chainNamespace"`
+	Format         ChallengeFormat         `json:"format" validate:"in:plain,siwe,eip712"`
+	ChainId        uint64                  `json:"chainId"`
+	NotBefore      *time.Time              `json:"notBefore"`
 }
 
 type ChallengeController_IssueResponse struct {
+	// Plain text message for personal_sign, or the JSON encoded typed data for eth_signTypedData_v4
 	Challenge string `json:"challenge"`
 }
 
@@ -60,32 +97,171 @@ func GenerateChallengeToken() (string, error) {
 	return hex.EncodeToString(challengeTokenBytes), nil
 }
 
+const (
+	MsgWalletAddressIsInvalid          = "Wallet address is invalid"
+	MsgChainNamespaceIsNotSupported    = "Chain namespace is not supported"
+	MsgChallengeFormatIsNotSupported   = "Challenge format is not supported by the wallet chain"
+	MsgCompanyHasNoDomainSettings      = "Company has no domain and uri configured"
+	MsgChallengeNotBeforeIsAfterExpiry = "Challenge not before is after its expiration"
+	MsgChainIdIsTooLarge               = "Chain id is too large"
+)
+
 func (ct ChallengeController) Issue(c echo.Context) error {
 	req, err := bindAndValidate[ChallengeController_IssueRequest](c)
 	if err != nil {
 		return err
 	}
 
+	res, err := issueChallenge(c.Request().Context(), ct.DB, ct.Verifiers, getContextValue[uint](c, ContextKey_CompanyId), ChallengePurpose_Login, req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+// issueChallenge saves a new challenge of the company for the wallet and returns the message to sign
+func issueChallenge(ctx context.Context, db *sql.DB, verifiers *verifier.Registry, companyId uint, purpose ChallengePurpose, req ChallengeController_IssueRequest) (ChallengeController_IssueResponse, error) {
+	if req.Format == "" {
+		req.Format = ChallengeFormat_Plain
+	}
+
+	// Validate wallet address
+	v, walletAddress, err := normalizeWalletAddress(verifiers, req.ChainNamespace, req.WalletAddress)
+	if err != nil {
+		return ChallengeController_IssueResponse{}, err
+	}
+	// SIWE and EIP-712 messages are specific to Ethereum wallets
+	if v.ChainNamespace() != verifier.ChainNamespace_Eip155 && req.Format != ChallengeFormat_Plain {
+		return ChallengeController_IssueResponse{}, NewHTTPError(http.StatusBadRequest, MsgChallengeFormatIsNotSupported)
+	}
+
 	// Generate challenge token
 	challengeToken, err := GenerateChallengeToken()
 	if err != nil {
-		return errtrace.Errorf("failed to generate challenge token: %w", err)
+		return ChallengeController_IssueResponse{}, errtrace.Errorf("failed to generate challenge token: %w", err)
+	}
+
+	challenge := entity.Challenge{
+		CompanyId:      companyId,
+		Purpose:        string(purpose),
+		ChainNamespace: string(v.ChainNamespace()),
+		Format:         string(req.Format),
+		WalletAddress:  walletAddress,
+		Token:          challengeToken,
+		IssuedAt:       time.Now().UTC().Truncate(time.Second),
+	}
+	challenge.ExpiredAt = challenge.IssuedAt.Add(ChallengeValidDuration)
+
+	var message string
+	switch req.Format {
+	case ChallengeFormat_Plain:
+		message = challengeMessagePrefix(purpose) + challengeToken
+
+	case ChallengeFormat_Siwe, ChallengeFormat_Eip712:
+		// Get company domain settings
+		var company entity.Company
+		err = sqlscan.Get(ctx, db, &company,
+			"SELECT domain, uri FROM companies WHERE id = ? LIMIT 1", challenge.CompanyId,
+		)
+		if err != nil {
+			return ChallengeController_IssueResponse{}, errtrace.Errorf("failed to get company domain settings: %w", err)
+		}
+		if company.Domain == nil || company.URI == nil {
+			return ChallengeController_IssueResponse{}, NewHTTPError(http.StatusUnprocessableEntity, MsgCompanyHasNoDomainSettings)
+		}
+		challenge.Domain = company.Domain
+		challenge.URI = company.URI
+
+		chainId := req.ChainId
+		if chainId == 0 {
+			chainId = ChallengeDefaultChainId
+		}
+		if chainId > ChallengeMaxChainId {
+			return ChallengeController_IssueResponse{}, NewHTTPError(http.StatusBadRequest, MsgChainIdIsTooLarge)
+		}
+		challenge.ChainId = &chainId
+
+		if req.NotBefore != nil {
+			notBefore := req.NotBefore.UTC().Truncate(time.Second)
+			if !notBefore.Before(challenge.ExpiredAt) {
+				return ChallengeController_IssueResponse{}, NewHTTPError(http.StatusBadRequest, MsgChallengeNotBeforeIsAfterExpiry)
+			}
+			challenge.NotBefore = &notBefore
+		}
+
+		if req.Format == ChallengeFormat_Siwe {
+			message = newSiweMessage(challenge).String()
+		} else {
+			typedDataBytes, err := json.Marshal(newEip712TypedData(challenge))
+			if err != nil {
+				return ChallengeController_IssueResponse{}, errtrace.Errorf("failed to marshal typed data: %w", err)
+			}
+			message = string(typedDataBytes)
+		}
 	}
 
 	// Save challenge
-	_, err = ct.DB.ExecContext(c.Request().Context(),
-		"INSERT INTO challenges (wallet_address, token, expired_at) VALUES (?, ?, ?)",
-		req.WalletAddress, challengeToken, time.Now().UTC().Add(ChallengeValidDuration),
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO challenges (company_id, purpose, chain_namespace, format, wallet_address, token, domain, uri, chain_id, issued_at, not_before, expired_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		challenge.CompanyId, challenge.Purpose, challenge.ChainNamespace, challenge.Format, challenge.WalletAddress, challenge.Token, challenge.Domain, challenge.URI,
+		challenge.ChainId, challenge.IssuedAt, challenge.NotBefore, challenge.ExpiredAt,
 	)
 	if err != nil {
-		return errtrace.Errorf("failed to save challenge: %w", err)
+		return ChallengeController_IssueResponse{}, errtrace.Errorf("failed to save challenge: %w", err)
 	}
 
-	return errtrace.Wrap(
-		c.JSON(http.StatusOK, ChallengeController_IssueResponse{
-			Challenge: ChallengeMessagePrefix + challengeToken,
-		}),
-	)
+	return ChallengeController_IssueResponse{Challenge: message}, nil
+}
+
+// normalizeWalletAddress returns the verifier of the wallet with its normalized address, the chain namespace is detected when empty
+func normalizeWalletAddress(verifiers *verifier.Registry, chainNamespace verifier.ChainNamespace, walletAddress string) (verifier.Verifier, string, error) {
+	var v verifier.Verifier
+	var ok bool
+	if chainNamespace == "" {
+		v, walletAddress, ok = verifiers.Detect(walletAddress)
+	} else {
+		v, ok = verifiers.Get(chainNamespace)
+		if !ok {
+			return nil, "", NewHTTPError(http.StatusBadRequest, MsgChainNamespaceIsNotSupported)
+		}
+		walletAddress, ok = v.NormalizeAddress(walletAddress)
+	}
+	if !ok {
+		return nil, "", NewHTTPError(http.StatusBadRequest, MsgWalletAddressIsInvalid)
+	}
+	return v, walletAddress, nil
+}
+
+// Owner challenges state what they sign in to, so that a wallet can't be tricked into signing in to the owner api by a login page
+func challengeMessagePrefix(purpose ChallengePurpose) string {
+	if purpose == ChallengePurpose_Owner {
+		return ChallengeOwnerMessagePrefix
+	}
+	return ChallengeMessagePrefix
+}
+
+func challengeStatement(purpose ChallengePurpose) string {
+	if purpose == ChallengePurpose_Owner {
+		return ChallengeOwnerSiweStatement
+	}
+	return ChallengeSiweStatement
+}
+
+func newSiweMessage(challenge entity.Challenge) siwe.Message {
+	return siwe.Message{
+		Domain:         *challenge.Domain,
+		Address:        challenge.WalletAddress,
+		Statement:      challengeStatement(ChallengePurpose(challenge.Purpose)),
+		URI:            *challenge.URI,
+		Version:        siwe.Version,
+		ChainId:        *challenge.ChainId,
+		Nonce:          challenge.Token,
+		IssuedAt:       challenge.IssuedAt,
+		ExpirationTime: &challenge.ExpiredAt,
+		NotBefore:      challenge.NotBefore,
+	}
 }
 
 type ChallengeController_VerifyRequest struct {
@@ -93,11 +269,11 @@ type ChallengeController_VerifyRequest struct {
 	Signature string `json:"signature" validate:"required"`
 }
 
-type ChallengeController_VerifyResponse struct {
-	ProofToken string `json:"proofToken"`
-}
+type ChallengeController_VerifyResponse = SessionTokens
 
 const MsgChallengeDoesNotExistOrExpired = "Challenge does not exist or has expired"
+const MsgChallengeIsNotValidYet = "Challenge is not valid yet"
+const MsgChallengeMessageInvalid = "Challenge message does not match the issued challenge"
 const MsgSignatureInvalid = "Signature is invalid for given challenge"
 
 func (ct ChallengeController) Verify(c echo.Context) error {
@@ -106,61 +282,194 @@ func (ct ChallengeController) Verify(c echo.Context) error {
 		return err
 	}
 
-	// Extract challenge token and get associated wallet address
-	challengeToken := strings.ReplaceAll(req.Challenge, ChallengeMessagePrefix, "")
-	challenge := entity.Challenge{Token: challengeToken}
-	err = sqlscan.Get(c.Request().Context(), ct.DB, &challenge,
-		"SELECT id, wallet_address, expired_at FROM challenges WHERE token = ? LIMIT 1", challengeToken,
+	tx, err := ct.DB.BeginTx(c.Request().Context(), nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	challenge, err := verifyChallenge(c.Request().Context(), tx, ct.Verifiers, getContextValue[uint](c, ContextKey_CompanyId), ChallengePurpose_Login, req)
+	if err != nil {
+		return err
+	}
+
+	// Start session
+	tokens, err := createSession(c.Request().Context(), tx, ct.JwtProvider, challenge.CompanyId, challenge.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, tokens))
+}
+
+// verifyChallenge checks the signature of a challenge issued by the company for the purpose and consumes the challenge
+func verifyChallenge(ctx context.Context, tx *sql.Tx, verifiers *verifier.Registry, companyId uint, purpose ChallengePurpose, req ChallengeController_VerifyRequest) (entity.Challenge, error) {
+	var err error
+
+	// Extract challenge token
+	var challengeFormat ChallengeFormat
+	var challengeToken string
+	var siweMsg siwe.Message
+	var typedData apitypes.TypedData
+	if strings.HasPrefix(req.Challenge, challengeMessagePrefix(purpose)) {
+		challengeFormat = ChallengeFormat_Plain
+		challengeToken = strings.TrimPrefix(req.Challenge, challengeMessagePrefix(purpose))
+	} else if strings.HasPrefix(req.Challenge, "{") {
+		err = json.Unmarshal([]byte(req.Challenge), &typedData)
+		if err != nil {
+			return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+		}
+		challengeFormat = ChallengeFormat_Eip712
+		challengeToken, _ = typedData.Message["nonce"].(string)
+	} else {
+		siweMsg, err = siwe.ParseMessage(req.Challenge)
+		if err != nil {
+			return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+		}
+		challengeFormat = ChallengeFormat_Siwe
+		challengeToken = siweMsg.Nonce
+	}
+
+	// Get associated challenge, challenges issued by other companies or for another purpose are treated as nonexistent
+	var challenge entity.Challenge
+	err = sqlscan.Get(ctx, tx, &challenge,
+		`SELECT id, company_id, purpose, chain_namespace, format, wallet_address, token, domain, uri, chain_id, issued_at, not_before, expired_at
+		FROM challenges WHERE token = ? AND company_id = ? AND purpose = ? LIMIT 1`,
+		challengeToken, companyId, purpose,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+			return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
 		}
-		return errtrace.Errorf("failed to get challenge: %w", err)
+		return entity.Challenge{}, errtrace.Errorf("failed to get challenge: %w", err)
 	}
 
 	// Check if expired
 	if challenge.ExpiredAt.Before(time.Now()) {
-		return NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+	}
+	if challenge.NotBefore != nil && challenge.NotBefore.After(time.Now()) {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeIsNotValidYet)
 	}
 
-	// Verify message
-	// https://eips.ethereum.org/EIPS/eip-191
-	challengeHash := crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(req.Challenge)) + req.Challenge))
-	signature, err := hexutil.Decode(req.Signature)
-	if err != nil {
-		return NewHTTPError(http.StatusUnprocessableEntity, MsgSignatureInvalid)
+	// Check message matches the issued challenge
+	if ChallengeFormat(challenge.Format) != challengeFormat {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeMessageInvalid)
+	}
+	if challengeFormat == ChallengeFormat_Siwe && !siweMessageMatchesChallenge(siweMsg, challenge) {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeMessageInvalid)
 	}
-	// https://eips.ethereum.org/EIPS/eip-155
-	if signature[64] == 27 || signature[64] == 28 {
-		signature[64] -= 27
+	if challengeFormat == ChallengeFormat_Eip712 && !typedDataMatchesChallenge(typedData, challenge) {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeMessageInvalid)
 	}
-	publicKey, err := crypto.SigToPub(challengeHash, signature)
+
+	// Verify signature
+	v, ok := verifiers.Get(verifier.ChainNamespace(challenge.ChainNamespace))
+	if !ok {
+		return entity.Challenge{}, errtrace.Errorf("no verifier registered for chain namespace %s", challenge.ChainNamespace)
+	}
+	message := verifier.Message{Text: req.Challenge}
+	if challengeFormat == ChallengeFormat_Eip712 {
+		message = verifier.Message{TypedData: &typedData}
+	}
+	valid, err := verifier.VerifyMessage(ctx, v, challenge.WalletAddress, message, req.Signature)
 	if err != nil {
-		return NewHTTPError(http.StatusUnprocessableEntity, MsgSignatureInvalid)
+		return entity.Challenge{}, errtrace.Errorf("failed to verify signature: %w", err)
 	}
-	if crypto.PubkeyToAddress(*publicKey).Hex() != challenge.WalletAddress {
-		return NewHTTPError(http.StatusUnprocessableEntity, MsgSignatureInvalid)
+	if !valid {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgSignatureInvalid)
 	}
 
-	// Delete challenge
-	_, err = ct.DB.ExecContext(c.Request().Context(),
+	// Delete challenge, it may have been used concurrently
+	res, err := tx.ExecContext(ctx,
 		"DELETE FROM challenges WHERE id = ?", challenge.Id,
 	)
 	if err != nil {
-		return errtrace.Errorf("failed to delete challenge (token: %s): %w", challengeToken, err)
+		return entity.Challenge{}, errtrace.Errorf("failed to delete challenge (token: %s): %w", challengeToken, err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return entity.Challenge{}, NewHTTPError(http.StatusUnprocessableEntity, MsgChallengeDoesNotExistOrExpired)
+	}
+
+	return challenge, nil
+}
+
+// https://eips.ethereum.org/EIPS/eip-712
+func newEip712TypedData(challenge entity.Challenge) apitypes.TypedData {
+	typedData := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+			},
+			ChallengeEip712PrimaryType: {
+				{Name: "statement", Type: "string"},
+				{Name: "wallet", Type: "address"},
+				{Name: "uri", Type: "string"},
+				{Name: "nonce", Type: "string"},
+				{Name: "issuedAt", Type: "uint256"},
+				{Name: "expiresAt", Type: "uint256"},
+			},
+		},
+		PrimaryType: ChallengeEip712PrimaryType,
+		Domain: apitypes.TypedDataDomain{
+			Name:    *challenge.Domain,
+			Version: ChallengeEip712DomainVersion,
+			ChainId: (*math.HexOrDecimal256)(new(big.Int).SetUint64(*challenge.ChainId)),
+		},
+		Message: apitypes.TypedDataMessage{
+			"statement": challengeStatement(ChallengePurpose(challenge.Purpose)),
+			"wallet":    challenge.WalletAddress,
+			"uri":       *challenge.URI,
+			"nonce":     challenge.Token,
+			"issuedAt":  big.NewInt(challenge.IssuedAt.Unix()),
+			"expiresAt": big.NewInt(challenge.ExpiredAt.Unix()),
+		},
 	}
+	if challenge.NotBefore != nil {
+		typedData.Types[ChallengeEip712PrimaryType] = append(typedData.Types[ChallengeEip712PrimaryType],
+			apitypes.Type{Name: "notBefore", Type: "uint256"},
+		)
+		typedData.Message["notBefore"] = big.NewInt(challenge.NotBefore.Unix())
+	}
+	return typedData
+}
 
-	// Generate proof token
-	proofToken, err := ct.JwtProvider.GenerateSignedToken(jwt.RegisteredClaims{
-		Subject:   challenge.WalletAddress,
-		ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(5 * time.Minute)},
-	})
+func typedDataMatchesChallenge(typedData apitypes.TypedData, challenge entity.Challenge) bool {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
 	if err != nil {
-		return errtrace.Errorf("failed to generate proof token: %w", err)
+		return false
 	}
+	expectedHash, _, err := apitypes.TypedDataAndHash(newEip712TypedData(challenge))
+	return err == nil && bytes.Equal(hash, expectedHash)
+}
 
-	return errtrace.Wrap(
-		c.JSON(http.StatusOK, ChallengeController_VerifyResponse{ProofToken: proofToken}),
-	)
+func siweMessageMatchesChallenge(msg siwe.Message, challenge entity.Challenge) bool {
+	expected := newSiweMessage(challenge)
+
+	timeMatches := func(a, b *time.Time) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	}
+
+	return msg.Domain == expected.Domain &&
+		msg.Address == expected.Address &&
+		msg.Statement == expected.Statement &&
+		msg.URI == expected.URI &&
+		msg.Version == expected.Version &&
+		msg.ChainId == expected.ChainId &&
+		msg.Nonce == expected.Nonce &&
+		msg.IssuedAt.Equal(expected.IssuedAt) &&
+		timeMatches(msg.ExpirationTime, expected.ExpirationTime) &&
+		timeMatches(msg.NotBefore, expected.NotBefore) &&
+		msg.RequestId == expected.RequestId &&
+		len(msg.Resources) == 0
 }
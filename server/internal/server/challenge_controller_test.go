@@ -1,27 +1,39 @@
 package server_test
 
 import (
-	"gatekeeper/internal"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/json"
 	"gatekeeper/internal/server"
 	server_testing "gatekeeper/internal/server/testing"
 	"gatekeeper/pkg/crypto_ext"
 	"gatekeeper/pkg/echo_ext"
+	"gatekeeper/pkg/eip1271"
+	"gatekeeper/pkg/siwe"
+	"gatekeeper/pkg/verifier"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestChallengeController_Issue(t *testing.T) {
-	s := server.NewServer(internal.NewTestInjector(t), server.Config{Env: "test"})
+	s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
 	res := echo_ext.SendTestRequest(
 		t, s.Echo, http.MethodPost, "/v1/challenges/issue",
 		map[string]string{"Api-Key": server_testing.ApiKey},
-		server.ChallengeController_IssueRequest{WalletAddress: "WalletAddress"},
+		server.ChallengeController_IssueRequest{WalletAddress: server_testing.WalletAddress},
 	)
 	require.Equal(t, http.StatusOK, res.Code)
 }
@@ -46,24 +58,27 @@ func TestChallengeController_Verify(t *testing.T) {
 	}
 
 	newTest := func(test Test, testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
-		s := server.NewServer(internal.NewTestInjector(t), server.Config{Env: "test"})
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
 
 		_, err = s.ChallengeCtrl.DB.Exec(
-			"INSERT INTO challenges (wallet_address, token, expired_at) VALUES (?, ?, ?)",
-			walletAddressA, challengeTokenA, test.ExpiredAt,
+			"INSERT INTO challenges (company_id, wallet_address, token, expired_at) VALUES (?, ?, ?, ?)",
+			1, walletAddressA, challengeTokenA, test.ExpiredAt,
 		)
 		require.NoError(t, err)
 
 		return func(t *testing.T) { testFn(t, s) }
 	}
 
-	sendReq := func(t *testing.T, s server.Server, challenge, signature string) *httptest.ResponseRecorder {
+	sendReqWithApiKey := func(t *testing.T, s server.Server, apiKey, challenge, signature string) *httptest.ResponseRecorder {
 		return echo_ext.SendTestRequest(
 			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
-			map[string]string{"Api-Key": server_testing.ApiKey},
+			map[string]string{"Api-Key": apiKey},
 			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: signature},
 		)
 	}
+	sendReq := func(t *testing.T, s server.Server, challenge, signature string) *httptest.ResponseRecorder {
+		return sendReqWithApiKey(t, s, server_testing.ApiKey, challenge, signature)
+	}
 
 	t.Run("Success", newTest(
 		Test{ExpiredAt: time.Now().UTC().Add(time.Minute)},
@@ -72,17 +87,39 @@ func TestChallengeController_Verify(t *testing.T) {
 			require.Equal(t, http.StatusOK, res.Code)
 			body := echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
 
-			claims, err := s.ChallengeCtrl.JwtProvider.GetClaims(body.ProofToken)
+			claims, err := s.ChallengeCtrl.JwtProvider.GetClaims(body.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
 			require.NoError(t, err)
 			sub, err := claims.GetSubject()
 			require.NoError(t, err)
 			assert.Equal(t, walletAddressA, sub)
+			aud, err := claims.GetAudience()
+			require.NoError(t, err)
+			assert.Equal(t, jwt.ClaimStrings{"1"}, aud)
 			expiredAt, err := claims.GetExpirationTime()
 			require.NoError(t, err)
 			assert.Greater(t, expiredAt.Time, time.Now())
 		},
 	))
 
+	t.Run("ChallengeIssuedByOtherCompany", newTest(
+		Test{ExpiredAt: time.Now().UTC().Add(time.Minute)},
+		func(t *testing.T, s server.Server) {
+			otherCompanyId := server_testing.CompanyId + 1
+			_, err := s.ChallengeCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+			require.NoError(t, err)
+			otherApiKey := server_testing.CreateApiKey(t, s.ChallengeCtrl.DB, otherCompanyId)
+
+			res := sendReqWithApiKey(t, s, otherApiKey, challengeA, hexutil.Encode(signatureA))
+			require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+			body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+			assert.Equal(t, server.MsgChallengeDoesNotExistOrExpired, body.Error)
+
+			// The challenge can still be used by the company that issued it
+			res = sendReq(t, s, challengeA, hexutil.Encode(signatureA))
+			require.Equal(t, http.StatusOK, res.Code)
+		},
+	))
+
 	t.Run("ChallengeDoesNotExist", newTest(
 		Test{ExpiredAt: time.Now().UTC().Add(time.Minute)},
 		func(t *testing.T, s server.Server) {
@@ -113,3 +150,535 @@ func TestChallengeController_Verify(t *testing.T) {
 		},
 	))
 }
+
+func TestChallengeController_PublishableApiKey(t *testing.T) {
+	origin := "http://localhost:4000"
+
+	newTest := func(testFn func(t *testing.T, s server.Server, publishableApiKey string)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		publishableApiKey := server_testing.CreatePublishableApiKey(t, s.ChallengeCtrl.DB, server_testing.CompanyId)
+		return func(t *testing.T) { testFn(t, s, publishableApiKey) }
+	}
+	issue := func(t *testing.T, s server.Server, headers map[string]string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue", headers,
+			server.ChallengeController_IssueRequest{WalletAddress: server_testing.WalletAddress},
+		)
+	}
+	preflight := func(t *testing.T, s server.Server, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/v1/challenges/issue", nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPost)
+		req.Header.Set(echo.HeaderAccessControlRequestHeaders, "api-key,content-type")
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	requireOriginIsNotAllowed := func(t *testing.T, res *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusForbidden, res.Code)
+		assert.Equal(t, server.MsgOriginIsNotAllowed, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		res := preflight(t, s, origin)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, origin, res.Header().Get(echo.HeaderAccessControlAllowOrigin))
+		assert.Contains(t, res.Header().Get(echo.HeaderAccessControlAllowHeaders), "Api-Key")
+
+		res = issue(t, s, map[string]string{"Api-Key": publishableApiKey, echo.HeaderOrigin: origin})
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, origin, res.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	}))
+
+	t.Run("PreflightOriginIsNotAllowed", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		res := preflight(t, s, "https://attacker.com")
+		assert.Empty(t, res.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	}))
+
+	t.Run("OriginIsNotAllowed", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		requireOriginIsNotAllowed(t, issue(t, s, map[string]string{"Api-Key": publishableApiKey, echo.HeaderOrigin: "https://attacker.com"}))
+		requireOriginIsNotAllowed(t, issue(t, s, map[string]string{"Api-Key": publishableApiKey}))
+	}))
+
+	t.Run("OriginOfOtherCompany", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.ChallengeCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		_, err = s.ChallengeCtrl.DB.Exec("INSERT INTO allowed_origins (company_id, origin) VALUES (?, ?)", otherCompanyId, "https://other.com")
+		require.NoError(t, err)
+
+		requireOriginIsNotAllowed(t, issue(t, s, map[string]string{"Api-Key": publishableApiKey, echo.HeaderOrigin: "https://other.com"}))
+	}))
+
+	t.Run("SecretApiKeyWithoutOrigin", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		res := issue(t, s, map[string]string{"Api-Key": server_testing.ApiKey})
+		assert.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("OtherRoutesAreForbidden", newTest(func(t *testing.T, s server.Server, publishableApiKey string) {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": publishableApiKey, echo.HeaderOrigin: origin},
+			server.SessionController_RevokeRequest{WalletAddress: server_testing.WalletAddress},
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	}))
+}
+
+func TestChallengeController_Siwe(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	issue := func(t *testing.T, s server.Server, req server.ChallengeController_IssueRequest) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			req,
+		)
+	}
+	issueMessage := func(t *testing.T, s server.Server, req server.ChallengeController_IssueRequest) siwe.Message {
+		res := issue(t, s, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body)
+		msg, err := siwe.ParseMessage(body.Challenge)
+		require.NoError(t, err)
+		require.Equal(t, body.Challenge, msg.String())
+		return msg
+	}
+	signAndVerify := func(t *testing.T, s server.Server, msg siwe.Message) *httptest.ResponseRecorder {
+		signature, err := crypto_ext.PersonalSign([]byte(msg.String()), privateKey)
+		require.NoError(t, err)
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: msg.String(), Signature: hexutil.Encode(signature)},
+		)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		msg := issueMessage(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+			ChainId:       10,
+		})
+		assert.Equal(t, "localhost:4000", msg.Domain)
+		assert.Equal(t, "http://localhost:4000", msg.URI)
+		assert.Equal(t, walletAddress, msg.Address)
+		assert.Equal(t, uint64(10), msg.ChainId)
+		require.NotNil(t, msg.ExpirationTime)
+		assert.Equal(t, server.ChallengeValidDuration, msg.ExpirationTime.Sub(msg.IssuedAt))
+
+		res := signAndVerify(t, s, msg)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("ChainIdIsTooLarge", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+			ChainId:       server.ChallengeMaxChainId + 1,
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChainIdIsTooLarge, body.Error)
+	}))
+
+	t.Run("WalletAddressIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: "WalletAddress",
+			Format:        server.ChallengeFormat_Siwe,
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgWalletAddressIsInvalid, body.Error)
+	}))
+
+	t.Run("MessageTampered", newTest(func(t *testing.T, s server.Server) {
+		msg := issueMessage(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+		})
+		msg.Domain = "phishing.com"
+
+		res := signAndVerify(t, s, msg)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeMessageInvalid, body.Error)
+	}))
+
+	t.Run("ExpirationTimeTampered", newTest(func(t *testing.T, s server.Server) {
+		msg := issueMessage(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+		})
+		expirationTime := msg.ExpirationTime.Add(time.Hour)
+		msg.ExpirationTime = &expirationTime
+
+		res := signAndVerify(t, s, msg)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeMessageInvalid, body.Error)
+	}))
+
+	t.Run("ChallengeIsNotValidYet", newTest(func(t *testing.T, s server.Server) {
+		notBefore := time.Now().Add(time.Minute)
+		msg := issueMessage(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+			NotBefore:     &notBefore,
+		})
+		require.NotNil(t, msg.NotBefore)
+
+		res := signAndVerify(t, s, msg)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeIsNotValidYet, body.Error)
+	}))
+}
+
+func TestChallengeController_Eip712(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	issueTypedData := func(t *testing.T, s server.Server) apitypes.TypedData {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_IssueRequest{WalletAddress: walletAddress, Format: server.ChallengeFormat_Eip712},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body)
+		var typedData apitypes.TypedData
+		require.NoError(t, json.Unmarshal([]byte(body.Challenge), &typedData))
+		return typedData
+	}
+	signAndVerify := func(t *testing.T, s server.Server, typedData apitypes.TypedData) *httptest.ResponseRecorder {
+		signature, err := crypto_ext.SignTypedData(typedData, privateKey)
+		require.NoError(t, err)
+		typedDataBytes, err := json.Marshal(typedData)
+		require.NoError(t, err)
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: string(typedDataBytes), Signature: hexutil.Encode(signature)},
+		)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		typedData := issueTypedData(t, s)
+		assert.Equal(t, server.ChallengeEip712PrimaryType, typedData.PrimaryType)
+		assert.Equal(t, "localhost:4000", typedData.Domain.Name)
+		assert.Equal(t, walletAddress, typedData.Message["wallet"])
+		assert.NotEmpty(t, typedData.Message["nonce"])
+		assert.NotEmpty(t, typedData.Message["expiresAt"])
+
+		res := signAndVerify(t, s, typedData)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("MessageTampered", newTest(func(t *testing.T, s server.Server) {
+		typedData := issueTypedData(t, s)
+		typedData.Message["uri"] = "https://phishing.com"
+
+		res := signAndVerify(t, s, typedData)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeMessageInvalid, body.Error)
+	}))
+
+	t.Run("InvalidSignature", newTest(func(t *testing.T, s server.Server) {
+		typedData := issueTypedData(t, s)
+		_, otherPrivateKey := server_testing.GenerateWalletAddress(t)
+		signature, err := crypto_ext.SignTypedData(typedData, otherPrivateKey)
+		require.NoError(t, err)
+		typedDataBytes, err := json.Marshal(typedData)
+		require.NoError(t, err)
+
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: string(typedDataBytes), Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+}
+
+func TestChallengeController_Eip1271(t *testing.T) {
+	ownerAddress, ownerPrivateKey := server_testing.GenerateWalletAddress(t)
+	_, otherPrivateKey := server_testing.GenerateWalletAddress(t)
+
+	walletAddress := common.HexToAddress("0x0000000000000000000000000000000000001271")
+	factoryAddress := common.HexToAddress("0x0000000000000000000000000000000000006492")
+	walletInitCode := server_testing.InitCode(server_testing.SmartWalletCode(common.HexToAddress(ownerAddress)))
+	counterfactualWalletAddress := server_testing.CounterfactualSmartWalletAddress(factoryAddress, walletInitCode)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		backend := server_testing.NewSimulatedBackend(t, core.GenesisAlloc{
+			walletAddress:  {Code: server_testing.SmartWalletCode(common.HexToAddress(ownerAddress))},
+			factoryAddress: {Code: server_testing.SmartWalletFactoryCode},
+		})
+		i := server_testing.NewTestInjector(t)
+		do.Override(i, func(_ *do.Injector) (eip1271.Caller, error) { return backend, nil })
+		s := server.NewServer(i, server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	issue := func(t *testing.T, s server.Server, walletAddress common.Address) string {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_IssueRequest{WalletAddress: walletAddress.Hex(), Format: server.ChallengeFormat_Siwe},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+	}
+	sign := func(t *testing.T, challenge string, privateKey *ecdsa.PrivateKey) []byte {
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+		signature[64] += 27
+		return signature
+	}
+	verify := func(t *testing.T, s server.Server, challenge string, signature []byte) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+	}
+	assertSubject := func(t *testing.T, s server.Server, res *httptest.ResponseRecorder, walletAddress common.Address) {
+		body := echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
+		claims, err := s.ChallengeCtrl.JwtProvider.GetClaims(body.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		sub, err := claims.GetSubject()
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress.Hex(), sub)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		challenge := issue(t, s, walletAddress)
+		res := verify(t, s, challenge, sign(t, challenge, ownerPrivateKey))
+		require.Equal(t, http.StatusOK, res.Code)
+		assertSubject(t, s, res, walletAddress)
+	}))
+
+	t.Run("InvalidSignature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issue(t, s, walletAddress)
+		res := verify(t, s, challenge, sign(t, challenge, otherPrivateKey))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+
+	t.Run("CounterfactualWallet", newTest(func(t *testing.T, s server.Server) {
+		challenge := issue(t, s, counterfactualWalletAddress)
+		signature := server_testing.WrapErc6492Signature(t, factoryAddress, walletInitCode, sign(t, challenge, ownerPrivateKey))
+		res := verify(t, s, challenge, signature)
+		require.Equal(t, http.StatusOK, res.Code)
+		assertSubject(t, s, res, counterfactualWalletAddress)
+	}))
+
+	t.Run("CounterfactualWalletInvalidSignature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issue(t, s, counterfactualWalletAddress)
+		signature := server_testing.WrapErc6492Signature(t, factoryAddress, walletInitCode, sign(t, challenge, otherPrivateKey))
+		res := verify(t, s, challenge, signature)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+
+	t.Run("CounterfactualWalletWithoutErc6492Signature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issue(t, s, counterfactualWalletAddress)
+		res := verify(t, s, challenge, sign(t, challenge, ownerPrivateKey))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+}
+
+func TestChallengeController_Solana(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateSolanaWalletAddress(t)
+	_, otherPrivateKey := server_testing.GenerateSolanaWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	issue := func(t *testing.T, s server.Server, req server.ChallengeController_IssueRequest) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			req,
+		)
+	}
+	issueChallenge := func(t *testing.T, s server.Server) string {
+		res := issue(t, s, server.ChallengeController_IssueRequest{WalletAddress: walletAddress})
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+	}
+	verify := func(t *testing.T, s server.Server, challenge string, signature string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: signature},
+		)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s)
+		res := verify(t, s, challenge, base58.Encode(ed25519.Sign(privateKey, []byte(challenge))))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		body := echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
+		claims, err := s.ChallengeCtrl.JwtProvider.GetClaims(body.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		sub, err := claims.GetSubject()
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, sub)
+	}))
+
+	t.Run("HexSignature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s)
+		res := verify(t, s, challenge, hexutil.Encode(ed25519.Sign(privateKey, []byte(challenge))))
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("InvalidSignature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s)
+		res := verify(t, s, challenge, base58.Encode(ed25519.Sign(otherPrivateKey, []byte(challenge))))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+
+	t.Run("ChainNamespaceDoesNotMatch", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress:  walletAddress,
+			ChainNamespace: verifier.ChainNamespace_Eip155,
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgWalletAddressIsInvalid, body.Error)
+	}))
+
+	t.Run("ChainNamespaceIsNotSupported", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress:  walletAddress,
+			ChainNamespace: "cosmos",
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChainNamespaceIsNotSupported, body.Error)
+	}))
+
+	t.Run("ChallengeFormatIsNotSupported", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: walletAddress,
+			Format:        server.ChallengeFormat_Siwe,
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeFormatIsNotSupported, body.Error)
+	}))
+}
+
+func TestChallengeController_Bitcoin(t *testing.T) {
+	wallet := server_testing.GenerateBitcoinWallet(t)
+	otherWallet := server_testing.GenerateBitcoinWallet(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	issue := func(t *testing.T, s server.Server, req server.ChallengeController_IssueRequest) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			req,
+		)
+	}
+	issueChallenge := func(t *testing.T, s server.Server, walletAddress string) string {
+		res := issue(t, s, server.ChallengeController_IssueRequest{WalletAddress: walletAddress})
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+	}
+	verify := func(t *testing.T, s server.Server, challenge string, signature string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: signature},
+		)
+	}
+	requireSubject := func(t *testing.T, s server.Server, res *httptest.ResponseRecorder, walletAddress string) {
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
+		claims, err := s.ChallengeCtrl.JwtProvider.GetClaims(body.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		sub, err := claims.GetSubject()
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, sub)
+	}
+
+	t.Run("P2pkhBip137", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s, wallet.P2pkh.EncodeAddress())
+		res := verify(t, s, challenge, server_testing.SignBip137Message(t, wallet.PrivateKey, wallet.P2pkh, challenge))
+		requireSubject(t, s, res, wallet.P2pkh.EncodeAddress())
+	}))
+
+	t.Run("P2wpkhBip137", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s, wallet.P2wpkh.EncodeAddress())
+		res := verify(t, s, challenge, server_testing.SignBip137Message(t, wallet.PrivateKey, wallet.P2wpkh, challenge))
+		requireSubject(t, s, res, wallet.P2wpkh.EncodeAddress())
+	}))
+
+	t.Run("P2wpkhBip322", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s, wallet.P2wpkh.EncodeAddress())
+		res := verify(t, s, challenge, server_testing.SignBip322Message(t, wallet.PrivateKey, wallet.P2wpkh, challenge))
+		requireSubject(t, s, res, wallet.P2wpkh.EncodeAddress())
+	}))
+
+	t.Run("P2trBip322", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s, wallet.P2tr.EncodeAddress())
+		res := verify(t, s, challenge, server_testing.SignBip322Message(t, wallet.PrivateKey, wallet.P2tr, challenge))
+		requireSubject(t, s, res, wallet.P2tr.EncodeAddress())
+	}))
+
+	t.Run("InvalidSignature", newTest(func(t *testing.T, s server.Server) {
+		challenge := issueChallenge(t, s, wallet.P2wpkh.EncodeAddress())
+		res := verify(t, s, challenge, "not base64")
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+
+		challenge = issueChallenge(t, s, wallet.P2pkh.EncodeAddress())
+		res = verify(t, s, challenge, server_testing.SignBip137Message(t, otherWallet.PrivateKey, otherWallet.P2pkh, challenge))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+
+		challenge = issueChallenge(t, s, wallet.P2tr.EncodeAddress())
+		res = verify(t, s, challenge, server_testing.SignBip322Message(t, otherWallet.PrivateKey, otherWallet.P2tr, challenge))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		body = echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgSignatureInvalid, body.Error)
+	}))
+
+	t.Run("ChallengeFormatIsNotSupported", newTest(func(t *testing.T, s server.Server) {
+		res := issue(t, s, server.ChallengeController_IssueRequest{
+			WalletAddress: wallet.P2tr.EncodeAddress(),
+			Format:        server.ChallengeFormat_Siwe,
+		})
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgChallengeFormatIsNotSupported, body.Error)
+	}))
+}
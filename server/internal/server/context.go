@@ -6,7 +6,10 @@ type ContextKey string
 
 const (
 	ContextKey_CompanyId     ContextKey = "companyId"
+	ContextKey_ApiKeyId      ContextKey = "apiKeyId"
 	ContextKey_WalletAddress ContextKey = "walletAddress"
+	// *jwt_provider.Claims of the proof token
+	ContextKey_ProofTokenClaims ContextKey = "proofTokenClaims"
 )
 
 func setContextValue(c echo.Context, key ContextKey, value any) {
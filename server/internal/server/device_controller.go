@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/jwt_provider"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/samber/do"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DevicePath          = "/device"
+	DeviceConfirmPath   = "/device/confirm"
+	DeviceCompletePath  = "/device/complete"
+	DeviceAuthorizePath = "/device/authorize"
+	DeviceTokenPath     = "/device/token"
+)
+
+const DeviceCodeLength uint = 32
+const DevicePollInterval = 5 * time.Second
+const DeviceLongPollTimeout = 30 * time.Second
+const DeviceLongPollCheckInterval = 250 * time.Millisecond
+const DeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// User code attempts are limited per client ip so that pending device authorizations can't be guessed, one attempt is regained per interval
+const DeviceUserCodeAttemptsBurst = 10
+const DeviceUserCodeAttemptsInterval = 6 * time.Second
+
+const DeviceInfoMaxLength = 255
+
+// Vowels and ambiguous characters are left out so user codes are easy to type and never spell words (RFC 8628 section 6.1)
+const UserCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+const UserCodeLength = 8
+
+// https://datatracker.ietf.org/doc/html/rfc8628#section-3.5
+const (
+	OAuthError_AuthorizationPending = "authorization_pending"
+	OAuthError_SlowDown             = "slow_down"
+	OAuthError_ExpiredToken         = "expired_token"
+)
+
+const (
+	MsgUserCodeDoesNotExistOrExpired = "User code does not exist or has expired"
+	MsgUserCodeAttemptsExceeded      = "Too many attempts, try again in a minute"
+)
+
+// DeviceController lets devices without a wallet, like desktop apps or smart TVs, sign in with a wallet on another device (RFC 8628).
+// The device shows a user code and a QR code of the verification uri, the wallet confirms the device and signs in on the login page while the device polls for its session.
+// Only companies which enabled the device flow accept devices.
+type DeviceController struct {
+	Config      Config
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+}
+
+func NewDeviceController(e *echo.Echo, i *do.Injector) DeviceController {
+	ct := DeviceController{
+		Config:      do.MustInvoke[Config](i),
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+	}
+
+	e.POST(DeviceAuthorizePath, ct.Authorize)
+	e.POST(DeviceTokenPath, ct.Token)
+	userCodeLimiter := newUserCodeRateLimiter()
+	e.GET(DevicePath, ct.Device, userCodeLimiter)
+	e.POST(DeviceConfirmPath, ct.Confirm, userCodeLimiter)
+	e.GET(DeviceCompletePath, ct.Complete)
+
+	return ct
+}
+
+// Field names follow RFC 8628, requests can be sent as a form or as JSON
+type DeviceController_AuthorizeRequest struct {
+	ClientId string `json:"client_id" form:"client_id"`
+}
+
+type DeviceController_AuthorizeResponse struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code"`
+	// Page where the user enters the user code
+	VerificationUri string `json:"verification_uri"`
+	// Verification uri including the user code, to be shown as a QR code
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	// Minimum seconds between two polls
+	Interval int64 `json:"interval"`
+}
+
+// Authorize starts a device authorization, devices are public clients so only the client id is required
+func (ct DeviceController) Authorize(c echo.Context) error {
+	var req DeviceController_AuthorizeRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidRequest, "")
+	}
+	ctx := c.Request().Context()
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	companyId, err := getPublicClientCompanyId(ctx, ct.DB, req.ClientId)
+	if err != nil {
+		return err
+	}
+	if companyId == 0 {
+		return oauthError(c, http.StatusUnauthorized, OAuthError_InvalidClient, "")
+	}
+
+	deviceCode, err := generateAuthorizationCode()
+	if err != nil {
+		return errtrace.Errorf("failed to generate device code: %w", err)
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return errtrace.Errorf("failed to generate user code: %w", err)
+	}
+	deviceCodeHash := hashAuthorizationCode(deviceCode)
+	authReq := entity.AuthorizationRequest{
+		CompanyId:      companyId,
+		Flow:           string(AuthorizationFlow_Device),
+		RedirectUri:    DeviceCompletePath,
+		UserCode:       &userCode,
+		DeviceCodeHash: &deviceCodeHash,
+		DeviceInfo:     nullIfEmpty(truncateDeviceInfo(c.Request().UserAgent())),
+	}
+	err = createAuthorizationRequest(ctx, ct.DB, &authReq)
+	if err != nil {
+		return err
+	}
+
+	verificationUri := strings.TrimSuffix(ct.Config.IssuerUrl, "/") + DevicePath
+	return errtrace.Wrap(c.JSON(http.StatusOK, DeviceController_AuthorizeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationUri:         verificationUri,
+		VerificationUriComplete: verificationUri + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               int64(AuthorizationRequestValidDuration.Seconds()),
+		Interval:                int64(DevicePollInterval.Seconds()),
+	}))
+}
+
+type DeviceController_DeviceRequest struct {
+	UserCode string `query:"user_code"`
+}
+
+type deviceCodePage struct {
+	Error string
+}
+
+// Device renders the confirmation page of the user code with the company and the device signing in (RFC 8628 section 5.4), or a form to enter it
+func (ct DeviceController) Device(c echo.Context) error {
+	var req DeviceController_DeviceRequest
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return ErrBadRequest
+	}
+	if req.UserCode == "" {
+		return renderTemplate(c, http.StatusOK, "device.html", deviceCodePage{})
+	}
+	ctx := c.Request().Context()
+
+	authReq, err := getDeviceAuthorizationRequest(ctx, ct.DB, req.UserCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return renderTemplate(c, http.StatusNotFound, "device.html", deviceCodePage{Error: MsgUserCodeDoesNotExistOrExpired})
+		}
+		return err
+	}
+	page, err := newLoginPage(ctx, ct.DB, authReq)
+	if err != nil {
+		return err
+	}
+	// The request token is only given once the device is confirmed
+	page.RequestToken = ""
+
+	return renderTemplate(c, http.StatusOK, "device_confirm.html", page)
+}
+
+type DeviceController_ConfirmRequest struct {
+	UserCode string `form:"user_code"`
+}
+
+// Confirm is posted by the wallet once it checked the device signing in, the login page is then rendered
+func (ct DeviceController) Confirm(c echo.Context) error {
+	var req DeviceController_ConfirmRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return ErrBadRequest
+	}
+	ctx := c.Request().Context()
+
+	now := time.Now().UTC()
+	res, err := ct.DB.ExecContext(ctx,
+		`UPDATE authorization_requests SET confirmed_at = ?
+		WHERE user_code = ? AND flow = ? AND code_hash IS NULL AND expired_at > ?`,
+		now, normalizeUserCode(req.UserCode), AuthorizationFlow_Device, now,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to confirm device authorization request: %w", err)
+	}
+	if confirmed, err := res.RowsAffected(); err != nil || confirmed == 0 {
+		return renderTemplate(c, http.StatusNotFound, "device.html", deviceCodePage{Error: MsgUserCodeDoesNotExistOrExpired})
+	}
+	authReq, err := getDeviceAuthorizationRequest(ctx, ct.DB, req.UserCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return renderTemplate(c, http.StatusNotFound, "device.html", deviceCodePage{Error: MsgUserCodeDoesNotExistOrExpired})
+		}
+		return err
+	}
+
+	return renderLoginPage(c, ct.DB, authReq)
+}
+
+// Complete is shown to the wallet once the device is signed in
+func (ct DeviceController) Complete(c echo.Context) error {
+	return renderTemplate(c, http.StatusOK, "device_complete.html", nil)
+}
+
+type DeviceController_TokenRequest struct {
+	GrantType  string `json:"grant_type" form:"grant_type"`
+	DeviceCode string `json:"device_code" form:"device_code"`
+	ClientId   string `json:"client_id" form:"client_id"`
+	// Holds the request until the wallet signs in or the long poll times out, instead of answering authorization_pending right away
+	LongPoll bool `json:"long_poll" form:"long_poll"`
+}
+
+type DeviceController_TokenResponse = OidcController_TokenResponse
+
+// Token is polled by the device until the wallet has signed in, the session is then started
+func (ct DeviceController) Token(c echo.Context) error {
+	var req DeviceController_TokenRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidRequest, "")
+	}
+	ctx := c.Request().Context()
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	if req.GrantType != DeviceGrantType {
+		return oauthError(c, http.StatusBadRequest, OAuthError_UnsupportedGrantType, "")
+	}
+	companyId, err := getPublicClientCompanyId(ctx, ct.DB, req.ClientId)
+	if err != nil {
+		return err
+	}
+	if companyId == 0 {
+		return oauthError(c, http.StatusUnauthorized, OAuthError_InvalidClient, "")
+	}
+	deviceCodeHash := hashAuthorizationCode(req.DeviceCode)
+
+	if req.LongPoll {
+		timeout := time.NewTimer(DeviceLongPollTimeout)
+		defer timeout.Stop()
+		ticker := time.NewTicker(DeviceLongPollCheckInterval)
+		defer ticker.Stop()
+	poll:
+		for {
+			var authenticated bool
+			err := sqlscan.Get(ctx, ct.DB, &authenticated,
+				`SELECT EXISTS (SELECT 1 FROM authorization_requests
+				WHERE device_code_hash = ? AND company_id = ? AND flow = ? AND (code_hash IS NOT NULL OR expired_at <= ?))`,
+				deviceCodeHash, companyId, AuthorizationFlow_Device, time.Now().UTC(),
+			)
+			if err != nil {
+				return errtrace.Errorf("failed to check if device is authenticated: %w", err)
+			}
+			if authenticated {
+				break
+			}
+			select {
+			case <-ticker.C:
+			case <-timeout.C:
+				break poll
+			case <-ctx.Done():
+				return errtrace.Wrap(ctx.Err())
+			}
+		}
+	}
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authReq entity.AuthorizationRequest
+	err = sqlscan.Get(ctx, tx, &authReq,
+		`SELECT id, company_id, wallet_address, code_hash, expired_at FROM authorization_requests
+		WHERE device_code_hash = ? AND company_id = ? AND flow = ? LIMIT 1`,
+		deviceCodeHash, companyId, AuthorizationFlow_Device,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "")
+		}
+		return errtrace.Errorf("failed to get device authorization request: %w", err)
+	}
+	now := time.Now().UTC()
+	if !authReq.ExpiredAt.After(now) {
+		return oauthError(c, http.StatusBadRequest, OAuthError_ExpiredToken, "")
+	}
+
+	// Wallet has not signed in yet, the poll time is only saved if the device waited for the interval since its last poll
+	if authReq.CodeHash == nil {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE authorization_requests SET last_polled_at = ?
+			WHERE id = ? AND (? OR last_polled_at IS NULL OR last_polled_at <= ?)`,
+			now, authReq.Id, req.LongPoll, now.Add(-DevicePollInterval),
+		)
+		if err != nil {
+			return errtrace.Errorf("failed to save device poll time: %w", err)
+		}
+		polled, err := res.RowsAffected()
+		if err != nil {
+			return errtrace.Errorf("failed to save device poll time: %w", err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return errtrace.Errorf("failed to commit transaction: %w", err)
+		}
+		if polled == 0 {
+			return oauthError(c, http.StatusBadRequest, OAuthError_SlowDown, "")
+		}
+		return oauthError(c, http.StatusBadRequest, OAuthError_AuthorizationPending, "")
+	}
+
+	// Consume authorization request and start session
+	res, err := tx.ExecContext(ctx, "DELETE FROM authorization_requests WHERE id = ?", authReq.Id)
+	if err != nil {
+		return errtrace.Errorf("failed to delete authorization request (id: %d): %w", authReq.Id, err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "")
+	}
+	tokens, err := createSession(ctx, tx, ct.JwtProvider, companyId, *authReq.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, newOidcTokenResponse(tokens)))
+}
+
+// getPublicClientCompanyId returns the company of the client id, or 0 if it does not exist or did not enable the device flow
+func getPublicClientCompanyId(ctx context.Context, db *sql.DB, clientId string) (uint, error) {
+	companyId, ok := parseOidcClientId(clientId)
+	if !ok {
+		return 0, nil
+	}
+	var enabled bool
+	err := sqlscan.Get(ctx, db, &enabled, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ? AND device_flow_enabled)", companyId)
+	if err != nil {
+		return 0, errtrace.Errorf("failed to check if company enabled the device flow: %w", err)
+	}
+	if !enabled {
+		return 0, nil
+	}
+	return companyId, nil
+}
+
+// getDeviceAuthorizationRequest returns the pending device authorization request of the user code, sql.ErrNoRows if there is none
+func getDeviceAuthorizationRequest(ctx context.Context, db *sql.DB, userCode string) (entity.AuthorizationRequest, error) {
+	var authReq entity.AuthorizationRequest
+	err := sqlscan.Get(ctx, db, &authReq,
+		`SELECT id, company_id, flow, token, redirect_uri, user_code, device_info, confirmed_at, created_at, expired_at FROM authorization_requests
+		WHERE user_code = ? AND flow = ? AND code_hash IS NULL AND expired_at > ? LIMIT 1`,
+		normalizeUserCode(userCode), AuthorizationFlow_Device, time.Now().UTC(),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.AuthorizationRequest{}, errtrace.Wrap(err)
+		}
+		return entity.AuthorizationRequest{}, errtrace.Errorf("failed to get device authorization request: %w", err)
+	}
+	return authReq, nil
+}
+
+// newUserCodeRateLimiter limits the user codes checked by a client ip, entering the form is not an attempt
+func newUserCodeRateLimiter() echo.MiddlewareFunc {
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.Request().Method == http.MethodGet && c.QueryParam("user_code") == ""
+		},
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:  rate.Every(DeviceUserCodeAttemptsInterval),
+			Burst: DeviceUserCodeAttemptsBurst,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, _ string, _ error) error {
+			return renderTemplate(c, http.StatusTooManyRequests, "device.html", deviceCodePage{Error: MsgUserCodeAttemptsExceeded})
+		},
+	})
+}
+
+// truncateDeviceInfo keeps the start of the user agent of the device, without cutting a character
+func truncateDeviceInfo(userAgent string) string {
+	if len(userAgent) <= DeviceInfoMaxLength {
+		return userAgent
+	}
+	return strings.ToValidUTF8(userAgent[:DeviceInfoMaxLength], "")
+}
+
+func generateUserCode() (string, error) {
+	userCode := make([]byte, UserCodeLength)
+	max := big.NewInt(int64(len(UserCodeCharset)))
+	for i := range userCode {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		userCode[i] = UserCodeCharset[n.Int64()]
+	}
+	return string(userCode), nil
+}
+
+// formatUserCode splits the user code in two halves to be easier to read, e.g. WDJB-MJHT
+func formatUserCode(userCode string) string {
+	return userCode[:UserCodeLength/2] + "-" + userCode[UserCodeLength/2:]
+}
+
+// normalizeUserCode accepts user codes typed in lowercase, with or without separators
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
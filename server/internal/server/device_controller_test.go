@@ -0,0 +1,246 @@
+package server_test
+
+import (
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/crypto_ext"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceController(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+	clientId := server.OidcClientId(server_testing.CompanyId)
+	requestTokenRegexp := regexp.MustCompile(`const requestToken = "([0-9a-f]+)"`)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		_, err := s.DeviceCtrl.DB.Exec("UPDATE companies SET device_flow_enabled = TRUE WHERE id = ?", server_testing.CompanyId)
+		require.NoError(t, err)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	sendForm := func(t *testing.T, s server.Server, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	sendGet := func(t *testing.T, s server.Server, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	authorize := func(t *testing.T, s server.Server) server.DeviceController_AuthorizeResponse {
+		res := sendForm(t, s, server.DeviceAuthorizePath, url.Values{"client_id": {clientId}})
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.DeviceController_AuthorizeResponse](t, res.Body)
+	}
+	poll := func(t *testing.T, s server.Server, deviceCode string, longPoll bool) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {server.DeviceGrantType}, "client_id": {clientId}, "device_code": {deviceCode}}
+		if longPoll {
+			form.Set("long_poll", "true")
+		}
+		return sendForm(t, s, server.DeviceTokenPath, form)
+	}
+	requireOAuthError := func(t *testing.T, res *httptest.ResponseRecorder, code string) {
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, code, echo_ext.ReadBody[server.OAuthErrorResponse](t, res.Body).Error)
+	}
+	// confirm confirms the device on the page of the verification uri and returns the login page
+	confirm := func(t *testing.T, s server.Server, verificationUri string) string {
+		u, err := url.Parse(verificationUri)
+		require.NoError(t, err)
+		res := sendGet(t, s, u.RequestURI())
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.NotRegexp(t, requestTokenRegexp, res.Body.String())
+
+		res = sendForm(t, s, server.DeviceConfirmPath, url.Values{"user_code": {u.Query().Get("user_code")}})
+		require.Equal(t, http.StatusOK, res.Code)
+		return res.Body.String()
+	}
+	// signIn confirms the device and signs in with the wallet on the page of the verification uri
+	signIn := func(t *testing.T, s server.Server, verificationUri string) string {
+		matches := requestTokenRegexp.FindStringSubmatch(confirm(t, s, verificationUri))
+		require.Len(t, matches, 2)
+		requestToken := matches[1]
+
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: requestToken, WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.OidcController_AuthorizeChallengeResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/verify", nil,
+			server.OidcController_AuthorizeVerifyRequest{RequestToken: requestToken, Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.OidcController_AuthorizeVerifyResponse](t, res.Body).RedirectUri
+	}
+	requireSession := func(t *testing.T, s server.Server, res *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusOK, res.Code)
+		tokens := echo_ext.ReadBody[server.DeviceController_TokenResponse](t, res.Body)
+		claims, err := s.DeviceCtrl.JwtProvider.GetClaims(tokens.AccessToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, claims.Subject)
+		assert.NotEmpty(t, tokens.RefreshToken)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, device.UserCode)
+		assert.Equal(t, "http://localhost:3000/device", device.VerificationUri)
+		assert.Equal(t, device.VerificationUri+"?user_code="+device.UserCode, device.VerificationUriComplete)
+		assert.Equal(t, int64(server.DevicePollInterval.Seconds()), device.Interval)
+
+		requireOAuthError(t, poll(t, s, device.DeviceCode, false), server.OAuthError_AuthorizationPending)
+
+		assert.Equal(t, server.DeviceCompletePath, signIn(t, s, device.VerificationUriComplete))
+		requireSession(t, s, poll(t, s, device.DeviceCode, false))
+
+		// Device codes can only be used once
+		requireOAuthError(t, poll(t, s, device.DeviceCode, false), server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("UserCodeIsNormalized", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		userCode := strings.ToLower(strings.ReplaceAll(device.UserCode, "-", " "))
+		signIn(t, s, server.DevicePath+"?"+url.Values{"user_code": {userCode}}.Encode())
+		requireSession(t, s, poll(t, s, device.DeviceCode, false))
+	}))
+
+	t.Run("UserCodeForm", newTest(func(t *testing.T, s server.Server) {
+		res := sendGet(t, s, server.DevicePath)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `name="user_code"`)
+	}))
+
+	t.Run("UserCodeDoesNotExist", newTest(func(t *testing.T, s server.Server) {
+		res := sendGet(t, s, server.DevicePath+"?user_code=BCDF-GHJK")
+		require.Equal(t, http.StatusNotFound, res.Code)
+		assert.Contains(t, res.Body.String(), server.MsgUserCodeDoesNotExistOrExpired)
+
+		res = sendForm(t, s, server.DeviceConfirmPath, url.Values{"user_code": {"BCDF-GHJK"}})
+		require.Equal(t, http.StatusNotFound, res.Code)
+		assert.Contains(t, res.Body.String(), server.MsgUserCodeDoesNotExistOrExpired)
+	}))
+
+	t.Run("ConfirmPageShowsDevice", newTest(func(t *testing.T, s server.Server) {
+		req := httptest.NewRequest(http.MethodPost, server.DeviceAuthorizePath, strings.NewReader(url.Values{"client_id": {clientId}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "SmartTV/1.0")
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		device := echo_ext.ReadBody[server.DeviceController_AuthorizeResponse](t, res.Body)
+
+		res = sendGet(t, s, server.DevicePath+"?"+url.Values{"user_code": {device.UserCode}}.Encode())
+		require.Equal(t, http.StatusOK, res.Code)
+		body := res.Body.String()
+		assert.Contains(t, body, device.UserCode)
+		assert.Contains(t, body, "SmartTV/1.0")
+		assert.Contains(t, body, `action="`+server.DeviceConfirmPath+`"`)
+		assert.NotRegexp(t, requestTokenRegexp, body)
+	}))
+
+	t.Run("DeviceIsNotConfirmed", newTest(func(t *testing.T, s server.Server) {
+		authorize(t, s)
+		var requestToken string
+		err := s.DeviceCtrl.DB.QueryRow("SELECT token FROM authorization_requests").Scan(&requestToken)
+		require.NoError(t, err)
+
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: requestToken, WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.Contains(t, res.Body.String(), server.MsgDeviceIsNotConfirmed)
+	}))
+
+	t.Run("UserCodeAttemptsExceeded", newTest(func(t *testing.T, s server.Server) {
+		for range server.DeviceUserCodeAttemptsBurst {
+			res := sendGet(t, s, server.DevicePath+"?user_code=BCDF-GHJK")
+			require.Equal(t, http.StatusNotFound, res.Code)
+		}
+		res := sendForm(t, s, server.DeviceConfirmPath, url.Values{"user_code": {"BCDF-GHJK"}})
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+		assert.Contains(t, res.Body.String(), server.MsgUserCodeAttemptsExceeded)
+
+		// Entering the code is not an attempt
+		res = sendGet(t, s, server.DevicePath)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("SlowDown", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		requireOAuthError(t, poll(t, s, device.DeviceCode, false), server.OAuthError_AuthorizationPending)
+		requireOAuthError(t, poll(t, s, device.DeviceCode, false), server.OAuthError_SlowDown)
+	}))
+
+	t.Run("Expired", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		_, err := s.DeviceCtrl.DB.Exec("UPDATE authorization_requests SET expired_at = ?", time.Now().UTC().Add(-time.Minute))
+		require.NoError(t, err)
+
+		requireOAuthError(t, poll(t, s, device.DeviceCode, false), server.OAuthError_ExpiredToken)
+		res := sendGet(t, s, server.DevicePath+"?"+url.Values{"user_code": {device.UserCode}}.Encode())
+		require.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("LongPoll", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		signedIn := make(chan struct{})
+		go func() {
+			defer close(signedIn)
+			time.Sleep(500 * time.Millisecond)
+			signIn(t, s, device.VerificationUriComplete)
+		}()
+
+		start := time.Now()
+		res := poll(t, s, device.DeviceCode, true)
+		<-signedIn
+		assert.Less(t, time.Since(start), server.DeviceLongPollTimeout)
+		requireSession(t, s, res)
+	}))
+
+	t.Run("InvalidDeviceCode", newTest(func(t *testing.T, s server.Server) {
+		requireOAuthError(t, poll(t, s, "invalid", false), server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("UnsupportedGrantType", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		res := sendForm(t, s, server.DeviceTokenPath, url.Values{"grant_type": {"authorization_code"}, "client_id": {clientId}, "device_code": {device.DeviceCode}})
+		requireOAuthError(t, res, server.OAuthError_UnsupportedGrantType)
+	}))
+
+	t.Run("InvalidClient", newTest(func(t *testing.T, s server.Server) {
+		res := sendForm(t, s, server.DeviceAuthorizePath, url.Values{"client_id": {server.OidcClientId(999)}})
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, server.OAuthError_InvalidClient, echo_ext.ReadBody[server.OAuthErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("DeviceFlowIsNotEnabled", newTest(func(t *testing.T, s server.Server) {
+		device := authorize(t, s)
+		_, err := s.DeviceCtrl.DB.Exec("UPDATE companies SET device_flow_enabled = FALSE")
+		require.NoError(t, err)
+
+		res := sendForm(t, s, server.DeviceAuthorizePath, url.Values{"client_id": {clientId}})
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, server.OAuthError_InvalidClient, echo_ext.ReadBody[server.OAuthErrorResponse](t, res.Body).Error)
+
+		res = poll(t, s, device.DeviceCode, false)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	}))
+}
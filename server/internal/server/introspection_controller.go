@@ -0,0 +1,115 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"gatekeeper/pkg/jwt_provider"
+	"net/http"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+type IntrospectionController struct {
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+}
+
+func NewIntrospectionController(echoGrp *echo.Group, i *do.Injector) IntrospectionController {
+	ct := IntrospectionController{
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+	}
+
+	echoGrp.POST("/introspect", ct.Introspect, NewApiKeyMiddleware(i, ApiKeyScope_TokensIntrospect))
+
+	return ct
+}
+
+// Field names follow RFC 7662, requests can be sent as a form or as JSON
+type IntrospectionController_IntrospectRequest struct {
+	Token string `json:"token" form:"token" validate:"required"`
+	// Only proof tokens can be introspected, the hint is accepted but ignored as allowed by the RFC
+	TokenTypeHint   string `json:"token_type_hint" form:"token_type_hint"`
+	IncludeMetadata bool   `json:"include_metadata" form:"include_metadata"`
+}
+
+type IntrospectionController_IntrospectResponse struct {
+	Active bool `json:"active"`
+	// Set when the token is valid for the company but has been revoked, either itself or through its session
+	Revoked bool `json:"revoked,omitempty"`
+
+	Sub string `json:"sub,omitempty"`
+	Aud string `json:"aud,omitempty"`
+	Iss string `json:"iss,omitempty"`
+	Jti string `json:"jti,omitempty"`
+	Sid string `json:"sid,omitempty"`
+	Exp int64  `json:"exp,omitempty"`
+	Iat int64  `json:"iat,omitempty"`
+	Nbf int64  `json:"nbf,omitempty"`
+
+	// Metadata of the account of the wallet, only set if requested and the account exists
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Introspect tells whether a proof token is active for the company of the api key (RFC 7662).
+// Invalid, expired or other companies' tokens are all reported as inactive.
+func (ct IntrospectionController) Introspect(c echo.Context) error {
+	req, err := bindAndValidate[IntrospectionController_IntrospectRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	companyId := getContextValue[uint](c, ContextKey_CompanyId)
+	// Responses must not be cached as the token can be revoked at any time
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	claims, err := ct.JwtProvider.GetClaims(req.Token, ProofTokenAudience(companyId))
+	if err != nil || claims.Subject == "" {
+		return errtrace.Wrap(c.JSON(http.StatusOK, IntrospectionController_IntrospectResponse{Active: false}))
+	}
+	revoked, err := isProofTokenRevoked(ctx, ct.DB, claims)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errtrace.Wrap(c.JSON(http.StatusOK, IntrospectionController_IntrospectResponse{Active: false, Revoked: true}))
+	}
+
+	res := IntrospectionController_IntrospectResponse{
+		Active: true,
+		Sub:    claims.Subject,
+		Aud:    ProofTokenAudience(companyId),
+		Iss:    claims.Issuer,
+		Jti:    claims.ID,
+		Sid:    claims.SessionId,
+		Exp:    claims.ExpiresAt.Unix(),
+	}
+	if claims.IssuedAt != nil {
+		res.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		res.Nbf = claims.NotBefore.Unix()
+	}
+
+	// Get account metadata, deleted accounts are left out like in the account api
+	if req.IncludeMetadata {
+		var metadataBytes []byte
+		err := sqlscan.Get(ctx, ct.DB, &metadataBytes,
+			"SELECT metadata FROM accounts WHERE company_id = ? AND wallet_address = ? AND deleted_at IS NULL LIMIT 1", companyId, claims.Subject,
+		)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return errtrace.Errorf("failed to get account metadata: %w", err)
+		}
+		if len(metadataBytes) > 0 {
+			err = decodeJson(metadataBytes, &res.Metadata)
+			if err != nil {
+				return errtrace.Errorf("failed to unmarshal metadata: %w", err)
+			}
+		}
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
@@ -0,0 +1,162 @@
+package server_test
+
+import (
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectionController_Introspect(t *testing.T) {
+	walletAddress, _ := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, i *do.Injector, s server.Server)) func(t *testing.T) {
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, i, s) }
+	}
+	sendReqWithApiKey := func(t *testing.T, e *echo.Echo, apiKey string, req server.IntrospectionController_IntrospectRequest) server.IntrospectionController_IntrospectResponse {
+		res := echo_ext.SendTestRequest(
+			t, e, http.MethodPost, "/v1/introspect",
+			map[string]string{"Api-Key": apiKey},
+			req,
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "no-store", res.Header().Get("Cache-Control"))
+		return echo_ext.ReadBody[server.IntrospectionController_IntrospectResponse](t, res.Body)
+	}
+	sendReq := func(t *testing.T, e *echo.Echo, req server.IntrospectionController_IntrospectRequest) server.IntrospectionController_IntrospectResponse {
+		return sendReqWithApiKey(t, e, server_testing.ApiKey, req)
+	}
+	newProofToken := func(t *testing.T, i *do.Injector, expiredAt time.Time) string {
+		return server_testing.GenerateProofToken(t, i, server_testing.CompanyId, walletAddress, expiredAt)
+	}
+
+	t.Run("Active", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		expiredAt := time.Now().Add(time.Minute)
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{Token: newProofToken(t, i, expiredAt)})
+		assert.True(t, body.Active)
+		assert.False(t, body.Revoked)
+		assert.Equal(t, walletAddress, body.Sub)
+		assert.Equal(t, server.ProofTokenAudience(server_testing.CompanyId), body.Aud)
+		assert.Equal(t, s.Config.IssuerUrl, body.Iss)
+		assert.NotEmpty(t, body.Jti)
+		assert.Equal(t, expiredAt.Unix(), body.Exp)
+		assert.Nil(t, body.Metadata)
+	}))
+
+	t.Run("FormEncoded", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		form := url.Values{"token": {newProofToken(t, i, time.Now().Add(time.Minute))}, "token_type_hint": {"access_token"}}
+		req := httptest.NewRequest(http.MethodPost, "/v1/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set("Api-Key", server_testing.ApiKey)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.IntrospectionController_IntrospectResponse](t, res.Body)
+		assert.True(t, body.Active)
+		assert.Equal(t, walletAddress, body.Sub)
+	}))
+
+	t.Run("IncludeMetadata", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		proofToken := newProofToken(t, i, time.Now().Add(time.Minute))
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/accounts",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": proofToken},
+			map[string]any{"walletAddress": walletAddress, "metadata": map[string]any{"email": "client@gatekeeper.com"}},
+		)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{Token: proofToken, IncludeMetadata: true})
+		assert.True(t, body.Active)
+		assert.Equal(t, map[string]any{"email": "client@gatekeeper.com"}, body.Metadata)
+	}))
+
+	t.Run("IncludeMetadataLargeNumber", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		account := server_testing.CreateAccount(t, i, server_testing.CompanyId, []byte(`{"id":9007199254740993}`))
+		proofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId, account.WalletAddress, time.Now().Add(time.Minute))
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/introspect",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.IntrospectionController_IntrospectRequest{Token: proofToken, IncludeMetadata: true},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `"metadata":{"id":9007199254740993}`)
+	}))
+
+	t.Run("IncludeMetadataAccountIsDeleted", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		account := server_testing.CreateAccount(t, i, server_testing.CompanyId, []byte(`{"email":"client@gatekeeper.com"}`))
+		_, err := s.AccountCtrl.DB.Exec("UPDATE accounts SET deleted_at = ? WHERE wallet_address = ?", time.Now().UTC(), account.WalletAddress)
+		require.NoError(t, err)
+
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{
+			Token:           server_testing.GenerateProofToken(t, i, server_testing.CompanyId, account.WalletAddress, time.Now().Add(time.Minute)),
+			IncludeMetadata: true,
+		})
+		assert.True(t, body.Active)
+		assert.Nil(t, body.Metadata)
+	}))
+
+	t.Run("IncludeMetadataAccountDoesNotExist", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{
+			Token:           newProofToken(t, i, time.Now().Add(time.Minute)),
+			IncludeMetadata: true,
+		})
+		assert.True(t, body.Active)
+		assert.Nil(t, body.Metadata)
+	}))
+
+	t.Run("Invalid", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{Token: "jiberish"})
+		assert.Equal(t, server.IntrospectionController_IntrospectResponse{Active: false}, body)
+	}))
+
+	t.Run("Expired", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{Token: newProofToken(t, i, time.Now().Add(-time.Minute))})
+		assert.Equal(t, server.IntrospectionController_IntrospectResponse{Active: false}, body)
+	}))
+
+	t.Run("OtherCompany", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.IntrospectionCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.IntrospectionCtrl.DB, otherCompanyId)
+
+		body := sendReqWithApiKey(t, s.Echo, otherApiKey, server.IntrospectionController_IntrospectRequest{
+			Token: newProofToken(t, i, time.Now().Add(time.Minute)),
+		})
+		assert.Equal(t, server.IntrospectionController_IntrospectResponse{Active: false}, body)
+	}))
+
+	t.Run("Revoked", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		proofToken := newProofToken(t, i, time.Now().Add(time.Minute))
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodDelete, "/v1/sessions/current",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": proofToken}, nil,
+		)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		body := sendReq(t, s.Echo, server.IntrospectionController_IntrospectRequest{Token: proofToken})
+		assert.Equal(t, server.IntrospectionController_IntrospectResponse{Active: false, Revoked: true}, body)
+	}))
+
+	t.Run("TokenIsRequired", newTest(func(t *testing.T, i *do.Injector, s server.Server) {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/introspect",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.IntrospectionController_IntrospectRequest{},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	}))
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/jwt_provider"
+	"net/http"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+const LoginPath = "/login"
+
+// Authorization requests are started by an OpenID Connect client, the hosted login page or a device
+type AuthorizationFlow string
+
+const (
+	AuthorizationFlow_Oidc        AuthorizationFlow = "oidc"
+	AuthorizationFlow_HostedLogin AuthorizationFlow = "hosted_login"
+	AuthorizationFlow_Device      AuthorizationFlow = "device"
+)
+
+const (
+	LoginPageDefaultName            = "Sign in with your wallet"
+	LoginPageDefaultPrimaryColor    = "#1a73e8"
+	LoginPageDefaultBackgroundColor = "#ffffff"
+)
+
+const (
+	MsgLoginCodeIsInvalidOrExpired = "Login code is invalid or has expired"
+)
+
+// LoginController serves the hosted login page, the wallet signs a challenge there and is redirected back to the company with a one-time code.
+// The page and the endpoints it signs in with are shared with the OpenID Connect authorization flow.
+type LoginController struct {
+	Config      Config
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+}
+
+func NewLoginController(e *echo.Echo, i *do.Injector) LoginController {
+	ct := LoginController{
+		Config:      do.MustInvoke[Config](i),
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+	}
+
+	e.GET(LoginPath, ct.Login)
+	// Exchanging a code is the hosted counterpart of verifying a challenge
+	e.Group("/v1").POST(LoginPath+"/exchange", ct.Exchange, NewApiKeyMiddleware(i, ApiKeyScope_ChallengesVerify))
+
+	return ct
+}
+
+type LoginController_LoginRequest struct {
+	ClientId    string `query:"client_id"`
+	RedirectUri string `query:"redirect_uri"`
+	State       string `query:"state"`
+}
+
+// Login starts a hosted login and renders the login page
+func (ct LoginController) Login(c echo.Context) error {
+	var req LoginController_LoginRequest
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	companyId, err := getClientCompanyId(c.Request().Context(), ct.DB, req.ClientId, req.RedirectUri)
+	if err != nil {
+		return err
+	}
+
+	authReq := entity.AuthorizationRequest{
+		CompanyId:   companyId,
+		Flow:        string(AuthorizationFlow_HostedLogin),
+		RedirectUri: req.RedirectUri,
+		State:       nullIfEmpty(req.State),
+	}
+	err = createAuthorizationRequest(c.Request().Context(), ct.DB, &authReq)
+	if err != nil {
+		return err
+	}
+
+	return renderLoginPage(c, ct.DB, authReq)
+}
+
+type LoginController_ExchangeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type LoginController_ExchangeResponse = SessionTokens
+
+// Exchange starts the session of a hosted login, the code can only be exchanged once by the company
+func (ct LoginController) Exchange(c echo.Context) error {
+	req, err := bindAndValidate[LoginController_ExchangeRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Get and consume authorization request of the code, OpenID Connect codes must go through the token endpoint
+	var authReq entity.AuthorizationRequest
+	err = sqlscan.Get(ctx, tx, &authReq,
+		`SELECT id, company_id, wallet_address, expired_at FROM authorization_requests
+		WHERE code_hash = ? AND company_id = ? AND flow = ? LIMIT 1`,
+		hashAuthorizationCode(req.Code), getContextValue[uint](c, ContextKey_CompanyId), AuthorizationFlow_HostedLogin,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return NewHTTPError(http.StatusBadRequest, MsgLoginCodeIsInvalidOrExpired)
+		}
+		return errtrace.Errorf("failed to get authorization request: %w", err)
+	}
+	if !authReq.ExpiredAt.After(time.Now()) {
+		return NewHTTPError(http.StatusBadRequest, MsgLoginCodeIsInvalidOrExpired)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM authorization_requests WHERE id = ?", authReq.Id)
+	if err != nil {
+		return errtrace.Errorf("failed to delete authorization request (id: %d): %w", authReq.Id, err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return NewHTTPError(http.StatusBadRequest, MsgLoginCodeIsInvalidOrExpired)
+	}
+
+	// Start session
+	tokens, err := createSession(ctx, tx, ct.JwtProvider, authReq.CompanyId, *authReq.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, tokens))
+}
+
+type loginPage struct {
+	RequestToken    string
+	ChallengeUrl    string
+	VerifyUrl       string
+	Name            string
+	LogoUrl         string
+	PrimaryColor    string
+	BackgroundColor string
+	// Device signing in, for the device flow only
+	UserCode   string
+	DeviceInfo string
+	ConfirmUrl string
+}
+
+// getClientCompanyId validates the client id and checks the redirect uri is registered for its company
+func getClientCompanyId(ctx context.Context, db *sql.DB, clientId string, redirectUri string) (uint, error) {
+	companyId, ok := parseOidcClientId(clientId)
+	if !ok {
+		return 0, NewHTTPError(http.StatusBadRequest, MsgClientIdIsInvalid)
+	}
+	var registered bool
+	err := sqlscan.Get(ctx, db, &registered,
+		"SELECT EXISTS (SELECT 1 FROM redirect_uris WHERE company_id = ? AND uri = ?)", companyId, redirectUri,
+	)
+	if err != nil {
+		return 0, errtrace.Errorf("failed to check if redirect uri is registered: %w", err)
+	}
+	if !registered {
+		return 0, NewHTTPError(http.StatusBadRequest, MsgRedirectUriIsNotRegistered)
+	}
+	return companyId, nil
+}
+
+// createAuthorizationRequest saves the authorization request with a new token
+func createAuthorizationRequest(ctx context.Context, db *sql.DB, authReq *entity.AuthorizationRequest) error {
+	var err error
+	authReq.Token, err = generateAuthorizationRequestToken()
+	if err != nil {
+		return errtrace.Errorf("failed to generate authorization request token: %w", err)
+	}
+	authReq.CreatedAt = time.Now().UTC()
+	authReq.ExpiredAt = authReq.CreatedAt.Add(AuthorizationRequestValidDuration)
+	res, err := db.ExecContext(ctx,
+		`INSERT INTO authorization_requests (company_id, flow, token, redirect_uri, scope, state, nonce, code_challenge, user_code, device_code_hash, device_info,
+		created_at, expired_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		authReq.CompanyId, authReq.Flow, authReq.Token, authReq.RedirectUri, authReq.Scope, authReq.State, authReq.Nonce, authReq.CodeChallenge,
+		authReq.UserCode, authReq.DeviceCodeHash, authReq.DeviceInfo, authReq.CreatedAt, authReq.ExpiredAt,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to save authorization request: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return errtrace.Errorf("failed to get authorization request id: %w", err)
+	}
+	authReq.Id = uint(id)
+	return nil
+}
+
+// renderLoginPage renders the login page of a pending authorization request with the branding of the company
+func renderLoginPage(c echo.Context, db *sql.DB, authReq entity.AuthorizationRequest) error {
+	page, err := newLoginPage(c.Request().Context(), db, authReq)
+	if err != nil {
+		return err
+	}
+	return renderTemplate(c, http.StatusOK, "login.html", page)
+}
+
+// newLoginPage returns the login page of the authorization request with the branding of its company
+func newLoginPage(ctx context.Context, db *sql.DB, authReq entity.AuthorizationRequest) (loginPage, error) {
+	var company entity.Company
+	err := sqlscan.Get(ctx, db, &company,
+		"SELECT name, logo_url, primary_color, background_color FROM companies WHERE id = ?", authReq.CompanyId,
+	)
+	if err != nil {
+		return loginPage{}, errtrace.Errorf("failed to get company branding: %w", err)
+	}
+
+	page := loginPage{
+		RequestToken:    authReq.Token,
+		ChallengeUrl:    OidcAuthorizePath + "/challenge",
+		VerifyUrl:       OidcAuthorizePath + "/verify",
+		Name:            LoginPageDefaultName,
+		PrimaryColor:    LoginPageDefaultPrimaryColor,
+		BackgroundColor: LoginPageDefaultBackgroundColor,
+	}
+	if company.Name != nil {
+		page.Name = *company.Name
+	}
+	if company.LogoUrl != nil {
+		page.LogoUrl = *company.LogoUrl
+	}
+	if company.PrimaryColor != nil {
+		page.PrimaryColor = *company.PrimaryColor
+	}
+	if company.BackgroundColor != nil {
+		page.BackgroundColor = *company.BackgroundColor
+	}
+	if authReq.UserCode != nil {
+		page.UserCode = formatUserCode(*authReq.UserCode)
+		page.ConfirmUrl = DeviceConfirmPath
+	}
+	if authReq.DeviceInfo != nil {
+		page.DeviceInfo = *authReq.DeviceInfo
+	}
+	return page, nil
+}
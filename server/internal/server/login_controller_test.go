@@ -0,0 +1,182 @@
+package server_test
+
+import (
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/crypto_ext"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginController(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+	clientId := server.OidcClientId(server_testing.CompanyId)
+	redirectUri := "http://localhost:4000/callback"
+	requestTokenRegexp := regexp.MustCompile(`const requestToken = "([0-9a-f]+)"`)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	sendGet := func(t *testing.T, s server.Server, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	loginPath := func(params url.Values) string {
+		return server.LoginPath + "?" + params.Encode()
+	}
+	// signIn signs in on the login page and returns the redirect uri with the code
+	signIn := func(t *testing.T, s server.Server, pagePath string) *url.URL {
+		res := sendGet(t, s, pagePath)
+		require.Equal(t, http.StatusOK, res.Code)
+		matches := requestTokenRegexp.FindStringSubmatch(res.Body.String())
+		require.Len(t, matches, 2)
+		requestToken := matches[1]
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: requestToken, WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.OidcController_AuthorizeChallengeResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/verify", nil,
+			server.OidcController_AuthorizeVerifyRequest{RequestToken: requestToken, Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		u, err := url.Parse(echo_ext.ReadBody[server.OidcController_AuthorizeVerifyResponse](t, res.Body).RedirectUri)
+		require.NoError(t, err)
+		return u
+	}
+	exchangeWithApiKey := func(t *testing.T, s server.Server, apiKey string, code string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/login/exchange",
+			map[string]string{"Api-Key": apiKey},
+			server.LoginController_ExchangeRequest{Code: code},
+		)
+	}
+	exchange := func(t *testing.T, s server.Server, code string) *httptest.ResponseRecorder {
+		return exchangeWithApiKey(t, s, server_testing.ApiKey, code)
+	}
+	requireInvalid := func(t *testing.T, res *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgLoginCodeIsInvalidOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}
+	hostedLoginParams := url.Values{"client_id": {clientId}, "redirect_uri": {redirectUri}, "state": {"xyz"}}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		redirect := signIn(t, s, loginPath(hostedLoginParams))
+		assert.Equal(t, redirectUri, redirect.Scheme+"://"+redirect.Host+redirect.Path)
+		assert.Equal(t, "xyz", redirect.Query().Get("state"))
+		code := redirect.Query().Get("code")
+		require.NotEmpty(t, code)
+
+		res := exchange(t, s, code)
+		require.Equal(t, http.StatusOK, res.Code)
+		tokens := echo_ext.ReadBody[server.LoginController_ExchangeResponse](t, res.Body)
+		claims, err := s.LoginCtrl.JwtProvider.GetClaims(tokens.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, claims.Subject)
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		// Codes can only be exchanged once
+		requireInvalid(t, exchange(t, s, code))
+	}))
+
+	t.Run("DefaultBranding", newTest(func(t *testing.T, s server.Server) {
+		res := sendGet(t, s, loginPath(hostedLoginParams))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), server.LoginPageDefaultName)
+		assert.Contains(t, res.Body.String(), server.LoginPageDefaultPrimaryColor)
+		assert.NotContains(t, res.Body.String(), "<img")
+	}))
+
+	t.Run("CompanyBranding", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.LoginCtrl.DB.Exec(
+			"UPDATE companies SET name = ?, logo_url = ?, primary_color = ?, background_color = ? WHERE id = ?",
+			"Odor", "https://odor.com/logo.png", "#ff0000", "#00ff00", server_testing.CompanyId,
+		)
+		require.NoError(t, err)
+
+		res := sendGet(t, s, loginPath(hostedLoginParams))
+		require.Equal(t, http.StatusOK, res.Code)
+		body := res.Body.String()
+		assert.Contains(t, body, "<h1>Odor</h1>")
+		assert.Contains(t, body, `<img src="https://odor.com/logo.png" alt="Odor">`)
+		assert.Contains(t, body, "#ff0000")
+		assert.Contains(t, body, "#00ff00")
+	}))
+
+	t.Run("BrandingIsEscaped", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.LoginCtrl.DB.Exec(
+			"UPDATE companies SET name = ?, logo_url = ?, background_color = ? WHERE id = ?",
+			"<script>alert(1)</script>", "javascript:alert(1)", "red;}</style><script>alert(1)</script>", server_testing.CompanyId,
+		)
+		require.NoError(t, err)
+
+		res := sendGet(t, s, loginPath(hostedLoginParams))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.NotContains(t, res.Body.String(), "<script>alert(1)")
+		assert.NotContains(t, res.Body.String(), "javascript:alert(1)")
+	}))
+
+	t.Run("OidcCodeCannotBeExchanged", newTest(func(t *testing.T, s server.Server) {
+		params := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientId},
+			"redirect_uri":          {redirectUri},
+			"scope":                 {"openid"},
+			"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+			"code_challenge_method": {"S256"},
+		}
+		code := signIn(t, s, server.OidcAuthorizePath+"?"+params.Encode()).Query().Get("code")
+		requireInvalid(t, exchange(t, s, code))
+	}))
+
+	t.Run("CodeExpired", newTest(func(t *testing.T, s server.Server) {
+		code := signIn(t, s, loginPath(hostedLoginParams)).Query().Get("code")
+		_, err := s.LoginCtrl.DB.Exec("UPDATE authorization_requests SET expired_at = ?", time.Now().UTC().Add(-time.Minute))
+		require.NoError(t, err)
+		requireInvalid(t, exchange(t, s, code))
+	}))
+
+	t.Run("OtherCompany", newTest(func(t *testing.T, s server.Server) {
+		code := signIn(t, s, loginPath(hostedLoginParams)).Query().Get("code")
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.LoginCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.LoginCtrl.DB, otherCompanyId)
+
+		requireInvalid(t, exchangeWithApiKey(t, s, otherApiKey, code))
+	}))
+
+	t.Run("RedirectUriIsNotRegistered", newTest(func(t *testing.T, s server.Server) {
+		res := sendGet(t, s, loginPath(url.Values{"client_id": {clientId}, "redirect_uri": {"https://attacker.com"}}))
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgRedirectUriIsNotRegistered, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("RequestAlreadyVerified", newTest(func(t *testing.T, s server.Server) {
+		res := sendGet(t, s, loginPath(hostedLoginParams))
+		require.Equal(t, http.StatusOK, res.Code)
+		requestToken := requestTokenRegexp.FindStringSubmatch(res.Body.String())[1]
+		_, err := s.LoginCtrl.DB.Exec("UPDATE authorization_requests SET code_hash = 'used' WHERE token = ?", requestToken)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: requestToken, WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	}))
+}
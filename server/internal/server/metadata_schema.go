@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gatekeeper/internal/entity"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/gookit/validate"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	MsgMetadataSchemaIsInvalid = "Metadata schema is invalid"
+)
+
+// Schemas are compiled from memory, they can't reference other documents
+const metadataSchemaUrl = "mem://metadata-schema.json"
+
+// Field of the validation errors of the metadata, the path of the invalid value is appended to it
+const metadataErrorField = "metadata"
+
+// Versions of a metadata schema can't be changed, so they are only compiled once.
+// Metadata is validated against the latest version, so only the latest version of each company is kept, keyed by company id.
+var compiledMetadataSchemas sync.Map
+
+type compiledMetadataSchema struct {
+	Version  uint
+	Schema   []byte
+	Compiled *jsonschema.Schema
+}
+
+type MetadataSchemaRequest struct {
+	// JSON Schema of the metadata, the dialect is draft 2020-12 unless $schema is set
+	Schema json.RawMessage `json:"schema" validate:"-"`
+}
+
+type MetadataSchemaResponse struct {
+	Version   uint            `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type MetadataSchemasResponse struct {
+	MetadataSchemas []MetadataSchemaResponse `json:"metadataSchemas"`
+}
+
+func listMetadataSchemas(ctx context.Context, db sqlscan.Querier, companyId uint) ([]MetadataSchemaResponse, error) {
+	var schemas []entity.MetadataSchema
+	err := sqlscan.Select(ctx, db, &schemas,
+		"SELECT id, company_id, version, schema, created_at FROM metadata_schemas WHERE company_id = ? ORDER BY version",
+		companyId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to list metadata schemas: %w", err)
+	}
+
+	res := make([]MetadataSchemaResponse, 0, len(schemas))
+	for _, schema := range schemas {
+		res = append(res, newMetadataSchemaResponse(schema))
+	}
+	return res, nil
+}
+
+func getMetadataSchema(ctx context.Context, db sqlscan.Querier, companyId uint, version uint) (MetadataSchemaResponse, error) {
+	var schema entity.MetadataSchema
+	err := sqlscan.Get(ctx, db, &schema,
+		"SELECT id, company_id, version, schema, created_at FROM metadata_schemas WHERE company_id = ? AND version = ? LIMIT 1",
+		companyId, version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MetadataSchemaResponse{}, ErrNotFound
+		}
+		return MetadataSchemaResponse{}, errtrace.Errorf("failed to get metadata schema: %w", err)
+	}
+	return newMetadataSchemaResponse(schema), nil
+}
+
+// createMetadataSchema adds the next version of the metadata schema of the company.
+// Existing accounts keep the version they were validated against until their metadata is updated or migrated.
+func createMetadataSchema(ctx context.Context, db *sql.DB, companyId uint, req MetadataSchemaRequest) (MetadataSchemaResponse, error) {
+	_, err := compileMetadataSchema(req.Schema)
+	if err != nil {
+		return MetadataSchemaResponse{}, NewHTTPError(http.StatusBadRequest, MsgMetadataSchemaIsInvalid)
+	}
+
+	var exists bool
+	err = sqlscan.Get(ctx, db, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return MetadataSchemaResponse{}, errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return MetadataSchemaResponse{}, ErrNotFound
+	}
+
+	schema := entity.MetadataSchema{CompanyId: companyId, Schema: req.Schema, CreatedAt: time.Now().UTC()}
+	err = sqlscan.Get(ctx, db, &schema.Version,
+		`INSERT INTO metadata_schemas (company_id, version, schema, created_at)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ? FROM metadata_schemas WHERE company_id = ?
+		RETURNING version`,
+		companyId, schema.Schema, schema.CreatedAt, companyId,
+	)
+	if err != nil {
+		return MetadataSchemaResponse{}, errtrace.Errorf("failed to create metadata schema: %w", err)
+	}
+
+	return newMetadataSchemaResponse(schema), nil
+}
+
+func newMetadataSchemaResponse(schema entity.MetadataSchema) MetadataSchemaResponse {
+	return MetadataSchemaResponse{
+		Version:   schema.Version,
+		Schema:    schema.Schema,
+		CreatedAt: schema.CreatedAt,
+	}
+}
+
+// validateMetadata validates the metadata against the latest metadata schema of the company and returns its version.
+// Metadata of companies without a schema is not validated, the returned version is nil.
+func validateMetadata(ctx context.Context, db sqlscan.Querier, companyId uint, metadata map[string]any) (*uint, error) {
+	var schemas []entity.MetadataSchema
+	err := sqlscan.Select(ctx, db, &schemas,
+		"SELECT company_id, version, schema FROM metadata_schemas WHERE company_id = ? ORDER BY version DESC LIMIT 1", companyId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to get latest metadata schema: %w", err)
+	}
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+
+	compiled, err := getCompiledMetadataSchema(schemas[0])
+	if err != nil {
+		return nil, err
+	}
+	err = compiled.Validate(metadata)
+	if err != nil {
+		var validationErr *jsonschema.ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil, errtrace.Errorf("failed to validate metadata: %w", err)
+		}
+		errs := validate.Errors{}
+		addMetadataValidationErrors(errs, validationErr)
+		return nil, NewValidationErrorResponse(errs)
+	}
+
+	return &schemas[0].Version, nil
+}
+
+// getCompiledMetadataSchema returns the compiled metadata schema from the cache, or compiles it and replaces the previous version of the company
+func getCompiledMetadataSchema(schema entity.MetadataSchema) (*jsonschema.Schema, error) {
+	// The cache outlives the database it was filled from, e.g. every test creates a new database with the same company ids and versions,
+	// so the cached schema must also be the same document
+	if cached, ok := compiledMetadataSchemas.Load(schema.CompanyId); ok {
+		cached := cached.(compiledMetadataSchema)
+		if cached.Version == schema.Version && bytes.Equal(cached.Schema, schema.Schema) {
+			return cached.Compiled, nil
+		}
+	}
+
+	compiled, err := compileMetadataSchema(schema.Schema)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to compile metadata schema (company id: %d, version: %d): %w", schema.CompanyId, schema.Version, err)
+	}
+	compiledMetadataSchemas.Store(schema.CompanyId, compiledMetadataSchema{Version: schema.Version, Schema: schema.Schema, Compiled: compiled})
+	return compiled, nil
+}
+
+func compileMetadataSchema(schema []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, errtrace.Errorf("metadata schema can't reference %s", url)
+	}
+	err := compiler.AddResource(metadataSchemaUrl, bytes.NewReader(schema))
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	return errtrace.Wrap2(compiler.Compile(metadataSchemaUrl))
+}
+
+// addMetadataValidationErrors adds the leaves of the validation error, keyed by the path of the invalid value and the failed keyword
+func addMetadataValidationErrors(errs validate.Errors, err *jsonschema.ValidationError) {
+	if len(err.Causes) > 0 {
+		for _, cause := range err.Causes {
+			addMetadataValidationErrors(errs, cause)
+		}
+		return
+	}
+
+	field := metadataErrorField
+	if err.InstanceLocation != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(err.InstanceLocation, "/"), "/") {
+			field += "." + strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		}
+	}
+	keyword := err.KeywordLocation[strings.LastIndex(err.KeywordLocation, "/")+1:]
+	errs.Add(field, keyword, err.Message)
+}
@@ -1,55 +1,123 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
-	"errors"
 	"gatekeeper/pkg/jwt_provider"
 	"net/http"
+	"strconv"
+	"time"
 
 	"braces.dev/errtrace"
 	"github.com/georgysavva/scany/sqlscan"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/samber/do"
 )
 
+// Browsers cache preflight responses for at most 10 minutes
+const CorsMaxAge = 10 * time.Minute
+
 const (
 	MsgApiKeyIsInvalid              = "Api key is invalid"
+	MsgApiKeyScopeIsMissing         = "Api key is missing the scope of this route"
+	MsgOriginIsNotAllowed           = "Origin is not allowed"
+	MsgAdminApiKeyIsInvalid         = "Admin api key is invalid"
 	MsgProofTokenIsInvalidOrExpired = "Proof token is invalid or has expired"
+	MsgOwnerTokenIsInvalidOrExpired = "Owner token is invalid or has expired"
 )
 
-func NewApiKeyMiddleware(i *do.Injector) echo.MiddlewareFunc {
+// NewApiKeyMiddleware only lets through api keys with the scope of the route
+func NewApiKeyMiddleware(i *do.Injector, scope ApiKeyScope) echo.MiddlewareFunc {
+	config := do.MustInvoke[Config](i)
 	db := do.MustInvoke[*sql.DB](i)
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			apiKey := c.Request().Header.Get("Api-Key")
+			// Check if api key is active and extract company id
+			apiKey, ok, err := authenticateApiKey(c.Request().Context(), db, config.ApiKeyPepper, c.Request().Header.Get("Api-Key"))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return NewHTTPError(http.StatusBadRequest, MsgApiKeyIsInvalid)
+			}
+			if !hasApiKeyScope(apiKey.Scopes, scope) {
+				return NewHTTPError(http.StatusForbidden, MsgApiKeyScopeIsMissing)
+			}
+
+			// Publishable keys are public, browsers attest they are used by a page of the company through the origin
+			if apiKey.Publishable {
+				allowed, err := isOriginAllowed(c.Request().Context(), db, apiKey.CompanyId, c.Request().Header.Get(echo.HeaderOrigin))
+				if err != nil {
+					return err
+				}
+				if !allowed {
+					return NewHTTPError(http.StatusForbidden, MsgOriginIsNotAllowed)
+				}
+			}
 
-			// Check if api key exists and extract company id
-			var companyId uint
-			err := sqlscan.Get(c.Request().Context(), db, &companyId,
-				"SELECT id FROM companies WHERE api_key = ?", apiKey,
+			setContextValue(c, ContextKey_CompanyId, apiKey.CompanyId)
+			setContextValue(c, ContextKey_ApiKeyId, apiKey.Id)
+
+			return next(c)
+		}
+	}
+}
+
+// NewCorsMiddleware lets pages of the allowed origins of any company call the route from the browser.
+// Preflight requests don't carry the api key, the origin is checked against the company of the key by NewApiKeyMiddleware.
+func NewCorsMiddleware(i *do.Injector) echo.MiddlewareFunc {
+	db := do.MustInvoke[*sql.DB](i)
+
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			var allowed bool
+			err := sqlscan.Get(context.Background(), db, &allowed,
+				"SELECT EXISTS (SELECT 1 FROM allowed_origins WHERE origin = ?)", origin,
 			)
 			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					return NewHTTPError(http.StatusBadRequest, MsgApiKeyIsInvalid)
-				}
-				return errtrace.Errorf("failed to check if api key exists: %w", err)
+				return false, errtrace.Errorf("failed to check if origin is allowed: %w", err)
 			}
+			return allowed, nil
+		},
+		AllowMethods: []string{http.MethodPost},
+		AllowHeaders: []string{echo.HeaderContentType, "Api-Key"},
+		MaxAge:       int(CorsMaxAge.Seconds()),
+	})
+}
 
-			setContextValue(c, ContextKey_CompanyId, companyId)
+// NewAdminApiKeyMiddleware protects the admin api, it is disabled when no admin api key is configured
+func NewAdminApiKeyMiddleware(i *do.Injector) echo.MiddlewareFunc {
+	adminApiKey := do.MustInvoke[Config](i).AdminApiKey
 
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := c.Request().Header.Get("Admin-Api-Key")
+			if adminApiKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(adminApiKey)) != 1 {
+				return NewHTTPError(http.StatusUnauthorized, MsgAdminApiKeyIsInvalid)
+			}
 			return next(c)
 		}
 	}
 }
 
+// ProofTokenAudience returns the aud claim of proof tokens issued for a company
+func ProofTokenAudience(companyId uint) string {
+	return strconv.FormatUint(uint64(companyId), 10)
+}
+
+// NewProofTokenMiddleware must run after NewApiKeyMiddleware, proof tokens are only accepted by the company they were issued for
 func NewProofTokenMiddleware(i *do.Injector) echo.MiddlewareFunc {
+	db := do.MustInvoke[*sql.DB](i)
 	jwtProvider := do.MustInvoke[jwt_provider.Provider](i)
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// Check if proof token is invalid or has expired and extract wallet address
-			claims, err := jwtProvider.GetClaims(c.Request().Header.Get("Proof-Token"))
+			audience := ProofTokenAudience(getContextValue[uint](c, ContextKey_CompanyId))
+			claims, err := jwtProvider.GetClaims(c.Request().Header.Get("Proof-Token"), audience)
 			if err != nil {
 				return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
 			}
@@ -61,9 +129,100 @@ func NewProofTokenMiddleware(i *do.Injector) echo.MiddlewareFunc {
 				return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
 			}
 
+			// Check if proof token or its session was revoked
+			revoked, err := isProofTokenRevoked(c.Request().Context(), db, claims)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return NewHTTPError(http.StatusBadRequest, MsgProofTokenIsInvalidOrExpired)
+			}
+
+			setContextValue(c, ContextKey_WalletAddress, walletAddress)
+			setContextValue(c, ContextKey_ProofTokenClaims, claims)
+
+			return next(c)
+		}
+	}
+}
+
+// OwnerTokenAudience returns the aud claim of owner tokens issued for a company, they can't be used as proof tokens
+func OwnerTokenAudience(companyId uint) string {
+	return "owner:" + strconv.FormatUint(uint64(companyId), 10)
+}
+
+// NewOwnerTokenMiddleware only lets through current owners of the company of the route
+func NewOwnerTokenMiddleware(i *do.Injector) echo.MiddlewareFunc {
+	db := do.MustInvoke[*sql.DB](i)
+	jwtProvider := do.MustInvoke[jwt_provider.Provider](i)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			companyId, err := parseCompanyIdParam(c)
+			if err != nil {
+				return err
+			}
+
+			// Check if owner token is invalid, has expired or was revoked and extract wallet address
+			claims, err := jwtProvider.GetClaims(c.Request().Header.Get("Owner-Token"), OwnerTokenAudience(companyId))
+			if err != nil {
+				return NewHTTPError(http.StatusUnauthorized, MsgOwnerTokenIsInvalidOrExpired)
+			}
+			walletAddress, err := claims.GetSubject()
+			if err != nil {
+				return errtrace.Errorf("failed to get subject from claims: %w", err)
+			}
+			revoked, err := isProofTokenRevoked(c.Request().Context(), db, claims)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return NewHTTPError(http.StatusUnauthorized, MsgOwnerTokenIsInvalidOrExpired)
+			}
+
+			// Removed owners lose access right away
+			owner, err := isCompanyOwner(c.Request().Context(), db, companyId, walletAddress)
+			if err != nil {
+				return err
+			}
+			if !owner {
+				return NewHTTPError(http.StatusForbidden, MsgWalletIsNotCompanyOwner)
+			}
+
+			setContextValue(c, ContextKey_CompanyId, companyId)
 			setContextValue(c, ContextKey_WalletAddress, walletAddress)
+			setContextValue(c, ContextKey_ProofTokenClaims, claims)
 
 			return next(c)
 		}
 	}
 }
+
+// isOriginAllowed checks the origin is one of the allowed origins of the company
+func isOriginAllowed(ctx context.Context, db *sql.DB, companyId uint, origin string) (bool, error) {
+	if origin == "" {
+		return false, nil
+	}
+	var allowed bool
+	err := sqlscan.Get(ctx, db, &allowed,
+		"SELECT EXISTS (SELECT 1 FROM allowed_origins WHERE company_id = ? AND origin = ?)", companyId, origin,
+	)
+	if err != nil {
+		return false, errtrace.Errorf("failed to check if origin is allowed: %w", err)
+	}
+	return allowed, nil
+}
+
+// isProofTokenRevoked checks the revocation list and the session of the proof token
+func isProofTokenRevoked(ctx context.Context, db sqlscan.Querier, claims *jwt_provider.Claims) (bool, error) {
+	var revoked bool
+	err := sqlscan.Get(ctx, db, &revoked,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR EXISTS (SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NOT NULL)`,
+		claims.ID, claims.SessionId,
+	)
+	if err != nil {
+		return false, errtrace.Errorf("failed to check if proof token is revoked: %w", err)
+	}
+	return revoked, nil
+}
@@ -1,14 +1,17 @@
 package server_test
 
 import (
-	"gatekeeper/internal"
+	"database/sql"
 	"gatekeeper/internal/server"
 	server_testing "gatekeeper/internal/server/testing"
 	"gatekeeper/pkg/echo_ext"
+	"gatekeeper/pkg/jwt_provider"
 	"net/http"
 	"testing"
 	"time"
 
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,11 +22,19 @@ func TestIntegration_ApiKeyMiddleware(t *testing.T) {
 		{Method: http.MethodGet, Path: "/v1/accounts/" + server_testing.WalletAddress + "/metadata"},
 		{Method: http.MethodPost, Path: "/v1/challenges/issue"},
 		{Method: http.MethodPost, Path: "/v1/challenges/verify"},
+		{Method: http.MethodPost, Path: "/v1/sessions/refresh"},
+		{Method: http.MethodDelete, Path: "/v1/sessions/current"},
+		{Method: http.MethodPost, Path: "/v1/sessions/revoke"},
+		{Method: http.MethodPost, Path: "/v1/introspect"},
+		{Method: http.MethodPost, Path: "/v1/login/exchange"},
+		{Method: http.MethodGet, Path: "/v1/api-keys"},
+		{Method: http.MethodPost, Path: "/v1/api-keys"},
+		{Method: http.MethodDelete, Path: "/v1/api-keys/1"},
 	}
 
 	for _, endpoint := range endpoints {
 		t.Run(endpoint.Method+" "+endpoint.Path, func(t *testing.T) {
-			s := server.NewServer(internal.NewTestInjector(t), server.Config{Env: "test"})
+			s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
 			res := echo_ext.SendTestRequest(
 				t, s.Echo, endpoint.Method, endpoint.Path,
 				map[string]string{"Api-Key": "jiberish"}, nil,
@@ -35,6 +46,55 @@ func TestIntegration_ApiKeyMiddleware(t *testing.T) {
 	}
 }
 
+func TestIntegration_ApiKeyScopes(t *testing.T) {
+	endpoints := []struct {
+		Method, Path string
+		Scope        server.ApiKeyScope
+	}{
+		{Method: http.MethodPost, Path: "/v1/accounts", Scope: server.ApiKeyScope_AccountsWrite},
+		{Method: http.MethodGet, Path: "/v1/accounts/" + server_testing.WalletAddress + "/metadata", Scope: server.ApiKeyScope_AccountsRead},
+		{Method: http.MethodPost, Path: "/v1/challenges/issue", Scope: server.ApiKeyScope_ChallengesIssue},
+		{Method: http.MethodPost, Path: "/v1/challenges/verify", Scope: server.ApiKeyScope_ChallengesVerify},
+		{Method: http.MethodPost, Path: "/v1/sessions/refresh", Scope: server.ApiKeyScope_SessionsWrite},
+		{Method: http.MethodDelete, Path: "/v1/sessions/current", Scope: server.ApiKeyScope_SessionsWrite},
+		{Method: http.MethodPost, Path: "/v1/sessions/revoke", Scope: server.ApiKeyScope_SessionsWrite},
+		{Method: http.MethodPost, Path: "/v1/introspect", Scope: server.ApiKeyScope_TokensIntrospect},
+		{Method: http.MethodPost, Path: "/v1/login/exchange", Scope: server.ApiKeyScope_ChallengesVerify},
+		{Method: http.MethodGet, Path: "/v1/api-keys", Scope: server.ApiKeyScope_Admin},
+		{Method: http.MethodPost, Path: "/v1/api-keys", Scope: server.ApiKeyScope_Admin},
+		{Method: http.MethodDelete, Path: "/v1/api-keys/1", Scope: server.ApiKeyScope_Admin},
+	}
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint.Method+" "+endpoint.Path, func(t *testing.T) {
+			i := server_testing.NewTestInjector(t)
+			s := server.NewServer(i, server_testing.TestConfig)
+			db := do.MustInvoke[*sql.DB](i)
+
+			// Api key with every scope except the one of the endpoint
+			otherScopes := []server.ApiKeyScope{}
+			for _, scope := range server.ApiKeyScopes {
+				if scope != endpoint.Scope {
+					otherScopes = append(otherScopes, scope)
+				}
+			}
+			res := echo_ext.SendTestRequest(
+				t, s.Echo, endpoint.Method, endpoint.Path,
+				map[string]string{"Api-Key": server_testing.CreateApiKey(t, db, server_testing.CompanyId, otherScopes...)}, nil,
+			)
+			require.Equal(t, http.StatusForbidden, res.Code)
+			assert.Equal(t, server.MsgApiKeyScopeIsMissing, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+			// Api key with only the scope of the endpoint
+			res = echo_ext.SendTestRequest(
+				t, s.Echo, endpoint.Method, endpoint.Path,
+				map[string]string{"Api-Key": server_testing.CreateApiKey(t, db, server_testing.CompanyId, endpoint.Scope)}, nil,
+			)
+			assert.NotEqual(t, http.StatusForbidden, res.Code)
+		})
+	}
+}
+
 func TestIntegration_ProofTokenyMiddleware(t *testing.T) {
 	endpoints := []struct{ Method, Path string }{
 		{Method: http.MethodPost, Path: "/v1/accounts"},
@@ -43,7 +103,7 @@ func TestIntegration_ProofTokenyMiddleware(t *testing.T) {
 
 	for _, endpoint := range endpoints {
 		t.Run(endpoint.Method+" "+endpoint.Path, func(t *testing.T) {
-			s := server.NewServer(internal.NewTestInjector(t), server.Config{Env: "test"})
+			s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
 			res := echo_ext.SendTestRequest(
 				t, s.Echo, endpoint.Method, endpoint.Path,
 				map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": "jiberish"}, nil,
@@ -56,15 +116,31 @@ func TestIntegration_ProofTokenyMiddleware(t *testing.T) {
 }
 
 func TestUnit_ProofTokenMiddleware(t *testing.T) {
-	i := internal.NewTestInjector(t)
+	i := server_testing.NewTestInjector(t)
 	handler := server.NewProofTokenMiddleware(i)
-	expiredProofToken := server_testing.GenerateProofToken(t, i, server_testing.WalletAddress, time.Now().Add(-time.Minute))
-	emptyProofToken := server_testing.GenerateProofToken(t, i, "", time.Now().Add(time.Minute))
+	validProofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId, server_testing.WalletAddress, time.Now().Add(time.Minute))
+	expiredProofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId, server_testing.WalletAddress, time.Now().Add(-time.Minute))
+	emptyProofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId, "", time.Now().Add(time.Minute))
+	otherCompanyProofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId+1, server_testing.WalletAddress, time.Now().Add(time.Minute))
+	revokedProofToken := server_testing.GenerateProofToken(t, i, server_testing.CompanyId, server_testing.WalletAddress, time.Now().Add(time.Minute))
+	revokedClaims, err := do.MustInvoke[jwt_provider.Provider](i).GetClaims(revokedProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+	require.NoError(t, err)
+	_, err = do.MustInvoke[*sql.DB](i).Exec(
+		"INSERT INTO revoked_tokens (jti, expired_at) VALUES (?, ?)", revokedClaims.ID, revokedClaims.ExpiresAt.UTC(),
+	)
+	require.NoError(t, err)
+
+	otherIssuerProvider := server_testing.NewTestJwtProvider(t, "https://other-issuer.com")
+	otherIssuerClaims, err := otherIssuerProvider.NewClaims(server_testing.WalletAddress, server.ProofTokenAudience(server_testing.CompanyId), time.Minute)
+	require.NoError(t, err)
+	otherIssuerProofToken, err := otherIssuerProvider.GenerateSignedToken(otherIssuerClaims)
+	require.NoError(t, err)
 
 	runTest := func(expectsErr bool, proofToken string) func(t *testing.T) {
 		return func(t *testing.T) {
-			err := echo_ext.RunMiddleware(t, handler, func(req *http.Request) {
-				req.Header.Set("Proof-Token", proofToken)
+			err := echo_ext.RunMiddlewareWithContext(t, handler, func(c echo.Context) {
+				c.Set(string(server.ContextKey_CompanyId), server_testing.CompanyId)
+				c.Request().Header.Set("Proof-Token", proofToken)
 			})
 			if expectsErr {
 				assert.Equal(t, server.NewHTTPError(http.StatusBadRequest, server.MsgProofTokenIsInvalidOrExpired), err)
@@ -74,7 +150,11 @@ func TestUnit_ProofTokenMiddleware(t *testing.T) {
 		}
 	}
 
+	t.Run("Valid", runTest(false, validProofToken))
 	t.Run("Invalid", runTest(true, "jiberish"))
 	t.Run("Expired", runTest(true, expiredProofToken))
 	t.Run("Empty", runTest(true, emptyProofToken))
+	t.Run("OtherCompany", runTest(true, otherCompanyProofToken))
+	t.Run("OtherIssuer", runTest(true, otherIssuerProofToken))
+	t.Run("Revoked", runTest(true, revokedProofToken))
 }
@@ -0,0 +1,548 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/jwt_provider"
+	"gatekeeper/pkg/verifier"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+const (
+	OidcAuthorizePath = "/oidc/authorize"
+	OidcTokenPath     = "/oidc/token"
+	OidcUserinfoPath  = "/oidc/userinfo"
+)
+
+const OidcScope_OpenId = "openid"
+const OidcCodeChallengeMethod = "S256"
+const OidcClientIdPrefix = "company-"
+
+const AuthorizationRequestTokenLength uint = 16
+const AuthorizationRequestValidDuration = 10 * time.Minute
+const AuthorizationCodeLength uint = 32
+const AuthorizationCodeValidDuration = time.Minute
+
+// https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+const (
+	OAuthError_InvalidRequest          = "invalid_request"
+	OAuthError_InvalidClient           = "invalid_client"
+	OAuthError_InvalidGrant            = "invalid_grant"
+	OAuthError_InvalidScope            = "invalid_scope"
+	OAuthError_InvalidToken            = "invalid_token"
+	OAuthError_UnsupportedGrantType    = "unsupported_grant_type"
+	OAuthError_UnsupportedResponseType = "unsupported_response_type"
+)
+
+const (
+	MsgClientIdIsInvalid                         = "Client id is invalid"
+	MsgRedirectUriIsNotRegistered                = "Redirect uri is not registered for the client"
+	MsgAuthorizationRequestDoesNotExistOrExpired = "Authorization request does not exist or has expired"
+	MsgDeviceIsNotConfirmed                      = "Device must be confirmed before signing in"
+)
+
+// OidcClientId is the OpenID Connect client id of a company.
+// It differs from the proof token audience so ID tokens can't be used as proof tokens.
+func OidcClientId(companyId uint) string {
+	return OidcClientIdPrefix + strconv.FormatUint(uint64(companyId), 10)
+}
+
+func parseOidcClientId(clientId string) (uint, bool) {
+	companyIdStr, ok := strings.CutPrefix(clientId, OidcClientIdPrefix)
+	if !ok {
+		return 0, false
+	}
+	companyId, err := strconv.ParseUint(companyIdStr, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(companyId), true
+}
+
+// OidcController makes Gatekeeper an OpenID Connect provider, companies are the clients and authenticate with their api key as client secret.
+// The wallet signs a challenge on the login page to authenticate during the authorization code flow, PKCE is required.
+type OidcController struct {
+	Config      Config
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+	Verifiers   *verifier.Registry
+}
+
+func NewOidcController(e *echo.Echo, i *do.Injector) OidcController {
+	ct := OidcController{
+		Config:      do.MustInvoke[Config](i),
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+		Verifiers:   do.MustInvoke[*verifier.Registry](i),
+	}
+
+	e.GET(OidcAuthorizePath, ct.Authorize)
+	e.POST(OidcAuthorizePath+"/challenge", ct.AuthorizeChallenge)
+	e.POST(OidcAuthorizePath+"/verify", ct.AuthorizeVerify)
+	e.POST(OidcTokenPath, ct.Token)
+	e.GET(OidcUserinfoPath, ct.Userinfo)
+	e.POST(OidcUserinfoPath, ct.Userinfo)
+
+	return ct
+}
+
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OidcController_AuthorizeRequest struct {
+	ResponseType        string `query:"response_type"`
+	ClientId            string `query:"client_id"`
+	RedirectUri         string `query:"redirect_uri"`
+	Scope               string `query:"scope"`
+	State               string `query:"state"`
+	Nonce               string `query:"nonce"`
+	CodeChallenge       string `query:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method"`
+}
+
+// Authorize starts the authorization code flow and renders the page where the wallet signs in.
+// Errors are only redirected to the client once the redirect uri is known to be registered.
+func (ct OidcController) Authorize(c echo.Context) error {
+	var req OidcController_AuthorizeRequest
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	if err != nil {
+		return ErrBadRequest
+	}
+
+	companyId, err := getClientCompanyId(c.Request().Context(), ct.DB, req.ClientId, req.RedirectUri)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	redirectErr := func(code, description string) error {
+		return errtrace.Wrap(c.Redirect(http.StatusFound, ct.redirectUrl(req.RedirectUri, url.Values{
+			"error":             {code},
+			"error_description": {description},
+		}, req.State)))
+	}
+	if req.ResponseType != "code" {
+		return redirectErr(OAuthError_UnsupportedResponseType, "Only the authorization code flow is supported")
+	}
+	if !slices.Contains(strings.Fields(req.Scope), OidcScope_OpenId) {
+		return redirectErr(OAuthError_InvalidScope, "The openid scope is required")
+	}
+	// https://datatracker.ietf.org/doc/html/rfc7636#section-4.2
+	if req.CodeChallengeMethod != OidcCodeChallengeMethod || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return redirectErr(OAuthError_InvalidRequest, "A S256 PKCE code challenge is required")
+	}
+
+	authReq := entity.AuthorizationRequest{
+		CompanyId:     companyId,
+		Flow:          string(AuthorizationFlow_Oidc),
+		RedirectUri:   req.RedirectUri,
+		Scope:         req.Scope,
+		State:         nullIfEmpty(req.State),
+		Nonce:         nullIfEmpty(req.Nonce),
+		CodeChallenge: req.CodeChallenge,
+	}
+	err = createAuthorizationRequest(c.Request().Context(), ct.DB, &authReq)
+	if err != nil {
+		return err
+	}
+
+	return renderLoginPage(c, ct.DB, authReq)
+}
+
+type OidcController_AuthorizeChallengeRequest struct {
+	RequestToken   string                  `json:"requestToken" validate:"required"`
+	WalletAddress  string                  `json:"walletAddress" validate:"required"`
+	ChainNamespace verifier.ChainNamespace `json:"chainNamespace"`
+	Format         ChallengeFormat         `json:"format" validate:"in:plain,siwe,eip712"`
+}
+
+type OidcController_AuthorizeChallengeResponse = ChallengeController_IssueResponse
+
+// AuthorizeChallenge issues a challenge on behalf of the client of the authorization request, whichever flow started it
+func (ct OidcController) AuthorizeChallenge(c echo.Context) error {
+	req, err := bindAndValidate[OidcController_AuthorizeChallengeRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	authReq, err := getPendingAuthorizationRequest(ctx, ct.DB, req.RequestToken)
+	if err != nil {
+		return err
+	}
+	res, err := issueChallenge(ctx, ct.DB, ct.Verifiers, authReq.CompanyId, ChallengePurpose_Login, ChallengeController_IssueRequest{
+		WalletAddress:  req.WalletAddress,
+		ChainNamespace: req.ChainNamespace,
+		Format:         req.Format,
+	})
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type OidcController_AuthorizeVerifyRequest struct {
+	RequestToken string `json:"requestToken" validate:"required"`
+	Challenge    string `json:"challenge" validate:"required"`
+	Signature    string `json:"signature" validate:"required"`
+}
+
+type OidcController_AuthorizeVerifyResponse struct {
+	// Redirect uri of the client with the authorization code
+	RedirectUri string `json:"redirectUri"`
+}
+
+// AuthorizeVerify authenticates the wallet of the authorization request and issues the authorization code
+func (ct OidcController) AuthorizeVerify(c echo.Context) error {
+	req, err := bindAndValidate[OidcController_AuthorizeVerifyRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	authReq, err := getPendingAuthorizationRequest(ctx, tx, req.RequestToken)
+	if err != nil {
+		return err
+	}
+	challenge, err := verifyChallenge(ctx, tx, ct.Verifiers, authReq.CompanyId, ChallengePurpose_Login, ChallengeController_VerifyRequest{
+		Challenge: req.Challenge,
+		Signature: req.Signature,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Issue authorization code, the request may have been used concurrently
+	code, err := generateAuthorizationCode()
+	if err != nil {
+		return errtrace.Errorf("failed to generate authorization code: %w", err)
+	}
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx,
+		`UPDATE authorization_requests SET wallet_address = ?, code_hash = ?, authenticated_at = ?, expired_at = ?
+		WHERE id = ? AND code_hash IS NULL`,
+		challenge.WalletAddress, hashAuthorizationCode(code), now, now.Add(AuthorizationCodeValidDuration), authReq.Id,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to save authorization code: %w", err)
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return NewHTTPError(http.StatusUnprocessableEntity, MsgAuthorizationRequestDoesNotExistOrExpired)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Devices poll for their session, the wallet is only shown a confirmation
+	if AuthorizationFlow(authReq.Flow) == AuthorizationFlow_Device {
+		return errtrace.Wrap(c.JSON(http.StatusOK, OidcController_AuthorizeVerifyResponse{RedirectUri: authReq.RedirectUri}))
+	}
+
+	state := ""
+	if authReq.State != nil {
+		state = *authReq.State
+	}
+	return errtrace.Wrap(c.JSON(http.StatusOK, OidcController_AuthorizeVerifyResponse{
+		RedirectUri: ct.redirectUrl(authReq.RedirectUri, url.Values{"code": {code}}, state),
+	}))
+}
+
+// Field names follow RFC 6749, requests are sent as a form
+type OidcController_TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type OidcController_TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type OidcIdTokenClaims struct {
+	jwt_provider.Claims
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+}
+
+// Token exchanges an authorization code or a refresh token.
+// Access tokens are proof tokens of the company, so they are accepted by the /v1 api as well.
+func (ct OidcController) Token(c echo.Context) error {
+	var req OidcController_TokenRequest
+	err := (&echo.DefaultBinder{}).BindBody(c, &req)
+	if err != nil {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidRequest, "")
+	}
+	ctx := c.Request().Context()
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	// Authenticate client with client_secret_basic or client_secret_post
+	clientId, clientSecret, basicAuth := c.Request().BasicAuth()
+	if basicAuth {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId, clientSecret = req.ClientId, req.ClientSecret
+	}
+	companyId, ok := parseOidcClientId(clientId)
+	if ok {
+		// Secret api keys of the company able to verify challenges are valid client secrets, publishable keys are embedded in web pages
+		var apiKey entity.ApiKey
+		apiKey, ok, err = authenticateApiKey(ctx, ct.DB, ct.Config.ApiKeyPepper, clientSecret)
+		if err != nil {
+			return err
+		}
+		ok = ok && apiKey.CompanyId == companyId && !apiKey.Publishable && hasApiKeyScope(apiKey.Scopes, ApiKeyScope_ChallengesVerify)
+	}
+	if !ok {
+		if basicAuth {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="gatekeeper"`)
+		}
+		return oauthError(c, http.StatusUnauthorized, OAuthError_InvalidClient, "")
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return ct.exchangeAuthorizationCode(c, companyId, req)
+
+	case "refresh_token":
+		tokens, err := refreshSession(ctx, ct.DB, ct.JwtProvider, companyId, req.RefreshToken)
+		if err != nil {
+			var httpErr HTTPError
+			if errors.As(err, &httpErr) {
+				return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, httpErr.Error())
+			}
+			return err
+		}
+		return errtrace.Wrap(c.JSON(http.StatusOK, newOidcTokenResponse(tokens)))
+
+	default:
+		return oauthError(c, http.StatusBadRequest, OAuthError_UnsupportedGrantType, "")
+	}
+}
+
+func (ct OidcController) exchangeAuthorizationCode(c echo.Context, companyId uint, req OidcController_TokenRequest) error {
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Get and consume authorization request of the code
+	var authReq entity.AuthorizationRequest
+	err = sqlscan.Get(ctx, tx, &authReq,
+		`SELECT id, company_id, redirect_uri, scope, nonce, code_challenge, wallet_address, authenticated_at, expired_at
+		FROM authorization_requests WHERE code_hash = ? AND company_id = ? AND flow = ? LIMIT 1`,
+		hashAuthorizationCode(req.Code), companyId, AuthorizationFlow_Oidc,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "")
+		}
+		return errtrace.Errorf("failed to get authorization request: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM authorization_requests WHERE id = ?", authReq.Id)
+	if err != nil {
+		return errtrace.Errorf("failed to delete authorization request (id: %d): %w", authReq.Id, err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "")
+	}
+	if !authReq.ExpiredAt.After(time.Now()) || authReq.RedirectUri != req.RedirectUri {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "")
+	}
+
+	// Check PKCE code verifier
+	verifierHash := sha256.Sum256([]byte(req.CodeVerifier))
+	if base64.RawURLEncoding.EncodeToString(verifierHash[:]) != authReq.CodeChallenge {
+		return oauthError(c, http.StatusBadRequest, OAuthError_InvalidGrant, "Code verifier does not match the code challenge")
+	}
+
+	// Start session and sign id token
+	tokens, err := createSession(ctx, tx, ct.JwtProvider, companyId, *authReq.WalletAddress)
+	if err != nil {
+		return err
+	}
+	claims, err := ct.JwtProvider.NewClaims(*authReq.WalletAddress, OidcClientId(companyId), time.Until(tokens.ProofTokenExpiredAt))
+	if err != nil {
+		return errtrace.Errorf("failed to create id token claims: %w", err)
+	}
+	idTokenClaims := OidcIdTokenClaims{Claims: claims, AuthTime: authReq.AuthenticatedAt.Unix()}
+	if authReq.Nonce != nil {
+		idTokenClaims.Nonce = *authReq.Nonce
+	}
+	idToken, err := ct.JwtProvider.GenerateSignedToken(idTokenClaims)
+	if err != nil {
+		return errtrace.Errorf("failed to generate id token: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	tokenRes := newOidcTokenResponse(tokens)
+	tokenRes.IdToken = idToken
+	tokenRes.Scope = authReq.Scope
+	return errtrace.Wrap(c.JSON(http.StatusOK, tokenRes))
+}
+
+type OidcController_UserinfoResponse struct {
+	Sub string `json:"sub"`
+}
+
+// Userinfo accepts the access token as bearer token, the company is taken from its audience
+func (ct OidcController) Userinfo(c echo.Context) error {
+	invalidToken := func() error {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return oauthError(c, http.StatusUnauthorized, OAuthError_InvalidToken, "")
+	}
+
+	accessToken, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return invalidToken()
+	}
+	var unverified jwt_provider.Claims
+	_, _, err := jwt.NewParser().ParseUnverified(accessToken, &unverified)
+	if err != nil || len(unverified.Audience) != 1 {
+		return invalidToken()
+	}
+	// ID tokens have the client id as audience and are rejected here
+	if _, err := strconv.ParseUint(unverified.Audience[0], 10, 64); err != nil {
+		return invalidToken()
+	}
+	claims, err := ct.JwtProvider.GetClaims(accessToken, unverified.Audience[0])
+	if err != nil || claims.Subject == "" {
+		return invalidToken()
+	}
+	revoked, err := isProofTokenRevoked(c.Request().Context(), ct.DB, claims)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return invalidToken()
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, OidcController_UserinfoResponse{Sub: claims.Subject}))
+}
+
+// redirectUrl adds the params to the redirect uri with the state and the issuer (RFC 9207)
+func (ct OidcController) redirectUrl(redirectUri string, params url.Values, state string) string {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return redirectUri
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", ct.Config.IssuerUrl)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// getPendingAuthorizationRequest returns the authorization request if the wallet has not authenticated yet
+func getPendingAuthorizationRequest(ctx context.Context, db sqlscan.Querier, token string) (entity.AuthorizationRequest, error) {
+	var authReq entity.AuthorizationRequest
+	err := sqlscan.Get(ctx, db, &authReq,
+		`SELECT id, company_id, flow, token, redirect_uri, scope, state, nonce, code_challenge, confirmed_at, created_at, expired_at
+		FROM authorization_requests WHERE token = ? AND code_hash IS NULL AND expired_at > ? LIMIT 1`,
+		token, time.Now().UTC(),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.AuthorizationRequest{}, NewHTTPError(http.StatusUnprocessableEntity, MsgAuthorizationRequestDoesNotExistOrExpired)
+		}
+		return entity.AuthorizationRequest{}, errtrace.Errorf("failed to get authorization request: %w", err)
+	}
+	if AuthorizationFlow(authReq.Flow) == AuthorizationFlow_Device && authReq.ConfirmedAt == nil {
+		return entity.AuthorizationRequest{}, NewHTTPError(http.StatusUnprocessableEntity, MsgDeviceIsNotConfirmed)
+	}
+	return authReq, nil
+}
+
+func newOidcTokenResponse(tokens SessionTokens) OidcController_TokenResponse {
+	return OidcController_TokenResponse{
+		AccessToken:  tokens.ProofToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(tokens.ProofTokenExpiredAt).Round(time.Second).Seconds()),
+		RefreshToken: tokens.RefreshToken,
+	}
+}
+
+func oauthError(c echo.Context, code int, err string, description string) error {
+	return errtrace.Wrap(c.JSON(code, OAuthErrorResponse{Error: err, ErrorDescription: description}))
+}
+
+func generateAuthorizationRequestToken() (string, error) {
+	tokenBytes := make([]byte, AuthorizationRequestTokenLength)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+func generateAuthorizationCode() (string, error) {
+	codeBytes := make([]byte, AuthorizationCodeLength)
+	_, err := rand.Read(codeBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(codeBytes), nil
+}
+
+// Authorization codes are short-lived and have enough entropy to be stored with a fast hash
+func hashAuthorizationCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
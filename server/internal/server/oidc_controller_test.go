@@ -0,0 +1,295 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/crypto_ext"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOidcController(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+	clientId := server.OidcClientId(server_testing.CompanyId)
+	redirectUri := "http://localhost:4000/callback"
+	codeVerifier := "dBjftJeZ4CVP-mJ92K9ecxAJoikIdh4ryXjYh3oGAaVgcEpJx9Q"
+	codeVerifierHash := sha256.Sum256([]byte(codeVerifier))
+	codeChallenge := base64.RawURLEncoding.EncodeToString(codeVerifierHash[:])
+	requestTokenRegexp := regexp.MustCompile(`const requestToken = "([0-9a-f]+)"`)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+	authorizeParams := func() url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientId},
+			"redirect_uri":          {redirectUri},
+			"scope":                 {"openid"},
+			"state":                 {"af0ifjsldkj"},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {codeChallenge},
+			"code_challenge_method": {"S256"},
+		}
+	}
+	sendAuthorize := func(t *testing.T, s server.Server, params url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, server.OidcAuthorizePath+"?"+params.Encode(), nil)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	// authorize runs the authorization flow as the page does and returns the redirect uri with the code
+	authorize := func(t *testing.T, s server.Server) *url.URL {
+		res := sendAuthorize(t, s, authorizeParams())
+		require.Equal(t, http.StatusOK, res.Code)
+		matches := requestTokenRegexp.FindStringSubmatch(res.Body.String())
+		require.Len(t, matches, 2)
+		requestToken := matches[1]
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: requestToken, WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.OidcController_AuthorizeChallengeResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/verify", nil,
+			server.OidcController_AuthorizeVerifyRequest{RequestToken: requestToken, Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		u, err := url.Parse(echo_ext.ReadBody[server.OidcController_AuthorizeVerifyResponse](t, res.Body).RedirectUri)
+		require.NoError(t, err)
+		return u
+	}
+	sendToken := func(t *testing.T, s server.Server, form url.Values, basicAuth bool) *httptest.ResponseRecorder {
+		if !basicAuth {
+			form.Set("client_id", clientId)
+			form.Set("client_secret", server_testing.ApiKey)
+		}
+		req := httptest.NewRequest(http.MethodPost, server.OidcTokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		if basicAuth {
+			req.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(server_testing.ApiKey))
+		}
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+		return res
+	}
+	codeForm := func(code string) url.Values {
+		return url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectUri},
+			"code_verifier": {codeVerifier},
+		}
+	}
+	requireOAuthError := func(t *testing.T, res *httptest.ResponseRecorder, code int, oauthErr string) {
+		require.Equal(t, code, res.Code)
+		assert.Equal(t, oauthErr, echo_ext.ReadBody[server.OAuthErrorResponse](t, res.Body).Error)
+	}
+	sendUserinfo := func(t *testing.T, s server.Server, accessToken string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, server.OidcUserinfoPath,
+			map[string]string{"Authorization": "Bearer " + accessToken}, nil,
+		)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		redirect := authorize(t, s)
+		assert.Equal(t, redirectUri, redirect.Scheme+"://"+redirect.Host+redirect.Path)
+		assert.Equal(t, "af0ifjsldkj", redirect.Query().Get("state"))
+		assert.Equal(t, s.Config.IssuerUrl, redirect.Query().Get("iss"))
+		code := redirect.Query().Get("code")
+		require.NotEmpty(t, code)
+
+		res := sendToken(t, s, codeForm(code), true)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "no-store", res.Header().Get("Cache-Control"))
+		tokens := echo_ext.ReadBody[server.OidcController_TokenResponse](t, res.Body)
+		assert.Equal(t, "Bearer", tokens.TokenType)
+		assert.Equal(t, "openid", tokens.Scope)
+		assert.InDelta(t, 300, tokens.ExpiresIn, 5)
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		// ID token
+		var idTokenClaims server.OidcIdTokenClaims
+		_, err := jwt.ParseWithClaims(tokens.IdToken, &idTokenClaims, func(token *jwt.Token) (any, error) {
+			return s.OidcCtrl.JwtProvider.SigningKey().PrivKey.Public(), nil
+		}, jwt.WithAudience(clientId), jwt.WithIssuer(s.Config.IssuerUrl))
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, idTokenClaims.Subject)
+		assert.Equal(t, "n-0S6_WzA2Mj", idTokenClaims.Nonce)
+		assert.WithinDuration(t, time.Now(), time.Unix(idTokenClaims.AuthTime, 0), 5*time.Second)
+
+		// The access token is a proof token of the company
+		claims, err := s.OidcCtrl.JwtProvider.GetClaims(tokens.AccessToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, claims.Subject)
+
+		res = sendUserinfo(t, s, tokens.AccessToken)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, walletAddress, echo_ext.ReadBody[server.OidcController_UserinfoResponse](t, res.Body).Sub)
+
+		// Codes can only be used once
+		requireOAuthError(t, sendToken(t, s, codeForm(code), true), http.StatusBadRequest, server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("ClientSecretPost", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		res := sendToken(t, s, codeForm(code), false)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("RefreshTokenGrant", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		res := sendToken(t, s, codeForm(code), true)
+		require.Equal(t, http.StatusOK, res.Code)
+		tokens := echo_ext.ReadBody[server.OidcController_TokenResponse](t, res.Body)
+
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+		res = sendToken(t, s, form, true)
+		require.Equal(t, http.StatusOK, res.Code)
+		refreshed := echo_ext.ReadBody[server.OidcController_TokenResponse](t, res.Body)
+		assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+		assert.Empty(t, refreshed.IdToken)
+
+		// Refresh tokens are rotated
+		requireOAuthError(t, sendToken(t, s, form, true), http.StatusBadRequest, server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("InvalidClientSecret", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		form := codeForm(code)
+		form.Set("client_id", clientId)
+		form.Set("client_secret", "jiberish")
+		req := httptest.NewRequest(http.MethodPost, server.OidcTokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+
+		requireOAuthError(t, res, http.StatusUnauthorized, server.OAuthError_InvalidClient)
+	}))
+
+	t.Run("PublishableApiKeyIsNotAClientSecret", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		form := codeForm(code)
+		form.Set("client_id", clientId)
+		form.Set("client_secret", server_testing.CreatePublishableApiKey(t, s.OidcCtrl.DB, server_testing.CompanyId))
+		req := httptest.NewRequest(http.MethodPost, server.OidcTokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		res := httptest.NewRecorder()
+		s.Echo.ServeHTTP(res, req)
+
+		requireOAuthError(t, res, http.StatusUnauthorized, server.OAuthError_InvalidClient)
+	}))
+
+	t.Run("CodeVerifierMismatch", newTest(func(t *testing.T, s server.Server) {
+		form := codeForm(authorize(t, s).Query().Get("code"))
+		form.Set("code_verifier", "jiberish-jiberish-jiberish-jiberish-jiberish-jiberish")
+		requireOAuthError(t, sendToken(t, s, form, true), http.StatusBadRequest, server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("RedirectUriMismatch", newTest(func(t *testing.T, s server.Server) {
+		form := codeForm(authorize(t, s).Query().Get("code"))
+		form.Set("redirect_uri", "http://localhost:4000/other")
+		requireOAuthError(t, sendToken(t, s, form, true), http.StatusBadRequest, server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("CodeExpired", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		_, err := s.OidcCtrl.DB.Exec("UPDATE authorization_requests SET expired_at = ?", time.Now().UTC().Add(-time.Minute))
+		require.NoError(t, err)
+		requireOAuthError(t, sendToken(t, s, codeForm(code), true), http.StatusBadRequest, server.OAuthError_InvalidGrant)
+	}))
+
+	t.Run("UnsupportedGrantType", newTest(func(t *testing.T, s server.Server) {
+		form := url.Values{"grant_type": {"password"}}
+		requireOAuthError(t, sendToken(t, s, form, true), http.StatusBadRequest, server.OAuthError_UnsupportedGrantType)
+	}))
+
+	t.Run("ClientIdIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		params := authorizeParams()
+		params.Set("client_id", server.ProofTokenAudience(server_testing.CompanyId))
+		res := sendAuthorize(t, s, params)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgClientIdIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("RedirectUriIsNotRegistered", newTest(func(t *testing.T, s server.Server) {
+		params := authorizeParams()
+		params.Set("redirect_uri", "https://attacker.com/callback")
+		res := sendAuthorize(t, s, params)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgRedirectUriIsNotRegistered, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("PkceIsRequired", newTest(func(t *testing.T, s server.Server) {
+		params := authorizeParams()
+		params.Del("code_challenge")
+		res := sendAuthorize(t, s, params)
+		require.Equal(t, http.StatusFound, res.Code)
+		location, err := url.Parse(res.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, server.OAuthError_InvalidRequest, location.Query().Get("error"))
+		assert.Equal(t, "af0ifjsldkj", location.Query().Get("state"))
+	}))
+
+	t.Run("OpenIdScopeIsRequired", newTest(func(t *testing.T, s server.Server) {
+		params := authorizeParams()
+		params.Set("scope", "profile")
+		res := sendAuthorize(t, s, params)
+		require.Equal(t, http.StatusFound, res.Code)
+		location, err := url.Parse(res.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, server.OAuthError_InvalidScope, location.Query().Get("error"))
+	}))
+
+	t.Run("AuthorizationRequestDoesNotExist", newTest(func(t *testing.T, s server.Server) {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, server.OidcAuthorizePath+"/challenge", nil,
+			server.OidcController_AuthorizeChallengeRequest{RequestToken: "jiberish", WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.Equal(t, server.MsgAuthorizationRequestDoesNotExistOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("UserinfoRejectsIdToken", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		res := sendToken(t, s, codeForm(code), true)
+		require.Equal(t, http.StatusOK, res.Code)
+		tokens := echo_ext.ReadBody[server.OidcController_TokenResponse](t, res.Body)
+
+		res = sendUserinfo(t, s, tokens.IdToken)
+		requireOAuthError(t, res, http.StatusUnauthorized, server.OAuthError_InvalidToken)
+		assert.Equal(t, `Bearer error="invalid_token"`, res.Header().Get("WWW-Authenticate"))
+	}))
+
+	t.Run("UserinfoRevokedToken", newTest(func(t *testing.T, s server.Server) {
+		code := authorize(t, s).Query().Get("code")
+		res := sendToken(t, s, codeForm(code), true)
+		require.Equal(t, http.StatusOK, res.Code)
+		tokens := echo_ext.ReadBody[server.OidcController_TokenResponse](t, res.Body)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.SessionController_RevokeRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		requireOAuthError(t, sendUserinfo(t, s, tokens.AccessToken), http.StatusUnauthorized, server.OAuthError_InvalidToken)
+	}))
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/jwt_provider"
+	"gatekeeper/pkg/sqlite_ext"
+	"gatekeeper/pkg/verifier"
+	"net/http"
+	"strconv"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Owner tokens can't be refreshed, owners sign in again with a new challenge
+const OwnerTokenLifetime = time.Hour
+
+const (
+	MsgWalletIsNotCompanyOwner = "Wallet is not an owner of the company"
+	MsgOwnerAlreadyExists      = "Wallet is already an owner of the company"
+	MsgCompanyMustHaveAnOwner  = "Company must keep at least one owner"
+)
+
+// OwnerController is the self-service api of company owners, they sign in with a challenge of their company signed by their wallet
+type OwnerController struct {
+	Config      Config
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+	Verifiers   *verifier.Registry
+}
+
+func NewOwnerController(echoGrp *echo.Group, i *do.Injector) OwnerController {
+	ct := OwnerController{
+		Config:      do.MustInvoke[Config](i),
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+		Verifiers:   do.MustInvoke[*verifier.Registry](i),
+	}
+
+	company := echoGrp.Group("/owner/companies/:companyId")
+	company.POST("/challenges/issue", ct.IssueChallenge)
+	company.POST("/challenges/verify", ct.VerifyChallenge)
+
+	authenticated := company.Group("", NewOwnerTokenMiddleware(i))
+	authenticated.POST("/sign-out", ct.SignOut)
+	authenticated.GET("", ct.GetCompany)
+	authenticated.PUT("", ct.UpdateCompany)
+	authenticated.GET("/api-keys", ct.ListApiKeys)
+	authenticated.POST("/api-keys", ct.CreateApiKey)
+	authenticated.DELETE("/api-keys/:apiKeyId", ct.RevokeApiKey)
+	authenticated.GET("/owners", ct.ListOwners)
+	authenticated.POST("/owners", ct.AddOwner)
+	authenticated.DELETE("/owners/:walletAddress", ct.RemoveOwner)
+	authenticated.GET("/accounts", ct.ListAccounts)
+	authenticated.GET("/accounts/:walletAddress", ct.GetAccount)
+	authenticated.GET("/metadata-schemas", ct.ListMetadataSchemas)
+	authenticated.POST("/metadata-schemas", ct.CreateMetadataSchema)
+	authenticated.GET("/metadata-schemas/:version", ct.GetMetadataSchema)
+
+	return ct
+}
+
+type OwnerController_IssueChallengeRequest = ChallengeController_IssueRequest
+
+type OwnerController_IssueChallengeResponse = ChallengeController_IssueResponse
+
+// IssueChallenge issues a challenge of the company to any wallet, so that its owners can't be found out without their signature.
+// Wallets which are not owners are rejected when the challenge is verified.
+func (ct OwnerController) IssueChallenge(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[OwnerController_IssueChallengeRequest](c)
+	if err != nil {
+		return err
+	}
+
+	res, err := issueChallenge(c.Request().Context(), ct.DB, ct.Verifiers, companyId, ChallengePurpose_Owner, req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type OwnerController_VerifyChallengeRequest = ChallengeController_VerifyRequest
+
+type OwnerController_VerifyChallengeResponse struct {
+	// Sent in the Owner-Token header of the other routes of the owner api
+	OwnerToken string    `json:"ownerToken"`
+	ExpiredAt  time.Time `json:"expiredAt"`
+}
+
+// VerifyChallenge signs the owner in, the returned owner token is only accepted by the owner api of the company
+func (ct OwnerController) VerifyChallenge(c echo.Context) error {
+	companyId, err := parseCompanyIdParam(c)
+	if err != nil {
+		return err
+	}
+	req, err := bindAndValidate[OwnerController_VerifyChallengeRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	challenge, err := verifyChallenge(ctx, tx, ct.Verifiers, companyId, ChallengePurpose_Owner, req)
+	if err != nil {
+		return err
+	}
+	// Owner may have been removed since the challenge was issued
+	owner, err := isCompanyOwner(ctx, tx, companyId, challenge.WalletAddress)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		return NewHTTPError(http.StatusForbidden, MsgWalletIsNotCompanyOwner)
+	}
+
+	claims, err := ct.JwtProvider.NewClaims(challenge.WalletAddress, OwnerTokenAudience(companyId), OwnerTokenLifetime)
+	if err != nil {
+		return errtrace.Errorf("failed to create owner token claims: %w", err)
+	}
+	ownerToken, err := ct.JwtProvider.GenerateSignedToken(claims)
+	if err != nil {
+		return errtrace.Errorf("failed to generate owner token: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, OwnerController_VerifyChallengeResponse{
+		OwnerToken: ownerToken,
+		ExpiredAt:  claims.ExpiresAt.UTC(),
+	}))
+}
+
+// SignOut revokes the owner token of the request
+func (ct OwnerController) SignOut(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = revokeProofToken(ctx, tx, getContextValue[*jwt_provider.Claims](c, ContextKey_ProofTokenClaims))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type OwnerController_GetCompanyResponse = AdminController_CompanyResponse
+
+func (ct OwnerController) GetCompany(c echo.Context) error {
+	company, err := getCompanyResponse(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, company))
+}
+
+type OwnerController_UpdateCompanyRequest = AdminController_CompanyRequest
+
+type OwnerController_UpdateCompanyResponse = AdminController_CompanyResponse
+
+// UpdateCompany replaces all settings of the company like the admin api, owners are managed separately
+func (ct OwnerController) UpdateCompany(c echo.Context) error {
+	req, err := bindAndValidate[OwnerController_UpdateCompanyRequest](c)
+	if err != nil {
+		return err
+	}
+	company, err := updateCompany(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, company))
+}
+
+type OwnerController_ListApiKeysResponse = ApiKeyController_ListResponse
+
+func (ct OwnerController) ListApiKeys(c echo.Context) error {
+	apiKeys, err := listApiKeys(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, OwnerController_ListApiKeysResponse{ApiKeys: apiKeys}))
+}
+
+type OwnerController_CreateApiKeyRequest = ApiKeyController_CreateRequest
+
+type OwnerController_CreateApiKeyResponse = ApiKeyController_CreateResponse
+
+func (ct OwnerController) CreateApiKey(c echo.Context) error {
+	req, err := bindAndValidate[OwnerController_CreateApiKeyRequest](c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	apiKey, err := createApiKey(ctx, tx, ct.Config.ApiKeyPepper, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, apiKey))
+}
+
+func (ct OwnerController) RevokeApiKey(c echo.Context) error {
+	apiKeyId, err := strconv.ParseUint(c.Param("apiKeyId"), 10, 0)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = revokeApiKey(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), uint(apiKeyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type CompanyOwnerResponse struct {
+	ChainNamespace string    `json:"chainNamespace"`
+	WalletAddress  string    `json:"walletAddress"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type OwnerController_ListOwnersResponse struct {
+	Owners []CompanyOwnerResponse `json:"owners"`
+}
+
+func (ct OwnerController) ListOwners(c echo.Context) error {
+	owners, err := listCompanyOwners(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, OwnerController_ListOwnersResponse{Owners: owners}))
+}
+
+type OwnerController_AddOwnerRequest struct {
+	WalletAddress  string                  `json:"walletAddress" validate:"required"`
+	ChainNamespace verifier.ChainNamespace `json:"chainNamespace"`
+}
+
+type OwnerController_AddOwnerResponse = CompanyOwnerResponse
+
+func (ct OwnerController) AddOwner(c echo.Context) error {
+	req, err := bindAndValidate[OwnerController_AddOwnerRequest](c)
+	if err != nil {
+		return err
+	}
+	owner, err := addCompanyOwner(c.Request().Context(), ct.DB, ct.Verifiers, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, owner))
+}
+
+// RemoveOwner removes an owner of the company, owners can remove themselves unless they are the last one
+func (ct OwnerController) RemoveOwner(c echo.Context) error {
+	err := removeCompanyOwner(c.Request().Context(), ct.DB, ct.Verifiers, getContextValue[uint](c, ContextKey_CompanyId), c.Param("walletAddress"))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type OwnerController_ListAccountsRequest = AccountController_ListRequest
+
+type OwnerController_ListAccountsResponse = AccountController_ListResponse
+
+// ListAccounts returns a page of the accounts of the company like the account api
+func (ct OwnerController) ListAccounts(c echo.Context) error {
+	req, err := bindListAccountsRequest(c)
+	if err != nil {
+		return err
+	}
+	res, err := listAccounts(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type OwnerController_GetAccountResponse = AccountResponse
+
+func (ct OwnerController) GetAccount(c echo.Context) error {
+	walletAddress, err := walletAddressParam(c, ct.Verifiers)
+	if err != nil {
+		return err
+	}
+	res, err := getAccount(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), walletAddress)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, res))
+}
+
+type OwnerController_ListMetadataSchemasResponse = MetadataSchemasResponse
+
+func (ct OwnerController) ListMetadataSchemas(c echo.Context) error {
+	schemas, err := listMetadataSchemas(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, OwnerController_ListMetadataSchemasResponse{MetadataSchemas: schemas}))
+}
+
+type OwnerController_CreateMetadataSchemaRequest = MetadataSchemaRequest
+
+type OwnerController_CreateMetadataSchemaResponse = MetadataSchemaResponse
+
+// CreateMetadataSchema adds a new version of the metadata schema, account metadata created or updated from now on must conform to it
+func (ct OwnerController) CreateMetadataSchema(c echo.Context) error {
+	req, err := bindAndValidate[OwnerController_CreateMetadataSchemaRequest](c)
+	if err != nil {
+		return err
+	}
+	schema, err := createMetadataSchema(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), req)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusCreated, schema))
+}
+
+type OwnerController_GetMetadataSchemaResponse = MetadataSchemaResponse
+
+func (ct OwnerController) GetMetadataSchema(c echo.Context) error {
+	version, err := strconv.ParseUint(c.Param("version"), 10, 0)
+	if err != nil {
+		return ErrNotFound
+	}
+	schema, err := getMetadataSchema(c.Request().Context(), ct.DB, getContextValue[uint](c, ContextKey_CompanyId), uint(version))
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, schema))
+}
+
+func isCompanyOwner(ctx context.Context, db sqlscan.Querier, companyId uint, walletAddress string) (bool, error) {
+	var owner bool
+	err := sqlscan.Get(ctx, db, &owner,
+		"SELECT EXISTS (SELECT 1 FROM company_owners WHERE company_id = ? AND wallet_address = ?)", companyId, walletAddress,
+	)
+	if err != nil {
+		return false, errtrace.Errorf("failed to check if wallet is an owner of the company: %w", err)
+	}
+	return owner, nil
+}
+
+func listCompanyOwners(ctx context.Context, db sqlscan.Querier, companyId uint) ([]CompanyOwnerResponse, error) {
+	var owners []entity.CompanyOwner
+	err := sqlscan.Select(ctx, db, &owners,
+		"SELECT id, company_id, chain_namespace, wallet_address, created_at FROM company_owners WHERE company_id = ? ORDER BY id",
+		companyId,
+	)
+	if err != nil {
+		return nil, errtrace.Errorf("failed to list company owners: %w", err)
+	}
+
+	res := make([]CompanyOwnerResponse, 0, len(owners))
+	for _, owner := range owners {
+		res = append(res, CompanyOwnerResponse{
+			ChainNamespace: owner.ChainNamespace,
+			WalletAddress:  owner.WalletAddress,
+			CreatedAt:      owner.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+// addCompanyOwner lets the wallet sign in to the owner api of the company
+func addCompanyOwner(ctx context.Context, db *sql.DB, verifiers *verifier.Registry, companyId uint, req OwnerController_AddOwnerRequest) (CompanyOwnerResponse, error) {
+	v, walletAddress, err := normalizeWalletAddress(verifiers, req.ChainNamespace, req.WalletAddress)
+	if err != nil {
+		return CompanyOwnerResponse{}, err
+	}
+
+	var exists bool
+	err = sqlscan.Get(ctx, db, &exists, "SELECT EXISTS (SELECT 1 FROM companies WHERE id = ?)", companyId)
+	if err != nil {
+		return CompanyOwnerResponse{}, errtrace.Errorf("failed to check if company exists: %w", err)
+	}
+	if !exists {
+		return CompanyOwnerResponse{}, ErrNotFound
+	}
+
+	owner := CompanyOwnerResponse{
+		ChainNamespace: string(v.ChainNamespace()),
+		WalletAddress:  walletAddress,
+		CreatedAt:      time.Now().UTC(),
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO company_owners (company_id, chain_namespace, wallet_address, created_at) VALUES (?, ?, ?, ?)",
+		companyId, owner.ChainNamespace, owner.WalletAddress, owner.CreatedAt,
+	)
+	if err != nil {
+		if sqlite_ext.HasErrCode(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE) {
+			return CompanyOwnerResponse{}, NewHTTPError(http.StatusBadRequest, MsgOwnerAlreadyExists)
+		}
+		return CompanyOwnerResponse{}, errtrace.Errorf("failed to add company owner: %w", err)
+	}
+
+	return owner, nil
+}
+
+// removeCompanyOwner removes an owner of the company, a company can't be left without owners
+func removeCompanyOwner(ctx context.Context, db *sql.DB, verifiers *verifier.Registry, companyId uint, walletAddress string) error {
+	_, walletAddress, err := normalizeWalletAddress(verifiers, "", walletAddress)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM company_owners WHERE company_id = ? AND wallet_address = ?", companyId, walletAddress,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to remove company owner: %w", err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+		return ErrNotFound
+	}
+	var ownersCount int
+	err = sqlscan.Get(ctx, tx, &ownersCount, "SELECT COUNT(*) FROM company_owners WHERE company_id = ?", companyId)
+	if err != nil {
+		return errtrace.Errorf("failed to count company owners: %w", err)
+	}
+	if ownersCount == 0 {
+		return NewHTTPError(http.StatusUnprocessableEntity, MsgCompanyMustHaveAnOwner)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"gatekeeper/internal/entity"
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/crypto_ext"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/samber/do"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnerController(t *testing.T) {
+	ownerWalletAddress, ownerPrivateKey := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, i *do.Injector, s server.Server, company entity.Company)) func(t *testing.T) {
+		i := server_testing.NewTestInjector(t)
+		s := server.NewServer(i, server_testing.TestConfig)
+		company, _ := server_testing.CreateCompany(t, i, ownerWalletAddress)
+		return func(t *testing.T) { testFn(t, i, s, company) }
+	}
+	companyPath := func(companyId uint) string {
+		return "/v1/owner/companies/" + strconv.FormatUint(uint64(companyId), 10)
+	}
+	signIn := func(t *testing.T, s server.Server, companyId uint, walletAddress string, privateKey *ecdsa.PrivateKey) string {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, companyPath(companyId)+"/challenges/issue", nil,
+			server.OwnerController_IssueChallengeRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.OwnerController_IssueChallengeResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, companyPath(companyId)+"/challenges/verify", nil,
+			server.OwnerController_VerifyChallengeRequest{Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.OwnerController_VerifyChallengeResponse](t, res.Body)
+		assert.WithinDuration(t, time.Now().Add(server.OwnerTokenLifetime), body.ExpiredAt, 5*time.Second)
+		return body.OwnerToken
+	}
+	sendReq := func(t *testing.T, s server.Server, ownerToken string, method string, path string, body any) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(t, s.Echo, method, path, map[string]string{"Owner-Token": ownerToken}, body)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+
+		res := sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id), nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.OwnerController_GetCompanyResponse](t, res.Body)
+		assert.Equal(t, company.Id, body.Id)
+		assert.Equal(t, []string{ownerWalletAddress}, body.Owners)
+
+		res = sendReq(t, s, ownerToken, http.MethodPut, companyPath(company.Id), server.OwnerController_UpdateCompanyRequest{
+			Name:           "Odor",
+			AllowedOrigins: []string{"https://odor.com"},
+		})
+		require.Equal(t, http.StatusOK, res.Code)
+		body = echo_ext.ReadBody[server.OwnerController_UpdateCompanyResponse](t, res.Body)
+		assert.Equal(t, "Odor", *body.Name)
+		assert.Equal(t, []string{"https://odor.com"}, body.AllowedOrigins)
+	}))
+
+	t.Run("ApiKeys", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+
+		res := sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/api-keys",
+			server.OwnerController_CreateApiKeyRequest{Label: "Backend", Scopes: []server.ApiKeyScope{server.ApiKeyScope_SessionsWrite}},
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+		apiKey := echo_ext.ReadBody[server.OwnerController_CreateApiKeyResponse](t, res.Body)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": apiKey.ApiKey},
+			server.SessionController_RevokeRequest{WalletAddress: server_testing.WalletAddress},
+		)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/api-keys", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Len(t, echo_ext.ReadBody[server.OwnerController_ListApiKeysResponse](t, res.Body).ApiKeys, 2)
+
+		res = sendReq(t, s, ownerToken, http.MethodDelete, companyPath(company.Id)+"/api-keys/"+strconv.FormatUint(uint64(apiKey.Id), 10), nil)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+
+		// Api keys of other companies can't be revoked
+		res = sendReq(t, s, ownerToken, http.MethodDelete, companyPath(company.Id)+"/api-keys/1", nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("Owners", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+		coOwnerWalletAddress, coOwnerPrivateKey := server_testing.GenerateWalletAddress(t)
+
+		res := sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/owners",
+			server.OwnerController_AddOwnerRequest{WalletAddress: coOwnerWalletAddress},
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+		owner := echo_ext.ReadBody[server.OwnerController_AddOwnerResponse](t, res.Body)
+		assert.Equal(t, coOwnerWalletAddress, owner.WalletAddress)
+		assert.Equal(t, "eip155", owner.ChainNamespace)
+
+		res = sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/owners",
+			server.OwnerController_AddOwnerRequest{WalletAddress: coOwnerWalletAddress},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgOwnerAlreadyExists, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/owners", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Len(t, echo_ext.ReadBody[server.OwnerController_ListOwnersResponse](t, res.Body).Owners, 2)
+
+		// The new owner can sign in and remove the first owner, who loses access right away.
+		// Wallet addresses are normalized, so the owner is found without its checksum.
+		coOwnerToken := signIn(t, s, company.Id, coOwnerWalletAddress, coOwnerPrivateKey)
+		res = sendReq(t, s, coOwnerToken, http.MethodDelete, companyPath(company.Id)+"/owners/"+strings.ToLower(ownerWalletAddress), nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id), nil)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		assert.Equal(t, server.MsgWalletIsNotCompanyOwner, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		// The last owner can't be removed
+		res = sendReq(t, s, coOwnerToken, http.MethodDelete, companyPath(company.Id)+"/owners/"+coOwnerWalletAddress, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.Equal(t, server.MsgCompanyMustHaveAnOwner, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("Accounts", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+		account := server_testing.CreateAccount(t, i, company.Id, []byte(`{"email":"odor@gatekeeper.com"}`))
+
+		res := sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/accounts", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.OwnerController_ListAccountsResponse](t, res.Body)
+		require.Len(t, body.Accounts, 1)
+		assert.Equal(t, account.WalletAddress, body.Accounts[0].WalletAddress)
+		assert.Empty(t, body.NextCursor)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/accounts?metadata.email=other@gatekeeper.com", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, echo_ext.ReadBody[server.OwnerController_ListAccountsResponse](t, res.Body).Accounts)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/accounts/"+strings.ToLower(account.WalletAddress), nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, map[string]any{"email": "odor@gatekeeper.com"}, echo_ext.ReadBody[server.OwnerController_GetAccountResponse](t, res.Body).Metadata)
+
+		// Accounts of other companies are not visible
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/accounts/"+server_testing.WalletAddress, nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("MetadataSchemas", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+
+		for _, schema := range []string{`{"type": "object"}`, `{"type": "object", "required": ["email"]}`} {
+			res := sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/metadata-schemas",
+				server.OwnerController_CreateMetadataSchemaRequest{Schema: []byte(schema)},
+			)
+			require.Equal(t, http.StatusCreated, res.Code)
+		}
+		for _, schema := range []string{`{"type": "jiberish"}`, `{"$ref": "https://odor.com/schema.json"}`, `"jiberish"`} {
+			res := sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/metadata-schemas",
+				server.OwnerController_CreateMetadataSchemaRequest{Schema: []byte(schema)},
+			)
+			require.Equal(t, http.StatusBadRequest, res.Code, schema)
+			assert.Equal(t, server.MsgMetadataSchemaIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+		}
+
+		res := sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/metadata-schemas", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		schemas := echo_ext.ReadBody[server.OwnerController_ListMetadataSchemasResponse](t, res.Body).MetadataSchemas
+		require.Len(t, schemas, 2)
+		assert.Equal(t, uint(1), schemas[0].Version)
+		assert.Equal(t, uint(2), schemas[1].Version)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/metadata-schemas/2", nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"type": "object", "required": ["email"]}`, string(echo_ext.ReadBody[server.OwnerController_GetMetadataSchemaResponse](t, res.Body).Schema))
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id)+"/metadata-schemas/3", nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}))
+
+	t.Run("WalletIsNotOwner", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		// Challenges are issued to any wallet, so that owners can't be found out
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, companyPath(server_testing.CompanyId)+"/challenges/issue", nil,
+			server.OwnerController_IssueChallengeRequest{WalletAddress: ownerWalletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.OwnerController_IssueChallengeResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), ownerPrivateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, companyPath(server_testing.CompanyId)+"/challenges/verify", nil,
+			server.OwnerController_VerifyChallengeRequest{Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		assert.Equal(t, server.MsgWalletIsNotCompanyOwner, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("ChallengePurposeDoesNotMatch", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		apiKey := server_testing.CreateApiKey(t, s.OwnerCtrl.DB, company.Id)
+		issue := func(path string, headers map[string]string) string {
+			res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, path, headers,
+				server.ChallengeController_IssueRequest{WalletAddress: ownerWalletAddress},
+			)
+			require.Equal(t, http.StatusOK, res.Code)
+			return echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+		}
+		sign := func(challenge string) server.ChallengeController_VerifyRequest {
+			signature, err := crypto_ext.PersonalSign([]byte(challenge), ownerPrivateKey)
+			require.NoError(t, err)
+			return server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: hexutil.Encode(signature)}
+		}
+
+		// A login challenge signed by the owner wallet on any login page of the company is not an owner sign in
+		userChallenge := issue("/v1/challenges/issue", map[string]string{"Api-Key": apiKey})
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, companyPath(company.Id)+"/challenges/verify", nil, sign(userChallenge))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.Equal(t, server.MsgChallengeDoesNotExistOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		// And an owner challenge is not a login
+		ownerChallenge := issue(companyPath(company.Id)+"/challenges/issue", nil)
+		assert.True(t, strings.HasPrefix(ownerChallenge, server.ChallengeOwnerMessagePrefix))
+		res = echo_ext.SendTestRequest(t, s.Echo, http.MethodPost, "/v1/challenges/verify", map[string]string{"Api-Key": apiKey}, sign(ownerChallenge))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		assert.Equal(t, server.MsgChallengeDoesNotExistOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+
+	t.Run("OwnerTokenIsInvalid", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+		proofToken := server_testing.GenerateProofToken(t, i, company.Id, ownerWalletAddress, time.Now().Add(time.Minute))
+
+		// Owner tokens only work for their company and proof tokens are not owner tokens
+		for _, req := range []struct {
+			companyId  uint
+			ownerToken string
+		}{
+			{server_testing.CompanyId, ownerToken},
+			{company.Id, proofToken},
+			{company.Id, ""},
+		} {
+			res := sendReq(t, s, req.ownerToken, http.MethodGet, companyPath(req.companyId), nil)
+			require.Equal(t, http.StatusUnauthorized, res.Code)
+			assert.Equal(t, server.MsgOwnerTokenIsInvalidOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+		}
+	}))
+
+	t.Run("SignOut", newTest(func(t *testing.T, i *do.Injector, s server.Server, company entity.Company) {
+		ownerToken := signIn(t, s, company.Id, ownerWalletAddress, ownerPrivateKey)
+
+		res := sendReq(t, s, ownerToken, http.MethodPost, companyPath(company.Id)+"/sign-out", nil)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		res = sendReq(t, s, ownerToken, http.MethodGet, companyPath(company.Id), nil)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	}))
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"braces.dev/errtrace"
 	"github.com/gookit/validate"
@@ -54,16 +55,44 @@ type ErrorResponse struct {
 type Config struct {
 	Env  string `env:"ENV" env-default:"production"`
 	Port uint   `env:"HTTP_PORT" env-default:"3000"`
+	// Public url of Gatekeeper, used as issuer of the tokens it signs
+	IssuerUrl string `env:"ISSUER_URL" env-default:"http://localhost:3000"`
+	// Credential of the admin api used by Gatekeeper operators, the admin api is disabled when empty
+	AdminApiKey string `env:"ADMIN_API_KEY"`
+	// Key of the hashes of the api key secrets, changing it invalidates every api key
+	ApiKeyPepper string `env:"API_KEY_PEPPER" env-required:"true"`
+	// Time after the import of the legacy signing key during which the tokens it signed without kid are accepted,
+	// it must be longer than the lifetime of the tokens
+	LegacyTokensTransition time.Duration `env:"LEGACY_TOKENS_TRANSITION" env-default:"24h"`
+	// Key of the hashes of the wallet addresses of erased accounts
+	WalletAddressPepper string `env:"WALLET_ADDRESS_PEPPER" env-required:"true"`
+	// Time during which a deleted account can be restored before it is erased
+	AccountDeletionGracePeriod time.Duration `env:"ACCOUNT_DELETION_GRACE_PERIOD" env-default:"720h"`
+	// Limits of the metadata of an account, in bytes of its JSON encoding and in levels of nested objects and arrays
+	MetadataMaxSize  uint `env:"METADATA_MAX_SIZE" env-default:"16384"`
+	MetadataMaxDepth uint `env:"METADATA_MAX_DEPTH" env-default:"8"`
 }
 
 type Server struct {
-	Config        Config
-	Echo          *echo.Echo
-	ChallengeCtrl ChallengeController
-	AccountCtrl   AccountController
+	Config            Config
+	Echo              *echo.Echo
+	ChallengeCtrl     ChallengeController
+	AccountCtrl       AccountController
+	SessionCtrl       SessionController
+	IntrospectionCtrl IntrospectionController
+	LoginCtrl         LoginController
+	OidcCtrl          OidcController
+	DeviceCtrl        DeviceController
+	WellKnownCtrl     WellKnownController
+	ApiKeyCtrl        ApiKeyController
+	AdminCtrl         AdminController
+	OwnerCtrl         OwnerController
 }
 
 func NewServer(i *do.Injector, config Config) Server {
+	// Controllers read the config from the injector, it is replaced in case the injector was built with another config
+	do.OverrideValue(i, config)
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -108,12 +137,22 @@ func NewServer(i *do.Injector, config Config) Server {
 	}
 
 	v1 := e.Group("/v1")
+	wellKnown := e.Group("/.well-known")
 
 	return Server{
-		Config:        config,
-		Echo:          e,
-		ChallengeCtrl: NewChallengeController(v1, i),
-		AccountCtrl:   NewAccountController(v1, i),
+		Config:            config,
+		Echo:              e,
+		ChallengeCtrl:     NewChallengeController(v1, i),
+		AccountCtrl:       NewAccountController(v1, i),
+		SessionCtrl:       NewSessionController(v1, i),
+		IntrospectionCtrl: NewIntrospectionController(v1, i),
+		LoginCtrl:         NewLoginController(e, i),
+		OidcCtrl:          NewOidcController(e, i),
+		DeviceCtrl:        NewDeviceController(e, i),
+		WellKnownCtrl:     NewWellKnownController(wellKnown, i),
+		ApiKeyCtrl:        NewApiKeyController(v1, i),
+		AdminCtrl:         NewAdminController(v1, i),
+		OwnerCtrl:         NewOwnerController(v1, i),
 	}
 }
 
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gatekeeper/internal/entity"
+	"gatekeeper/pkg/jwt_provider"
+	"gatekeeper/pkg/verifier"
+	"net/http"
+	"strconv"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+const RefreshTokenLength uint = 32
+
+const MsgRefreshTokenIsInvalidOrExpired = "Refresh token is invalid or has expired"
+
+type SessionController struct {
+	DB          *sql.DB
+	JwtProvider jwt_provider.Provider
+	Verifiers   *verifier.Registry
+}
+
+func NewSessionController(echoGrp *echo.Group, i *do.Injector) SessionController {
+	ct := SessionController{
+		DB:          do.MustInvoke[*sql.DB](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+		Verifiers:   do.MustInvoke[*verifier.Registry](i),
+	}
+
+	sessions := echoGrp.Group("/sessions", NewApiKeyMiddleware(i, ApiKeyScope_SessionsWrite))
+	sessions.POST("/refresh", ct.Refresh)
+	sessions.DELETE("/current", ct.DeleteCurrent, NewProofTokenMiddleware(i))
+	sessions.POST("/revoke", ct.Revoke)
+
+	return ct
+}
+
+// SessionTokens are returned when a session starts and each time it is refreshed
+type SessionTokens struct {
+	ProofToken            string    `json:"proofToken"`
+	ProofTokenExpiredAt   time.Time `json:"proofTokenExpiredAt"`
+	RefreshToken          string    `json:"refreshToken"`
+	RefreshTokenExpiredAt time.Time `json:"refreshTokenExpiredAt"`
+}
+
+type SessionController_RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type SessionController_RefreshResponse = SessionTokens
+
+// Refresh exchanges a refresh token for a new proof token and a new refresh token.
+// Refresh tokens can only be used once, using one again revokes the whole session as the token has leaked.
+func (ct SessionController) Refresh(c echo.Context) error {
+	req, err := bindAndValidate[SessionController_RefreshRequest](c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := refreshSession(c.Request().Context(), ct.DB, ct.JwtProvider, getContextValue[uint](c, ContextKey_CompanyId), req.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, tokens))
+}
+
+// refreshSession rotates a refresh token of a session of the company
+func refreshSession(ctx context.Context, db *sql.DB, jwtProvider jwt_provider.Provider, companyId uint, token string) (SessionTokens, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Get refresh token and its session
+	var refreshToken entity.RefreshToken
+	err = sqlscan.Get(ctx, tx, &refreshToken,
+		`SELECT refresh_tokens.id, refresh_tokens.session_id, refresh_tokens.expired_at
+		FROM refresh_tokens JOIN sessions ON sessions.id = refresh_tokens.session_id
+		WHERE refresh_tokens.token_hash = ? AND sessions.company_id = ? LIMIT 1`,
+		hashRefreshToken(token), companyId,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SessionTokens{}, NewHTTPError(http.StatusBadRequest, MsgRefreshTokenIsInvalidOrExpired)
+		}
+		return SessionTokens{}, errtrace.Errorf("failed to get refresh token: %w", err)
+	}
+	var session entity.Session
+	err = sqlscan.Get(ctx, tx, &session,
+		"SELECT id, company_id, wallet_address, created_at, expired_at, revoked_at FROM sessions WHERE id = ? LIMIT 1",
+		refreshToken.SessionId,
+	)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to get session: %w", err)
+	}
+
+	// Rotate refresh token, it is only marked as used by the first of concurrent refreshes
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", now, refreshToken.Id)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to mark refresh token as used: %w", err)
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to mark refresh token as used: %w", err)
+	}
+
+	// Revoke session on reuse
+	if used != 1 {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, session.Id,
+		)
+		if err != nil {
+			return SessionTokens{}, errtrace.Errorf("failed to revoke session (id: %d): %w", session.Id, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return SessionTokens{}, errtrace.Errorf("failed to commit transaction: %w", err)
+		}
+		return SessionTokens{}, NewHTTPError(http.StatusBadRequest, MsgRefreshTokenIsInvalidOrExpired)
+	}
+
+	// Check if expired, the token is not marked as used as the transaction is rolled back
+	if session.RevokedAt != nil || !session.ExpiredAt.After(now) || !refreshToken.ExpiredAt.After(now) {
+		return SessionTokens{}, NewHTTPError(http.StatusBadRequest, MsgRefreshTokenIsInvalidOrExpired)
+	}
+
+	tokens, err := newSessionTokens(ctx, tx, jwtProvider, session)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// DeleteCurrent logs out the session of the proof token.
+// The proof token is revoked until it expires and the refresh tokens of the session can no longer be used.
+func (ct SessionController) DeleteCurrent(c echo.Context) error {
+	claims := getContextValue[*jwt_provider.Claims](c, ContextKey_ProofTokenClaims)
+	companyId := getContextValue[uint](c, ContextKey_CompanyId)
+	ctx := c.Request().Context()
+
+	tx, err := ct.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = revokeProofToken(ctx, tx, claims)
+	if err != nil {
+		return err
+	}
+	if claims.SessionId != "" {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = ? WHERE id = ? AND company_id = ? AND revoked_at IS NULL",
+			time.Now().UTC(), claims.SessionId, companyId,
+		)
+		if err != nil {
+			return errtrace.Errorf("failed to revoke session (id: %s): %w", claims.SessionId, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errtrace.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errtrace.Wrap(c.NoContent(http.StatusNoContent))
+}
+
+type SessionController_RevokeRequest struct {
+	WalletAddress string `json:"walletAddress" validate:"required"`
+}
+
+type SessionController_RevokeResponse struct {
+	RevokedSessions int64 `json:"revokedSessions"`
+}
+
+// Revoke revokes all sessions of a wallet, e.g. when the wallet has been compromised.
+// Proof tokens of the revoked sessions are rejected from then on.
+func (ct SessionController) Revoke(c echo.Context) error {
+	req, err := bindAndValidate[SessionController_RevokeRequest](c)
+	if err != nil {
+		return err
+	}
+
+	_, walletAddress, ok := ct.Verifiers.Detect(req.WalletAddress)
+	if !ok {
+		return NewHTTPError(http.StatusBadRequest, MsgWalletAddressIsInvalid)
+	}
+	res, err := ct.DB.ExecContext(c.Request().Context(),
+		"UPDATE sessions SET revoked_at = ? WHERE company_id = ? AND wallet_address = ? AND revoked_at IS NULL",
+		time.Now().UTC(), getContextValue[uint](c, ContextKey_CompanyId), walletAddress,
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to revoke sessions: %w", err)
+	}
+	revokedSessions, err := res.RowsAffected()
+	if err != nil {
+		return errtrace.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return errtrace.Wrap(c.JSON(http.StatusOK, SessionController_RevokeResponse{RevokedSessions: revokedSessions}))
+}
+
+// revokeProofToken adds the proof token to the revocation list, it is kept until the token expires
+func revokeProofToken(ctx context.Context, tx *sql.Tx, claims *jwt_provider.Claims) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, revoked_at, expired_at) VALUES (?, ?, ?) ON CONFLICT (jti) DO NOTHING",
+		claims.ID, time.Now().UTC(), claims.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return errtrace.Errorf("failed to revoke proof token (jti: %s): %w", claims.ID, err)
+	}
+	return nil
+}
+
+// createSession starts a session of the wallet, it lasts the session lifetime of the company at most
+func createSession(ctx context.Context, tx *sql.Tx, jwtProvider jwt_provider.Provider, companyId uint, walletAddress string) (SessionTokens, error) {
+	var sessionLifetime uint
+	err := sqlscan.Get(ctx, tx, &sessionLifetime, "SELECT session_lifetime FROM companies WHERE id = ?", companyId)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to get company session lifetime: %w", err)
+	}
+
+	session := entity.Session{
+		CompanyId:     companyId,
+		WalletAddress: walletAddress,
+		CreatedAt:     time.Now().UTC(),
+	}
+	session.ExpiredAt = session.CreatedAt.Add(time.Duration(sessionLifetime) * time.Second)
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO sessions (company_id, wallet_address, created_at, expired_at) VALUES (?, ?, ?, ?)",
+		session.CompanyId, session.WalletAddress, session.CreatedAt, session.ExpiredAt,
+	)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to create session: %w", err)
+	}
+	sessionId, err := res.LastInsertId()
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to get session id: %w", err)
+	}
+	session.Id = uint(sessionId)
+
+	return newSessionTokens(ctx, tx, jwtProvider, session)
+}
+
+// newSessionTokens signs a new proof token and stores a new refresh token for the session
+func newSessionTokens(ctx context.Context, tx *sql.Tx, jwtProvider jwt_provider.Provider, session entity.Session) (SessionTokens, error) {
+	var company entity.Company
+	err := sqlscan.Get(ctx, tx, &company,
+		"SELECT proof_token_lifetime, refresh_token_lifetime FROM companies WHERE id = ?", session.CompanyId,
+	)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to get company token lifetimes: %w", err)
+	}
+	now := time.Now().UTC()
+
+	// Generate proof token
+	proofTokenExpiredAt := minTime(now.Add(time.Duration(company.ProofTokenLifetime)*time.Second), session.ExpiredAt)
+	claims, err := jwtProvider.NewClaims(session.WalletAddress, ProofTokenAudience(session.CompanyId), proofTokenExpiredAt.Sub(now))
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to create proof token claims: %w", err)
+	}
+	claims.SessionId = strconv.FormatUint(uint64(session.Id), 10)
+	proofToken, err := jwtProvider.GenerateSignedToken(claims)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to generate proof token: %w", err)
+	}
+
+	// Generate refresh token
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshTokenExpiredAt := minTime(now.Add(time.Duration(company.RefreshTokenLifetime)*time.Second), session.ExpiredAt)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (session_id, token_hash, created_at, expired_at) VALUES (?, ?, ?, ?)",
+		session.Id, hashRefreshToken(refreshToken), now, refreshTokenExpiredAt,
+	)
+	if err != nil {
+		return SessionTokens{}, errtrace.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return SessionTokens{
+		ProofToken:            proofToken,
+		ProofTokenExpiredAt:   claims.ExpiresAt.Time,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiredAt: refreshTokenExpiredAt,
+	}, nil
+}
+
+func generateRefreshToken() (string, error) {
+	refreshTokenBytes := make([]byte, RefreshTokenLength)
+	_, err := rand.Read(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(refreshTokenBytes), nil
+}
+
+// Refresh tokens have enough entropy to be stored with a fast hash
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
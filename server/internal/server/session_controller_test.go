@@ -0,0 +1,295 @@
+package server_test
+
+import (
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/crypto_ext"
+	"gatekeeper/pkg/echo_ext"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionController_Refresh(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	login := func(t *testing.T, s server.Server) server.SessionTokens {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_IssueRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
+	}
+	refreshWithApiKey := func(t *testing.T, s server.Server, apiKey string, refreshToken string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/refresh",
+			map[string]string{"Api-Key": apiKey},
+			server.SessionController_RefreshRequest{RefreshToken: refreshToken},
+		)
+	}
+	refresh := func(t *testing.T, s server.Server, refreshToken string) *httptest.ResponseRecorder {
+		return refreshWithApiKey(t, s, server_testing.ApiKey, refreshToken)
+	}
+	requireInvalid := func(t *testing.T, res *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		body := echo_ext.ReadBody[server.ErrorResponse](t, res.Body)
+		assert.Equal(t, server.MsgRefreshTokenIsInvalidOrExpired, body.Error)
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.WithinDuration(t, time.Now().Add(5*time.Minute), tokens.ProofTokenExpiredAt, 5*time.Second)
+		assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), tokens.RefreshTokenExpiredAt, 5*time.Second)
+
+		res := refresh(t, s, tokens.RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+		refreshed := echo_ext.ReadBody[server.SessionController_RefreshResponse](t, res.Body)
+		assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+		assert.NotEqual(t, tokens.ProofToken, refreshed.ProofToken)
+
+		claims, err := s.SessionCtrl.JwtProvider.GetClaims(refreshed.ProofToken, server.ProofTokenAudience(server_testing.CompanyId))
+		require.NoError(t, err)
+		sub, err := claims.GetSubject()
+		require.NoError(t, err)
+		assert.Equal(t, walletAddress, sub)
+
+		// The new refresh token can be used in turn
+		res = refresh(t, s, refreshed.RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("ReuseRevokesSession", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		res := refresh(t, s, tokens.RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+		refreshed := echo_ext.ReadBody[server.SessionController_RefreshResponse](t, res.Body)
+
+		requireInvalid(t, refresh(t, s, tokens.RefreshToken))
+		requireInvalid(t, refresh(t, s, refreshed.RefreshToken))
+
+		// Other sessions of the wallet are not affected
+		res = refresh(t, s, login(t, s).RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+	}))
+
+	t.Run("ConcurrentRefresh", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+
+		var wg sync.WaitGroup
+		results := make([]*httptest.ResponseRecorder, 5)
+		for idx := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[idx] = refresh(t, s, tokens.RefreshToken)
+			}()
+		}
+		wg.Wait()
+
+		// Only one refresh succeeds, the others are reuses which revoke the session
+		var refreshed []server.SessionController_RefreshResponse
+		for _, res := range results {
+			if res.Code == http.StatusOK {
+				refreshed = append(refreshed, echo_ext.ReadBody[server.SessionController_RefreshResponse](t, res.Body))
+				continue
+			}
+			requireInvalid(t, res)
+		}
+		require.Len(t, refreshed, 1)
+		requireInvalid(t, refresh(t, s, refreshed[0].RefreshToken))
+	}))
+
+	t.Run("RefreshTokenExpired", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		_, err := s.SessionCtrl.DB.Exec("UPDATE refresh_tokens SET expired_at = ?", time.Now().UTC().Add(-time.Minute))
+		require.NoError(t, err)
+
+		requireInvalid(t, refresh(t, s, tokens.RefreshToken))
+	}))
+
+	t.Run("SessionExpired", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		_, err := s.SessionCtrl.DB.Exec("UPDATE sessions SET expired_at = ?", time.Now().UTC().Add(-time.Minute))
+		require.NoError(t, err)
+
+		requireInvalid(t, refresh(t, s, tokens.RefreshToken))
+	}))
+
+	t.Run("RefreshTokenDoesNotExist", newTest(func(t *testing.T, s server.Server) {
+		requireInvalid(t, refresh(t, s, "jiberish"))
+	}))
+
+	t.Run("OtherCompany", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.SessionCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.SessionCtrl.DB, otherCompanyId)
+
+		requireInvalid(t, refreshWithApiKey(t, s, otherApiKey, tokens.RefreshToken))
+	}))
+
+	t.Run("CompanyLifetimes", newTest(func(t *testing.T, s server.Server) {
+		_, err := s.SessionCtrl.DB.Exec(
+			"UPDATE companies SET proof_token_lifetime = 60, refresh_token_lifetime = 3600, session_lifetime = 1800 WHERE id = ?",
+			server_testing.CompanyId,
+		)
+		require.NoError(t, err)
+
+		tokens := login(t, s)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), tokens.ProofTokenExpiredAt, 5*time.Second)
+		// Refresh tokens do not outlive their session
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), tokens.RefreshTokenExpiredAt, 5*time.Second)
+	}))
+}
+
+func TestSessionController_Revocation(t *testing.T) {
+	walletAddress, privateKey := server_testing.GenerateWalletAddress(t)
+
+	newTest := func(testFn func(t *testing.T, s server.Server)) func(t *testing.T) {
+		s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s) }
+	}
+
+	login := func(t *testing.T, s server.Server) server.SessionTokens {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/issue",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_IssueRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		challenge := echo_ext.ReadBody[server.ChallengeController_IssueResponse](t, res.Body).Challenge
+		signature, err := crypto_ext.PersonalSign([]byte(challenge), privateKey)
+		require.NoError(t, err)
+
+		res = echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/challenges/verify",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.ChallengeController_VerifyRequest{Challenge: challenge, Signature: hexutil.Encode(signature)},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return echo_ext.ReadBody[server.ChallengeController_VerifyResponse](t, res.Body)
+	}
+	// The account does not exist, so a proof token accepted by the middleware results in 404
+	useProofToken := func(t *testing.T, s server.Server, proofToken string) *httptest.ResponseRecorder {
+		return echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodGet, "/v1/accounts/"+walletAddress+"/metadata",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": proofToken}, nil,
+		)
+	}
+	requireRevoked := func(t *testing.T, s server.Server, tokens server.SessionTokens) {
+		res := useProofToken(t, s, tokens.ProofToken)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgProofTokenIsInvalidOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+
+		res = echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/refresh",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.SessionController_RefreshRequest{RefreshToken: tokens.RefreshToken},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgRefreshTokenIsInvalidOrExpired, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}
+
+	t.Run("DeleteCurrent", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		otherTokens := login(t, s)
+		require.Equal(t, http.StatusNotFound, useProofToken(t, s, tokens.ProofToken).Code)
+
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodDelete, "/v1/sessions/current",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": tokens.ProofToken}, nil,
+		)
+		require.Equal(t, http.StatusNoContent, res.Code)
+		requireRevoked(t, s, tokens)
+
+		// Other sessions of the wallet are not affected
+		require.Equal(t, http.StatusNotFound, useProofToken(t, s, otherTokens.ProofToken).Code)
+	}))
+
+	t.Run("DeleteCurrentWithRevokedProofToken", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodDelete, "/v1/sessions/current",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": tokens.ProofToken}, nil,
+		)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		res = echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodDelete, "/v1/sessions/current",
+			map[string]string{"Api-Key": server_testing.ApiKey, "Proof-Token": tokens.ProofToken}, nil,
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	}))
+
+	t.Run("Revoke", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		otherTokens := login(t, s)
+
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.SessionController_RevokeRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.SessionController_RevokeResponse](t, res.Body)
+		assert.Equal(t, int64(2), body.RevokedSessions)
+		requireRevoked(t, s, tokens)
+		requireRevoked(t, s, otherTokens)
+
+		// The wallet can login again
+		require.Equal(t, http.StatusNotFound, useProofToken(t, s, login(t, s).ProofToken).Code)
+	}))
+
+	t.Run("RevokeOtherCompany", newTest(func(t *testing.T, s server.Server) {
+		tokens := login(t, s)
+		otherCompanyId := server_testing.CompanyId + 1
+		_, err := s.SessionCtrl.DB.Exec("INSERT INTO companies (id) VALUES (?)", otherCompanyId)
+		require.NoError(t, err)
+		otherApiKey := server_testing.CreateApiKey(t, s.SessionCtrl.DB, otherCompanyId)
+
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": otherApiKey},
+			server.SessionController_RevokeRequest{WalletAddress: walletAddress},
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		body := echo_ext.ReadBody[server.SessionController_RevokeResponse](t, res.Body)
+		assert.Equal(t, int64(0), body.RevokedSessions)
+		require.Equal(t, http.StatusNotFound, useProofToken(t, s, tokens.ProofToken).Code)
+	}))
+
+	t.Run("RevokeWalletAddressIsInvalid", newTest(func(t *testing.T, s server.Server) {
+		res := echo_ext.SendTestRequest(
+			t, s.Echo, http.MethodPost, "/v1/sessions/revoke",
+			map[string]string{"Api-Key": server_testing.ApiKey},
+			server.SessionController_RevokeRequest{WalletAddress: "jiberish"},
+		)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, server.MsgWalletAddressIsInvalid, echo_ext.ReadBody[server.ErrorResponse](t, res.Body).Error)
+	}))
+}
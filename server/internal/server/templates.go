@@ -0,0 +1,24 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+
+	"braces.dev/errtrace"
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var templates = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+func renderTemplate(c echo.Context, code int, name string, data any) error {
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return errtrace.Errorf("failed to render template %s: %w", name, err)
+	}
+	return errtrace.Wrap(c.HTMLBlob(code, buf.Bytes()))
+}
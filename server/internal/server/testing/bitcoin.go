@@ -0,0 +1,73 @@
+package server_testing
+
+import (
+	"encoding/base64"
+	"gatekeeper/pkg/bip137"
+	"gatekeeper/pkg/bip322"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// BitcoinWallet holds a key and the mainnet addresses derived from it
+type BitcoinWallet struct {
+	PrivateKey *btcec.PrivateKey
+	P2pkh      btcutil.Address
+	P2wpkh     btcutil.Address
+	P2tr       btcutil.Address
+}
+
+func GenerateBitcoinWallet(t *testing.T) BitcoinWallet {
+	privateKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	publicKeyHash := btcutil.Hash160(privateKey.PubKey().SerializeCompressed())
+
+	p2pkh, err := btcutil.NewAddressPubKeyHash(publicKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(publicKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	// https://github.com/bitcoin/bips/blob/master/bip-0086.mediawiki
+	outputKey := txscript.ComputeTaprootKeyNoScript(privateKey.PubKey())
+	p2tr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	return BitcoinWallet{PrivateKey: privateKey, P2pkh: p2pkh, P2wpkh: p2wpkh, P2tr: p2tr}
+}
+
+func SignBip137Message(t *testing.T, privateKey *btcec.PrivateKey, address btcutil.Address, message string) string {
+	signature, err := bip137.Sign(privateKey, address, []byte(message))
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// SignBip322Message creates a BIP-322 simple signature for a P2WPKH or P2TR address
+func SignBip322Message(t *testing.T, privateKey *btcec.PrivateKey, address btcutil.Address, message string) string {
+	pkScript, err := txscript.PayToAddrScript(address)
+	require.NoError(t, err)
+	toSpend, err := bip322.ToSpendTx(pkScript, []byte(message))
+	require.NoError(t, err)
+	toSign, err := bip322.ToSignTx(toSpend)
+	require.NoError(t, err)
+	sigHashes := bip322.NewSigHashes(toSign, pkScript)
+
+	var witness wire.TxWitness
+	switch address.(type) {
+	case *btcutil.AddressWitnessPubKeyHash:
+		witness, err = txscript.WitnessSignature(toSign, sigHashes, 0, 0, pkScript, txscript.SigHashAll, privateKey, true)
+	case *btcutil.AddressTaproot:
+		witness, err = txscript.TaprootWitnessSignature(toSign, sigHashes, 0, 0, pkScript, txscript.SigHashDefault, privateKey)
+	default:
+		t.Fatalf("unsupported address type %T", address)
+	}
+	require.NoError(t, err)
+
+	signature, err := bip322.EncodeSimpleSignature(witness)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,60 @@
+package server_testing
+
+import (
+	"gatekeeper/pkg/eip1271"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// Runtime code of a minimal EIP-1271 wallet that accepts signatures made by owner
+//
+//	00: hash = calldata[0x04], v = calldata[0xa4], r = calldata[0x64], s = calldata[0x84]
+//	1b: STATICCALL ecrecover
+//	28: PUSH1 0x80 MLOAD PUSH20 owner EQ PUSH1 0x49 JUMPI
+//	44: PUSH1 0x20 PUSH1 0xa0 RETURN            ; return zeros
+//	49: JUMPDEST PUSH4 0x1626ba7e PUSH1 0xe0 SHL PUSH1 0x00 MSTORE PUSH1 0x20 PUSH1 0x00 RETURN
+func SmartWalletCode(owner common.Address) []byte {
+	code := common.FromHex("0x60043560005260a43560001a602052606435604052608435606052602060806080600060015afa50608051")
+	code = append(code, 0x73)
+	code = append(code, owner.Bytes()...)
+	return append(code, common.FromHex("0x14604957602060a0f35b631626ba7e60e01b60005260206000f3")...)
+}
+
+// Runtime code of a factory that deploys its calldata as init code with CREATE2 and a zero salt
+var SmartWalletFactoryCode = common.FromHex("0x36600060003760003660006000f500")
+
+// InitCode wraps runtime code in a constructor that deploys it
+func InitCode(runtimeCode []byte) []byte {
+	code := []byte{0x60, byte(len(runtimeCode))}
+	code = append(code, common.FromHex("0x80600b6000396000f3")...)
+	return append(code, runtimeCode...)
+}
+
+// CounterfactualSmartWalletAddress returns the address the factory will deploy the wallet init code to
+func CounterfactualSmartWalletAddress(factory common.Address, walletInitCode []byte) common.Address {
+	return crypto.CreateAddress2(factory, [32]byte{}, crypto.Keccak256(walletInitCode))
+}
+
+// https://eips.ethereum.org/EIPS/eip-6492
+func WrapErc6492Signature(t *testing.T, factory common.Address, factoryCalldata []byte, signature []byte) []byte {
+	addressType, err := abi.NewType("address", "", nil)
+	require.NoError(t, err)
+	bytesType, err := abi.NewType("bytes", "", nil)
+	require.NoError(t, err)
+
+	wrapped, err := abi.Arguments{{Type: addressType}, {Type: bytesType}, {Type: bytesType}}.Pack(factory, factoryCalldata, signature)
+	require.NoError(t, err)
+	return append(wrapped, eip1271.Erc6492Suffix...)
+}
+
+func NewSimulatedBackend(t *testing.T, alloc core.GenesisAlloc) *backends.SimulatedBackend {
+	backend := backends.NewSimulatedBackend(alloc, 30_000_000)
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
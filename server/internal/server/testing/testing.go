@@ -2,43 +2,79 @@ package server_testing
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"database/sql"
 	"gatekeeper/internal/entity"
 	"gatekeeper/internal/helper"
+	"gatekeeper/internal/server"
 	"gatekeeper/pkg/jwt_provider"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcd/btcutil/base58"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/samber/do"
 	"github.com/stretchr/testify/require"
 )
 
+const CompanyId uint = 1
 const ApiKey = "018df6ccab907592ae2da5c3dd9a79f3AFF3MAUaKHt9DVuBBi4Jzw"
 const WalletAddress = "0x25a3aaf7a4fF88A8aa53ff63CFE5e8C16ce93756"
+const AdminApiKey = "test-admin-api-key"
 
-func CreateCompany(t *testing.T, i *do.Injector, adminAccountId uint) entity.Company {
+// Pepper the api key of the seed is hashed with
+const ApiKeyPepper = "gatekeeper-development"
+
+// CreateCompany creates a company owned by the wallet and returns it with its api key
+func CreateCompany(t *testing.T, i *do.Injector, ownerWalletAddress string) (entity.Company, string) {
 	db := do.MustInvoke[*sql.DB](i)
 
-	apiKey, err := helper.GenerateApiKey()
+	res, err := db.Exec("INSERT INTO companies DEFAULT VALUES")
 	require.NoError(t, err)
 
-	res, err := db.Exec(
-		"INSERT INTO companies (api_key, admin_account_id) VALUES (?, ?)",
-		apiKey, adminAccountId,
-	)
+	id, err := res.LastInsertId()
 	require.NoError(t, err)
 
-	id, err := res.LastInsertId()
+	_, err = db.Exec("INSERT INTO company_owners (company_id, wallet_address) VALUES (?, ?)", id, ownerWalletAddress)
 	require.NoError(t, err)
 
-	return entity.Company{
-		Id:             uint(id),
-		CreatedAt:      time.Now(),
-		ApiKey:         apiKey,
-		AdminAccountId: adminAccountId,
+	company := entity.Company{
+		Id:        uint(id),
+		CreatedAt: time.Now(),
 	}
+	return company, CreateApiKey(t, db, company.Id)
+}
+
+// CreateApiKey creates an api key of the company with the scopes, or all scopes when none are given, and returns it
+func CreateApiKey(t *testing.T, db *sql.DB, companyId uint, scopes ...server.ApiKeyScope) string {
+	return createApiKey(t, db, companyId, false, scopes)
+}
+
+// CreatePublishableApiKey creates a publishable api key of the company and returns it
+func CreatePublishableApiKey(t *testing.T, db *sql.DB, companyId uint) string {
+	return createApiKey(t, db, companyId, true, server.PublishableApiKeyScopes)
+}
+
+func createApiKey(t *testing.T, db *sql.DB, companyId uint, publishable bool, scopes []server.ApiKeyScope) string {
+	apiKey, err := helper.GenerateApiKey()
+	require.NoError(t, err)
+	prefix, secret, _ := helper.SplitApiKey(apiKey)
+	if len(scopes) == 0 {
+		scopes = server.ApiKeyScopes
+	}
+	formattedScopes := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		formattedScopes = append(formattedScopes, string(scope))
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO api_keys (company_id, prefix, secret_hash, scopes, publishable) VALUES (?, ?, ?, ?, ?)",
+		companyId, prefix, helper.Hmac(ApiKeyPepper, secret), strings.Join(formattedScopes, " "), publishable,
+	)
+	require.NoError(t, err)
+
+	return apiKey
 }
 
 func CreateAccount(t *testing.T, i *do.Injector, companyId uint, metadata []byte) entity.Account {
@@ -69,12 +105,17 @@ func GenerateWalletAddress(t *testing.T) (string, *ecdsa.PrivateKey) {
 	return address.Hex(), privateKey
 }
 
-func GenerateProofToken(t *testing.T, i *do.Injector, walletAddress string, expiredAt time.Time) string {
+func GenerateSolanaWalletAddress(t *testing.T) (string, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return base58.Encode(publicKey), privateKey
+}
+
+func GenerateProofToken(t *testing.T, i *do.Injector, companyId uint, walletAddress string, expiredAt time.Time) string {
 	jwtProvider := do.MustInvoke[jwt_provider.Provider](i)
-	proofToken, err := jwtProvider.GenerateSignedToken(jwt.RegisteredClaims{
-		Subject:   walletAddress,
-		ExpiresAt: &jwt.NumericDate{Time: expiredAt},
-	})
+	claims, err := jwtProvider.NewClaims(walletAddress, server.ProofTokenAudience(companyId), time.Until(expiredAt))
+	require.NoError(t, err)
+	proofToken, err := jwtProvider.GenerateSignedToken(claims)
 	require.NoError(t, err)
 	return proofToken
 }
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"gatekeeper/pkg/jwt_provider"
+	"net/http"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/do"
+)
+
+// Clients refetch the key set when they see an unknown kid, so it can be cached
+const JwksCacheControl = "public, max-age=300"
+
+type WellKnownController struct {
+	Config      Config
+	JwtProvider jwt_provider.Provider
+}
+
+func NewWellKnownController(echoGrp *echo.Group, i *do.Injector) WellKnownController {
+	ct := WellKnownController{
+		Config:      do.MustInvoke[Config](i),
+		JwtProvider: do.MustInvoke[jwt_provider.Provider](i),
+	}
+
+	echoGrp.GET("/jwks.json", ct.Jwks)
+	echoGrp.GET("/openid-configuration", ct.OpenIdConfiguration)
+
+	return ct
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7517#section-5
+func (ct WellKnownController) Jwks(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, JwksCacheControl)
+	return errtrace.Wrap(c.JSON(http.StatusOK, ct.JwtProvider.JWKS()))
+}
+
+type WellKnownController_OpenIdConfigurationResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+func (ct WellKnownController) OpenIdConfiguration(c echo.Context) error {
+	issuer := strings.TrimSuffix(ct.Config.IssuerUrl, "/")
+	c.Response().Header().Set(echo.HeaderCacheControl, JwksCacheControl)
+	return errtrace.Wrap(c.JSON(http.StatusOK, WellKnownController_OpenIdConfigurationResponse{
+		Issuer:                            ct.Config.IssuerUrl,
+		AuthorizationEndpoint:             issuer + OidcAuthorizePath,
+		TokenEndpoint:                     issuer + OidcTokenPath,
+		UserinfoEndpoint:                  issuer + OidcUserinfoPath,
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{OidcScope_OpenId},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodES256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{OidcCodeChallengeMethod},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "sid", "nonce", "auth_time"},
+	}))
+}
@@ -0,0 +1,224 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"gatekeeper/internal/server"
+	server_testing "gatekeeper/internal/server/testing"
+	"gatekeeper/pkg/echo_ext"
+	"gatekeeper/pkg/jwt_provider"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/do"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWellKnownController_Jwks(t *testing.T) {
+	keysDir := t.TempDir()
+	oldKey, _, err := jwt_provider.RotateKeys(keysDir, time.Hour)
+	require.NoError(t, err)
+
+	newTest := func(testFn func(t *testing.T, s server.Server, provider jwt_provider.Provider)) func(t *testing.T) {
+		keys, err := jwt_provider.LoadKeys(keysDir)
+		require.NoError(t, err)
+		provider, err := jwt_provider.NewProvider(keys, "http://localhost:3000")
+		require.NoError(t, err)
+
+		i := server_testing.NewTestInjector(t)
+		do.OverrideValue(i, provider)
+		s := server.NewServer(i, server_testing.TestConfig)
+		return func(t *testing.T) { testFn(t, s, provider) }
+	}
+
+	getJwks := func(t *testing.T, s server.Server) jwt_provider.JSONWebKeySet {
+		res := echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/.well-known/jwks.json", nil, nil)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, server.JwksCacheControl, res.Header().Get("Cache-Control"))
+		return echo_ext.ReadBody[jwt_provider.JSONWebKeySet](t, res.Body)
+	}
+	// Verifies the token the way an integrator would, only knowing the key set
+	verifyWithJwks := func(t *testing.T, jwks jwt_provider.JSONWebKeySet, signedToken string) {
+		_, err := jwt.Parse(signedToken, func(token *jwt.Token) (interface{}, error) {
+			for _, jwk := range jwks.Keys {
+				if jwk.Kid != token.Header["kid"] {
+					continue
+				}
+				x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+				require.NoError(t, err)
+				y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+				require.NoError(t, err)
+				return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+			}
+			return nil, jwt.ErrTokenUnverifiable
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		require.NoError(t, err)
+	}
+	generateToken := func(t *testing.T, provider jwt_provider.Provider) string {
+		claims, err := provider.NewClaims(server_testing.WalletAddress, server.ProofTokenAudience(server_testing.CompanyId), time.Minute)
+		require.NoError(t, err)
+		signedToken, err := provider.GenerateSignedToken(claims)
+		require.NoError(t, err)
+		return signedToken
+	}
+
+	t.Run("Success", newTest(func(t *testing.T, s server.Server, provider jwt_provider.Provider) {
+		jwks := getJwks(t, s)
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, oldKey.Id, jwks.Keys[0].Kid)
+		assert.Equal(t, "EC", jwks.Keys[0].Kty)
+		assert.Equal(t, "P-256", jwks.Keys[0].Crv)
+
+		verifyWithJwks(t, jwks, generateToken(t, provider))
+	}))
+
+	oldProvider, err := jwt_provider.NewProvider([]jwt_provider.Key{oldKey}, "http://localhost:3000")
+	require.NoError(t, err)
+	oldToken := generateToken(t, oldProvider)
+	newKey, deletedKeys, err := jwt_provider.RotateKeys(keysDir, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, deletedKeys)
+
+	t.Run("Rotated", newTest(func(t *testing.T, s server.Server, provider jwt_provider.Provider) {
+		jwks := getJwks(t, s)
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, oldKey.Id, jwks.Keys[0].Kid)
+		assert.Equal(t, newKey.Id, jwks.Keys[1].Kid)
+
+		// New tokens are signed by the new key, tokens signed by the old key are still valid
+		newToken := generateToken(t, provider)
+		token, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+		require.NoError(t, err)
+		assert.Equal(t, newKey.Id, token.Header["kid"])
+		verifyWithJwks(t, jwks, newToken)
+
+		_, err = provider.GetClaims(oldToken, server.ProofTokenAudience(server_testing.CompanyId))
+		assert.NoError(t, err)
+	}))
+
+	t.Run("RetentionElapsed", func(t *testing.T) {
+		latestKey, deletedKeys, err := jwt_provider.RotateKeys(keysDir, 0)
+		require.NoError(t, err)
+		require.Len(t, deletedKeys, 2)
+		assert.Equal(t, oldKey.Id, deletedKeys[0].Id)
+		assert.Equal(t, newKey.Id, deletedKeys[1].Id)
+
+		keys, err := jwt_provider.LoadKeys(keysDir)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, latestKey.Id, keys[0].Id)
+
+		provider, err := jwt_provider.NewProvider(keys, "http://localhost:3000")
+		require.NoError(t, err)
+		_, err = provider.GetClaims(oldToken, server.ProofTokenAudience(server_testing.CompanyId))
+		assert.Error(t, err)
+	})
+}
+
+func TestWellKnownController_JwksReload(t *testing.T) {
+	keysDir := t.TempDir()
+	_, _, err := jwt_provider.RotateKeys(keysDir, time.Hour)
+	require.NoError(t, err)
+	provider, err := jwt_provider.LoadProvider(keysDir, "http://localhost:3000")
+	require.NoError(t, err)
+
+	generateToken := func(t *testing.T) string {
+		// Another server which loaded the keys after the rotation
+		rotatedProvider, err := jwt_provider.LoadProvider(keysDir, "http://localhost:3000")
+		require.NoError(t, err)
+		claims, err := rotatedProvider.NewClaims(server_testing.WalletAddress, server.ProofTokenAudience(server_testing.CompanyId), time.Minute)
+		require.NoError(t, err)
+		signedToken, err := rotatedProvider.GenerateSignedToken(claims)
+		require.NoError(t, err)
+		return signedToken
+	}
+
+	// Unknown kids reload the keys
+	newKey, _, err := jwt_provider.RotateKeys(keysDir, time.Hour)
+	require.NoError(t, err)
+	_, err = provider.GetClaims(generateToken(t), server.ProofTokenAudience(server_testing.CompanyId))
+	require.NoError(t, err)
+	assert.Equal(t, newKey.Id, provider.SigningKey().Id)
+
+	// At most once per interval
+	_, _, err = jwt_provider.RotateKeys(keysDir, time.Hour)
+	require.NoError(t, err)
+	_, err = provider.GetClaims(generateToken(t), server.ProofTokenAudience(server_testing.CompanyId))
+	assert.Error(t, err)
+}
+
+func TestWellKnownController_JwksLegacyKey(t *testing.T) {
+	keysDir := t.TempDir()
+	legacyKey, err := jwt_provider.GenerateKey()
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(legacyKey.PrivKey)
+	require.NoError(t, err)
+	legacyFile := filepath.Join(t.TempDir(), "ecdsa")
+	err = os.WriteFile(legacyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	require.NoError(t, err)
+
+	importedKey, ok, err := jwt_provider.ImportLegacyKey(keysDir, legacyFile)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, importedKey.PrivKey.Equal(legacyKey.PrivKey))
+
+	// The key is only imported once
+	key, ok, err := jwt_provider.ImportLegacyKey(keysDir, legacyFile)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, importedKey.Id, key.Id)
+
+	provider, err := jwt_provider.LoadProvider(keysDir, "http://localhost:3000")
+	require.NoError(t, err)
+	require.Len(t, provider.Keys(), 1)
+	assert.Equal(t, importedKey.Id, provider.SigningKey().Id)
+
+	// Tokens signed before the import have no kid
+	claims, err := provider.NewClaims(server_testing.WalletAddress, server.ProofTokenAudience(server_testing.CompanyId), time.Minute)
+	require.NoError(t, err)
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(legacyKey.PrivKey)
+	require.NoError(t, err)
+
+	_, err = provider.GetClaims(legacyToken, server.ProofTokenAudience(server_testing.CompanyId))
+	assert.Error(t, err)
+
+	provider.LegacyKeyId = importedKey.Id
+	provider.LegacyKeyExpiry = time.Now().Add(time.Hour)
+	_, err = provider.GetClaims(legacyToken, server.ProofTokenAudience(server_testing.CompanyId))
+	assert.NoError(t, err)
+
+	provider.LegacyKeyExpiry = time.Now().Add(-time.Second)
+	_, err = provider.GetClaims(legacyToken, server.ProofTokenAudience(server_testing.CompanyId))
+	assert.Error(t, err)
+
+	// The key is not imported again once a rotation deleted it
+	_, _, err = jwt_provider.RotateKeys(keysDir, 0)
+	require.NoError(t, err)
+	_, ok, err = jwt_provider.ImportLegacyKey(keysDir, legacyFile)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWellKnownController_OpenIdConfiguration(t *testing.T) {
+	s := server.NewServer(server_testing.NewTestInjector(t), server_testing.TestConfig)
+
+	res := echo_ext.SendTestRequest(t, s.Echo, http.MethodGet, "/.well-known/openid-configuration", nil, nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	body := echo_ext.ReadBody[server.WellKnownController_OpenIdConfigurationResponse](t, res.Body)
+	assert.Equal(t, s.Config.IssuerUrl, body.Issuer)
+	assert.Equal(t, s.Config.IssuerUrl+server.OidcAuthorizePath, body.AuthorizationEndpoint)
+	assert.Equal(t, s.Config.IssuerUrl+server.OidcTokenPath, body.TokenEndpoint)
+	assert.Equal(t, s.Config.IssuerUrl+server.OidcUserinfoPath, body.UserinfoEndpoint)
+	assert.Equal(t, s.Config.IssuerUrl+"/.well-known/jwks.json", body.JwksUri)
+	assert.Equal(t, []string{"S256"}, body.CodeChallengeMethodsSupported)
+	assert.Equal(t, []string{"ES256"}, body.IdTokenSigningAlgValuesSupported)
+}
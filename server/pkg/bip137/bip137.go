@@ -0,0 +1,92 @@
+package bip137
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// https://github.com/bitcoin/bips/blob/master/bip-0137.mediawiki
+
+const SignatureLength = 65
+
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// Header byte ranges, the offset inside each range is the recovery id
+const (
+	headerP2pkhUncompressed = 27
+	headerP2pkhCompressed   = 31
+	headerP2shP2wpkh        = 35
+	headerP2wpkh            = 39
+	headerMax               = 42
+)
+
+var ErrUnsupportedAddress = errors.New("unsupported address type")
+
+func MessageHash(message []byte) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, messageMagic)
+	_ = wire.WriteVarBytes(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// Sign creates a compact signature whose header byte matches the address type
+func Sign(privateKey *btcec.PrivateKey, address btcutil.Address, message []byte) ([]byte, error) {
+	switch address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return ecdsa.SignCompact(privateKey, MessageHash(message), true)
+	case *btcutil.AddressWitnessPubKeyHash:
+		signature, err := ecdsa.SignCompact(privateKey, MessageHash(message), true)
+		if err != nil {
+			return nil, err
+		}
+		signature[0] += headerP2wpkh - headerP2pkhCompressed
+		return signature, nil
+	default:
+		return nil, ErrUnsupportedAddress
+	}
+}
+
+// Verify checks the signature was made by the key behind a P2PKH or P2WPKH address.
+// Like most wallets, the header byte is only used to know if the public key is compressed.
+func Verify(address btcutil.Address, message []byte, signature []byte) bool {
+	if len(signature) != SignatureLength {
+		return false
+	}
+	header := signature[0]
+	if header < headerP2pkhUncompressed || header > headerMax {
+		return false
+	}
+	recoveryId := (header - headerP2pkhUncompressed) % 4
+	compressed := header >= headerP2pkhCompressed
+
+	// Public key recovery expects a P2PKH header
+	compactSignature := bytes.Clone(signature)
+	if compressed {
+		compactSignature[0] = headerP2pkhCompressed + recoveryId
+	} else {
+		compactSignature[0] = headerP2pkhUncompressed + recoveryId
+	}
+	publicKey, _, err := ecdsa.RecoverCompact(compactSignature, MessageHash(message))
+	if err != nil {
+		return false
+	}
+
+	switch address := address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		serializedKey := publicKey.SerializeUncompressed()
+		if compressed {
+			serializedKey = publicKey.SerializeCompressed()
+		}
+		return bytes.Equal(btcutil.Hash160(serializedKey), address.Hash160()[:])
+	case *btcutil.AddressWitnessPubKeyHash:
+		return compressed && bytes.Equal(btcutil.Hash160(publicKey.SerializeCompressed()), address.WitnessProgram())
+	default:
+		return false
+	}
+}
@@ -0,0 +1,116 @@
+package bip137_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"gatekeeper/pkg/bip137"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAddress(t *testing.T, address string) btcutil.Address {
+	decoded, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	return decoded
+}
+
+func decodeSignature(t *testing.T, signature string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	require.NoError(t, err)
+	return decoded
+}
+
+func newKey(t *testing.T) (*btcec.PrivateKey, *btcutil.AddressPubKeyHash, *btcutil.AddressWitnessPubKeyHash) {
+	privateKey, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{0x01}, 32))
+	pubKeyHash := btcutil.Hash160(privateKey.PubKey().SerializeCompressed())
+	p2pkh, err := btcutil.NewAddressPubKeyHash(pubKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	return privateKey, p2pkh, p2wpkh
+}
+
+func TestVerify(t *testing.T) {
+	// Signature made with the uncompressed key of the address
+	t.Run("P2pkhUncompressed", func(t *testing.T) {
+		address := decodeAddress(t, "1F3sAm6ZtwLAUnj7d38pGFxtP3RVEvtsbV")
+		signature := decodeSignature(t, "H9L5yLFjti0QTHhPyFrZCT1V/MMnBtXKmoiKDZ78NDBjERki6ZTQZdSMCtkgoNmp17By9ItJr8o7ChX0XxY91nk=")
+		assert.True(t, bip137.Verify(address, []byte("This is an example of a signed message."), signature))
+		assert.False(t, bip137.Verify(address, []byte("This is an example of a signed message!"), signature))
+	})
+
+	t.Run("P2pkhCompressed", func(t *testing.T) {
+		privateKey, p2pkh, _ := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, signature[0], byte(31))
+		assert.LessOrEqual(t, signature[0], byte(34))
+		assert.True(t, bip137.Verify(p2pkh, []byte("message"), signature))
+		assert.False(t, bip137.Verify(p2pkh, []byte("another message"), signature))
+	})
+
+	t.Run("P2wpkh", func(t *testing.T) {
+		privateKey, _, p2wpkh := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2wpkh, []byte("message"))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, signature[0], byte(39))
+		assert.LessOrEqual(t, signature[0], byte(42))
+		assert.True(t, bip137.Verify(p2wpkh, []byte("message"), signature))
+		assert.False(t, bip137.Verify(p2wpkh, []byte("another message"), signature))
+	})
+
+	// Wallets like Electrum sign segwit addresses with a P2PKH header
+	t.Run("P2wpkhWithP2pkhHeader", func(t *testing.T) {
+		privateKey, p2pkh, p2wpkh := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		assert.True(t, bip137.Verify(p2wpkh, []byte("message"), signature))
+	})
+
+	t.Run("P2wpkhWithUncompressedHeader", func(t *testing.T) {
+		privateKey, p2pkh, p2wpkh := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		signature[0] -= 4
+		assert.False(t, bip137.Verify(p2wpkh, []byte("message"), signature))
+	})
+
+	t.Run("AnotherAddress", func(t *testing.T) {
+		privateKey, p2pkh, _ := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		address := decodeAddress(t, "1F3sAm6ZtwLAUnj7d38pGFxtP3RVEvtsbV")
+		assert.False(t, bip137.Verify(address, []byte("message"), signature))
+	})
+
+	t.Run("HeaderIsOutOfRange", func(t *testing.T) {
+		privateKey, p2pkh, _ := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		signature[0] = 43
+		assert.False(t, bip137.Verify(p2pkh, []byte("message"), signature))
+		signature[0] = 26
+		assert.False(t, bip137.Verify(p2pkh, []byte("message"), signature))
+	})
+
+	t.Run("SignatureLengthIsInvalid", func(t *testing.T) {
+		privateKey, p2pkh, _ := newKey(t)
+		signature, err := bip137.Sign(privateKey, p2pkh, []byte("message"))
+		require.NoError(t, err)
+		assert.False(t, bip137.Verify(p2pkh, []byte("message"), signature[:bip137.SignatureLength-1]))
+	})
+}
+
+func TestSign(t *testing.T) {
+	t.Run("UnsupportedAddress", func(t *testing.T) {
+		privateKey, _, _ := newKey(t)
+		address := decodeAddress(t, "bc1p5d7rjq7g6rdk2yhzks9smlaqtedr4dekq08ge8ztwac72sfr9rusxg3297")
+		_, err := bip137.Sign(privateKey, address, []byte("message"))
+		assert.ErrorIs(t, err, bip137.ErrUnsupportedAddress)
+	})
+}
@@ -0,0 +1,115 @@
+package bip322
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// https://github.com/bitcoin/bips/blob/master/bip-0322.mediawiki
+
+var messageTag = []byte("BIP0322-signed-message")
+
+func MessageHash(message []byte) []byte {
+	return chainhash.TaggedHash(messageTag, message)[:]
+}
+
+// ToSpendTx builds the virtual transaction that commits to the message and the address script
+func ToSpendTx(pkScript []byte, message []byte) (*wire.MsgTx, error) {
+	scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(MessageHash(message)).Script()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build to_spend script sig: %w", err)
+	}
+
+	tx := wire.NewMsgTx(0)
+	txIn := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), scriptSig, nil)
+	txIn.Sequence = 0
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+	return tx, nil
+}
+
+// ToSignTx builds the virtual transaction that spends to_spend, the signature is its witness
+func ToSignTx(toSpend *wire.MsgTx) (*wire.MsgTx, error) {
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).Script()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build to_sign output script: %w", err)
+	}
+
+	toSpendHash := toSpend.TxHash()
+	tx := wire.NewMsgTx(0)
+	txIn := wire.NewTxIn(wire.NewOutPoint(&toSpendHash, 0), nil, nil)
+	txIn.Sequence = 0
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+	return tx, nil
+}
+
+// NewSigHashes returns the sighash midstate required to sign or verify to_sign
+func NewSigHashes(toSign *wire.MsgTx, pkScript []byte) *txscript.TxSigHashes {
+	return txscript.NewTxSigHashes(toSign, txscript.NewCannedPrevOutputFetcher(pkScript, 0))
+}
+
+func EncodeSimpleSignature(witness wire.TxWitness) ([]byte, error) {
+	var buf bytes.Buffer
+	err := wire.WriteVarInt(&buf, 0, uint64(len(witness)))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range witness {
+		err = wire.WriteVarBytes(&buf, 0, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeSimpleSignature(signature []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(signature)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > txscript.MaxStackSize {
+		return nil, fmt.Errorf("too many witness items: %d", count)
+	}
+	witness := make(wire.TxWitness, 0, count)
+	for i := uint64(0); i < count; i++ {
+		item, err := wire.ReadVarBytes(r, 0, txscript.MaxScriptSize, "witness item")
+		if err != nil {
+			return nil, err
+		}
+		witness = append(witness, item)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("unexpected trailing bytes")
+	}
+	return witness, nil
+}
+
+// VerifySimple runs the address script against the witness of the simple signature
+func VerifySimple(pkScript []byte, message []byte, witness wire.TxWitness) bool {
+	toSpend, err := ToSpendTx(pkScript, message)
+	if err != nil {
+		return false
+	}
+	toSign, err := ToSignTx(toSpend)
+	if err != nil {
+		return false
+	}
+	toSign.TxIn[0].Witness = witness
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	engine, err := txscript.NewEngine(
+		pkScript, toSign, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(toSign, prevOutFetcher), 0, prevOutFetcher,
+	)
+	if err != nil {
+		return false
+	}
+	return engine.Execute() == nil
+}
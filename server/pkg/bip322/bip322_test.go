@@ -0,0 +1,133 @@
+package bip322_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"gatekeeper/pkg/bip322"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors of https://github.com/bitcoin/bips/blob/master/bip-0322.mediawiki#test-vectors
+const address = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+
+var vectors = []struct {
+	Name            string
+	Message         string
+	MessageHash     string
+	ToSpendTxId     string
+	ToSignTxId      string
+	SimpleSignature string
+}{
+	{
+		Name:            "Empty",
+		Message:         "",
+		MessageHash:     "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1",
+		ToSpendTxId:     "c5680aa69bb8d860bf82d4e9cd3504b55dde018de765a91bb566283c545a99a7",
+		ToSignTxId:      "1e9654e951a5ba44c8604c4de6c67fd78a27e81dcadcfe1edf638ba3aaebaed6",
+		SimpleSignature: "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+	},
+	{
+		Name:            "HelloWorld",
+		Message:         "Hello World",
+		MessageHash:     "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a",
+		ToSpendTxId:     "b79d196740ad5217771c1098fc4a4b51e0535c32236c71f1ea4d61a2d603352b",
+		ToSignTxId:      "88737ae86f2077145f93cc4b153ae9a1cb8d56afa511988c149c5c8c9d93bddf",
+		SimpleSignature: "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+	},
+}
+
+func addressPkScript(t *testing.T) []byte {
+	witnessAddress, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(witnessAddress)
+	require.NoError(t, err)
+	return pkScript
+}
+
+func TestMessageHash(t *testing.T) {
+	for _, vector := range vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			assert.Equal(t, vector.MessageHash, hex.EncodeToString(bip322.MessageHash([]byte(vector.Message))))
+		})
+	}
+}
+
+func TestVirtualTransactions(t *testing.T) {
+	pkScript := addressPkScript(t)
+
+	for _, vector := range vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			toSpend, err := bip322.ToSpendTx(pkScript, []byte(vector.Message))
+			require.NoError(t, err)
+			assert.Equal(t, vector.ToSpendTxId, toSpend.TxHash().String())
+
+			toSign, err := bip322.ToSignTx(toSpend)
+			require.NoError(t, err)
+			assert.Equal(t, vector.ToSignTxId, toSign.TxHash().String())
+		})
+	}
+}
+
+func TestVerifySimple(t *testing.T) {
+	pkScript := addressPkScript(t)
+
+	for _, vector := range vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			signature, err := base64.StdEncoding.DecodeString(vector.SimpleSignature)
+			require.NoError(t, err)
+			witness, err := bip322.DecodeSimpleSignature(signature)
+			require.NoError(t, err)
+			assert.True(t, bip322.VerifySimple(pkScript, []byte(vector.Message), witness))
+
+			encoded, err := bip322.EncodeSimpleSignature(witness)
+			require.NoError(t, err)
+			assert.Equal(t, signature, encoded)
+		})
+	}
+
+	t.Run("SignatureOfAnotherMessage", func(t *testing.T) {
+		signature, err := base64.StdEncoding.DecodeString(vectors[0].SimpleSignature)
+		require.NoError(t, err)
+		witness, err := bip322.DecodeSimpleSignature(signature)
+		require.NoError(t, err)
+		assert.False(t, bip322.VerifySimple(pkScript, []byte(vectors[1].Message), witness))
+	})
+
+	t.Run("SignatureOfAnotherAddress", func(t *testing.T) {
+		signature, err := base64.StdEncoding.DecodeString(vectors[0].SimpleSignature)
+		require.NoError(t, err)
+		witness, err := bip322.DecodeSimpleSignature(signature)
+		require.NoError(t, err)
+		otherPkScript := append([]byte{}, pkScript...)
+		otherPkScript[len(otherPkScript)-1] ^= 0xff
+		assert.False(t, bip322.VerifySimple(otherPkScript, []byte(vectors[0].Message), witness))
+	})
+}
+
+func TestDecodeSimpleSignature(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		_, err := bip322.DecodeSimpleSignature(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("TruncatedItem", func(t *testing.T) {
+		_, err := bip322.DecodeSimpleSignature([]byte{0x01, 0x02, 0xaa})
+		assert.Error(t, err)
+	})
+
+	t.Run("TrailingBytes", func(t *testing.T) {
+		_, err := bip322.DecodeSimpleSignature([]byte{0x01, 0x01, 0xaa, 0xbb})
+		assert.Error(t, err)
+	})
+
+	t.Run("TooManyItems", func(t *testing.T) {
+		_, err := bip322.DecodeSimpleSignature([]byte{0xfd, 0xe9, 0x03})
+		assert.Error(t, err)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 // https://eips.ethereum.org/EIPS/eip-191
@@ -12,3 +13,12 @@ func PersonalSign(data []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
 	hash := crypto.Keccak256Hash([]byte("\x19Ethereum Signed Message:\n"+strconv.Itoa(len(data))), data).Bytes()
 	return crypto.Sign(hash, privateKey)
 }
+
+// https://eips.ethereum.org/EIPS/eip-712
+func SignTypedData(typedData apitypes.TypedData, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(hash, privateKey)
+}
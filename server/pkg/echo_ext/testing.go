@@ -38,8 +38,13 @@ func ReadBody[T any](t *testing.T, bodyBuffer *bytes.Buffer) T {
 }
 
 func RunMiddleware(t *testing.T, handler echo.MiddlewareFunc, setupReq func(*http.Request)) error {
+	return RunMiddlewareWithContext(t, handler, func(c echo.Context) { setupReq(c.Request()) })
+}
+
+// RunMiddlewareWithContext lets the setup set context values normally set by previous middlewares
+func RunMiddlewareWithContext(t *testing.T, handler echo.MiddlewareFunc, setupCtx func(echo.Context)) error {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	setupReq(req)
 	c := echo.New().NewContext(req, httptest.NewRecorder())
+	setupCtx(c)
 	return handler(func(c echo.Context) error { return nil })(c)
 }
@@ -0,0 +1,137 @@
+package eip1271
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// https://eips.ethereum.org/EIPS/eip-1271
+var MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+// https://eips.ethereum.org/EIPS/eip-6492
+var Erc6492Suffix = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+
+// Deployless validator used for ERC-6492 signatures. Its constructor receives
+// signer | factory | len(deployData) | len(checkData) | deployData | checkData (appended to the code),
+// deploys the signer through the factory when it has no code yet and returns what signer.isValidSignature returned.
+//
+//	00: PUSH1 0x50 CODESIZE SUB PUSH1 0x50 PUSH1 0x00 CODECOPY   ; copy arguments to memory
+//	09: PUSH1 0x00 MLOAD EXTCODESIZE PUSH1 0x28 JUMPI            ; skip deploy if signer has code
+//	10: PUSH1 0x40 MLOAD ISZERO PUSH1 0x28 JUMPI                 ; skip deploy if there is no deploy data
+//	17: PUSH1 0x00 PUSH1 0x00 PUSH1 0x40 MLOAD PUSH1 0x80 PUSH1 0x00 PUSH1 0x20 MLOAD GAS CALL POP
+//	28: JUMPDEST
+//	29: PUSH1 0x20 PUSH1 0x00 PUSH1 0x60 MLOAD PUSH1 0x40 MLOAD PUSH1 0x80 ADD PUSH1 0x00 MLOAD GAS STATICCALL
+//	3b: ISZERO PUSH1 0x4b JUMPI                                  ; fail if call reverted
+//	3f: PUSH1 0x20 RETURNDATASIZE LT PUSH1 0x4b JUMPI            ; fail if less than 32 bytes were returned
+//	46: PUSH1 0x20 PUSH1 0x00 RETURN
+//	4b: JUMPDEST PUSH1 0x00 DUP1 RETURN                          ; return nothing on failure
+var deploylessValidatorCode = common.FromHex(
+	"0x6050380360506000396000513b6028576040511560285760006000604051608060006020515af150" +
+		"5b602060006060516040516080016000515afa15604b5760203d10604b5760206000f35b600080f3",
+)
+
+var walletAbi = mustParseAbi(`[{
+	"name": "isValidSignature",
+	"type": "function",
+	"stateMutability": "view",
+	"inputs": [{"name": "hash", "type": "bytes32"}, {"name": "signature", "type": "bytes"}],
+	"outputs": [{"name": "magicValue", "type": "bytes4"}]
+}]`)
+
+var erc6492Arguments = abi.Arguments{
+	{Name: "factory", Type: mustNewType("address")},
+	{Name: "factoryCalldata", Type: mustNewType("bytes")},
+	{Name: "signature", Type: mustNewType("bytes")},
+}
+
+// Subset of go-ethereum's ethclient.Client used to talk with wallet contracts
+type Caller interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// IsValidSignature asks the wallet contract if signature is valid for hash.
+// ERC-6492 wrapped signatures of counterfactual wallets are checked by deploying the wallet in a simulated call.
+func IsValidSignature(ctx context.Context, caller Caller, wallet common.Address, hash []byte, signature []byte) (bool, error) {
+	if bytes.HasSuffix(signature, Erc6492Suffix) {
+		return isValidErc6492Signature(ctx, caller, wallet, hash, signature)
+	}
+
+	code, err := caller.CodeAt(ctx, wallet, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get wallet code: %w", err)
+	}
+	if len(code) == 0 {
+		return false, nil
+	}
+
+	data, err := walletAbi.Pack("isValidSignature", common.BytesToHash(hash), signature)
+	if err != nil {
+		return false, fmt.Errorf("failed to pack isValidSignature call: %w", err)
+	}
+	res, err := caller.CallContract(ctx, ethereum.CallMsg{To: &wallet, Data: data}, nil)
+	return checkCallResult(res, err)
+}
+
+func isValidErc6492Signature(ctx context.Context, caller Caller, wallet common.Address, hash []byte, signature []byte) (bool, error) {
+	values, err := erc6492Arguments.Unpack(bytes.TrimSuffix(signature, Erc6492Suffix))
+	if err != nil {
+		return false, nil
+	}
+	factory := values[0].(common.Address)
+	factoryCalldata := values[1].([]byte)
+	innerSignature := values[2].([]byte)
+
+	checkData, err := walletAbi.Pack("isValidSignature", common.BytesToHash(hash), innerSignature)
+	if err != nil {
+		return false, fmt.Errorf("failed to pack isValidSignature call: %w", err)
+	}
+
+	data := bytes.Clone(deploylessValidatorCode)
+	data = append(data, common.LeftPadBytes(wallet.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(factory.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(factoryCalldata))).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(checkData))).Bytes(), 32)...)
+	data = append(data, factoryCalldata...)
+	data = append(data, checkData...)
+
+	res, err := caller.CallContract(ctx, ethereum.CallMsg{Data: data}, nil)
+	return checkCallResult(res, err)
+}
+
+func checkCallResult(res []byte, err error) (bool, error) {
+	if err != nil {
+		// Calls that were executed but reverted or failed are treated as invalid signatures
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to call isValidSignature: %w", err)
+	}
+	return len(res) >= len(MagicValue) && bytes.Equal(res[:len(MagicValue)], MagicValue), nil
+}
+
+func mustParseAbi(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func mustNewType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
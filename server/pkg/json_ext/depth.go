@@ -0,0 +1,22 @@
+package json_ext
+
+// Depth returns the number of nested levels of objects and arrays of a decoded JSON value, scalars have no depth
+func Depth(v any) int {
+	var children []any
+	switch v := v.(type) {
+	case map[string]any:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case []any:
+		children = v
+	default:
+		return 0
+	}
+
+	depth := 0
+	for _, child := range children {
+		depth = max(depth, Depth(child))
+	}
+	return depth + 1
+}
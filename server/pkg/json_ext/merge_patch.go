@@ -0,0 +1,26 @@
+package json_ext
+
+// MergePatch applies a JSON merge patch to the target, both being decoded JSON values (https://www.rfc-editor.org/rfc/rfc7396)
+func MergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	res := make(map[string]any, len(targetObj))
+	for key, value := range targetObj {
+		res[key] = value
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(res, key)
+		} else {
+			res[key] = MergePatch(res[key], value)
+		}
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package jwt_provider
+
+import (
+	"encoding/base64"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of every key, including the ones only kept for verification
+func (p Provider) JWKS() JSONWebKeySet {
+	keys := p.Keys()
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		pubKey := key.PrivKey.PublicKey
+		size := (pubKey.Curve.Params().BitSize + 7) / 8
+		set.Keys = append(set.Keys, JSONWebKey{
+			Kty: "EC",
+			Crv: pubKey.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(pubKey.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pubKey.Y.FillBytes(make([]byte, size))),
+			Kid: key.Id,
+			Use: "sig",
+			Alg: "ES256",
+		})
+	}
+	return set
+}
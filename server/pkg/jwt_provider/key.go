@@ -0,0 +1,171 @@
+package jwt_provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const keyFileExt = ".pem"
+
+type Key struct {
+	// UUIDv7, so that ids are ordered by creation time
+	Id      string
+	PrivKey *ecdsa.PrivateKey
+}
+
+func GenerateKey() (Key, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	return Key{Id: id.String(), PrivKey: privKey}, nil
+}
+
+// CreatedAt returns the creation time encoded in the key id
+func (k Key) CreatedAt() (time.Time, error) {
+	id, err := uuid.Parse(k.Id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse key id: %w", err)
+	}
+	if id.Version() != 7 {
+		return time.Time{}, fmt.Errorf("key id is not a uuid v7 (kid: %s)", k.Id)
+	}
+	sec, nsec := id.Time().UnixTime()
+	return time.Unix(sec, nsec), nil
+}
+
+// LoadKeys reads the PEM encoded private keys of dir, named after their id, ordered from oldest to newest
+func LoadKeys(dir string) ([]Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	var keys []Key
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExt {
+			continue
+		}
+		keyBytes, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file %s: %w", entry.Name(), err)
+		}
+		privKey, err := jwt.ParseECPrivateKeyFromPEM(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key file %s: %w", entry.Name(), err)
+		}
+		keys = append(keys, Key{Id: strings.TrimSuffix(entry.Name(), keyFileExt), PrivKey: privKey})
+	}
+
+	slices.SortFunc(keys, func(a, b Key) int { return strings.Compare(a.Id, b.Id) })
+	return keys, nil
+}
+
+// ImportLegacyKey adds the PEM encoded private key of legacyFile to dir as its initial key, so that tokens signed before key sets stay valid.
+// It returns the key of dir matching legacyFile, ok is false if legacyFile does not exist or its key was deleted by a rotation.
+func ImportLegacyKey(dir string, legacyFile string) (key Key, ok bool, err error) {
+	keyBytes, err := os.ReadFile(legacyFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Key{}, false, nil
+		}
+		return Key{}, false, fmt.Errorf("failed to read legacy key file: %w", err)
+	}
+	privKey, err := jwt.ParseECPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		return Key{}, false, fmt.Errorf("failed to parse legacy key file: %w", err)
+	}
+
+	keys, err := LoadKeys(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Key{}, false, err
+	}
+	for _, key := range keys {
+		if key.PrivKey.Equal(privKey) {
+			return key, true, nil
+		}
+	}
+	if len(keys) > 0 {
+		return Key{}, false, nil
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return Key{}, false, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	key = Key{Id: id.String(), PrivKey: privKey}
+	err = WriteKey(dir, key)
+	if err != nil {
+		return Key{}, false, fmt.Errorf("failed to write legacy key: %w", err)
+	}
+	return key, true, nil
+}
+
+func WriteKey(dir string, key Key) error {
+	der, err := x509.MarshalECPrivateKey(key.PrivKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, key.Id+keyFileExt), keyBytes, 0o600)
+}
+
+// RotateKeys adds a new signing key to dir and deletes the keys that were replaced more than retention ago.
+// Retention must be longer than the lifetime of the tokens, so that tokens signed by a replaced key stay verifiable.
+func RotateKeys(dir string, retention time.Duration) (Key, []Key, error) {
+	keys, err := LoadKeys(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Key{}, nil, err
+	}
+
+	newKey, err := GenerateKey()
+	if err != nil {
+		return Key{}, nil, err
+	}
+	err = WriteKey(dir, newKey)
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+	keys = append(keys, newKey)
+
+	// A key is replaced when its successor is created
+	var deletedKeys []Key
+	for idx, key := range keys[:len(keys)-1] {
+		replacedAt, err := keys[idx+1].CreatedAt()
+		if err != nil {
+			return newKey, deletedKeys, err
+		}
+		if time.Since(replacedAt) < retention {
+			break
+		}
+		err = os.Remove(filepath.Join(dir, key.Id+keyFileExt))
+		if err != nil {
+			return newKey, deletedKeys, fmt.Errorf("failed to delete key %s: %w", key.Id, err)
+		}
+		deletedKeys = append(deletedKeys, key)
+	}
+
+	return newKey, deletedKeys, nil
+}
@@ -0,0 +1,209 @@
+package siwe
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://eips.ethereum.org/EIPS/eip-4361
+
+const Version = "1"
+
+const (
+	headerSuffix        = " wants you to sign in with your Ethereum account:"
+	uriTag              = "URI: "
+	versionTag          = "Version: "
+	chainIdTag          = "Chain ID: "
+	nonceTag            = "Nonce: "
+	issuedAtTag         = "Issued At: "
+	expirationTimeTag   = "Expiration Time: "
+	notBeforeTag        = "Not Before: "
+	requestIdTag        = "Request ID: "
+	resourcesTag        = "Resources:"
+	resourceEntryPrefix = "- "
+)
+
+var ErrInvalidMessage = errors.New("invalid siwe message")
+
+type Message struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainId        uint64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestId      string
+	Resources      []string
+}
+
+func (m Message) String() string {
+	var b strings.Builder
+
+	b.WriteString(m.Domain + headerSuffix + "\n")
+	b.WriteString(m.Address + "\n")
+	b.WriteString("\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString(uriTag + m.URI + "\n")
+	b.WriteString(versionTag + m.Version + "\n")
+	b.WriteString(chainIdTag + strconv.FormatUint(m.ChainId, 10) + "\n")
+	b.WriteString(nonceTag + m.Nonce + "\n")
+	b.WriteString(issuedAtTag + m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		b.WriteString("\n" + expirationTimeTag + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		b.WriteString("\n" + notBeforeTag + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestId != "" {
+		b.WriteString("\n" + requestIdTag + m.RequestId)
+	}
+	if len(m.Resources) != 0 {
+		b.WriteString("\n" + resourcesTag)
+		for _, resource := range m.Resources {
+			b.WriteString("\n" + resourceEntryPrefix + resource)
+		}
+	}
+
+	return b.String()
+}
+
+func ParseMessage(s string) (Message, error) {
+	var m Message
+	lines := strings.Split(s, "\n")
+	pos := 0
+
+	nextLine := func() (string, bool) {
+		if pos >= len(lines) {
+			return "", false
+		}
+		line := lines[pos]
+		pos++
+		return line, true
+	}
+	invalid := func(reason string) (Message, error) {
+		return Message{}, fmt.Errorf("%w: %s", ErrInvalidMessage, reason)
+	}
+
+	// Header
+	line, _ := nextLine()
+	domain, ok := strings.CutSuffix(line, headerSuffix)
+	if !ok || domain == "" {
+		return invalid("missing header")
+	}
+	m.Domain = domain
+
+	// Address
+	m.Address, ok = nextLine()
+	if !ok || m.Address == "" {
+		return invalid("missing address")
+	}
+	if line, _ = nextLine(); line != "" {
+		return invalid("expected empty line after address")
+	}
+
+	// Statement (optional)
+	line, ok = nextLine()
+	if !ok {
+		return invalid("unexpected end of message")
+	}
+	if line != "" {
+		m.Statement = line
+		if line, _ = nextLine(); line != "" {
+			return invalid("expected empty line after statement")
+		}
+	}
+
+	// Required fields
+	requiredField := func(tag string) (string, error) {
+		line, ok := nextLine()
+		value, found := strings.CutPrefix(line, tag)
+		if !ok || !found || value == "" {
+			return "", fmt.Errorf("%w: missing %q", ErrInvalidMessage, strings.TrimSuffix(tag, ": "))
+		}
+		return value, nil
+	}
+	var err error
+	if m.URI, err = requiredField(uriTag); err != nil {
+		return Message{}, err
+	}
+	if m.Version, err = requiredField(versionTag); err != nil {
+		return Message{}, err
+	}
+	if m.Version != Version {
+		return invalid("unsupported version")
+	}
+	chainId, err := requiredField(chainIdTag)
+	if err != nil {
+		return Message{}, err
+	}
+	m.ChainId, err = strconv.ParseUint(chainId, 10, 64)
+	if err != nil {
+		return invalid("chain id is not a number")
+	}
+	if m.Nonce, err = requiredField(nonceTag); err != nil {
+		return Message{}, err
+	}
+	issuedAt, err := requiredField(issuedAtTag)
+	if err != nil {
+		return Message{}, err
+	}
+	m.IssuedAt, err = time.Parse(time.RFC3339, issuedAt)
+	if err != nil {
+		return invalid("issued at is not a valid timestamp")
+	}
+
+	// Optional fields
+	optionalField := func(tag string) (string, bool) {
+		if pos >= len(lines) {
+			return "", false
+		}
+		value, found := strings.CutPrefix(lines[pos], tag)
+		if found {
+			pos++
+		}
+		return value, found
+	}
+	if value, found := optionalField(expirationTimeTag); found {
+		expirationTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("expiration time is not a valid timestamp")
+		}
+		m.ExpirationTime = &expirationTime
+	}
+	if value, found := optionalField(notBeforeTag); found {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("not before is not a valid timestamp")
+		}
+		m.NotBefore = &notBefore
+	}
+	if value, found := optionalField(requestIdTag); found {
+		m.RequestId = value
+	}
+	if _, found := optionalField(resourcesTag); found {
+		for pos < len(lines) {
+			resource, found := optionalField(resourceEntryPrefix)
+			if !found {
+				break
+			}
+			m.Resources = append(m.Resources, resource)
+		}
+	}
+
+	if pos != len(lines) {
+		return invalid("unexpected trailing content")
+	}
+
+	return m, nil
+}
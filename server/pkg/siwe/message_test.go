@@ -0,0 +1,134 @@
+package siwe_test
+
+import (
+	"gatekeeper/pkg/siwe"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fullMessage = `service.org wants you to sign in with your Ethereum account:
+0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946
+
+I accept the ServiceOrg Terms of Service: https://service.org/tos
+
+URI: https://service.org/login
+Version: 1
+Chain ID: 1
+Nonce: 32891756
+Issued At: 2021-09-30T16:25:24Z
+Expiration Time: 2021-09-30T16:30:24Z
+Not Before: 2021-09-30T16:26:24Z
+Request ID: some-request-id
+Resources:
+- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/
+- https://example.com/my-web2-claim.json`
+
+const minimalMessage = `service.org wants you to sign in with your Ethereum account:
+0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946
+
+
+URI: https://service.org/login
+Version: 1
+Chain ID: 10
+Nonce: 32891756
+Issued At: 2021-09-30T16:25:24Z`
+
+func TestParseMessage(t *testing.T) {
+	t.Run("Full", func(t *testing.T) {
+		msg, err := siwe.ParseMessage(fullMessage)
+		require.NoError(t, err)
+		assert.Equal(t, "service.org", msg.Domain)
+		assert.Equal(t, "0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946", msg.Address)
+		assert.Equal(t, "I accept the ServiceOrg Terms of Service: https://service.org/tos", msg.Statement)
+		assert.Equal(t, "https://service.org/login", msg.URI)
+		assert.Equal(t, siwe.Version, msg.Version)
+		assert.Equal(t, uint64(1), msg.ChainId)
+		assert.Equal(t, "32891756", msg.Nonce)
+		assert.Equal(t, time.Date(2021, 9, 30, 16, 25, 24, 0, time.UTC), msg.IssuedAt.UTC())
+		require.NotNil(t, msg.ExpirationTime)
+		assert.Equal(t, time.Date(2021, 9, 30, 16, 30, 24, 0, time.UTC), msg.ExpirationTime.UTC())
+		require.NotNil(t, msg.NotBefore)
+		assert.Equal(t, time.Date(2021, 9, 30, 16, 26, 24, 0, time.UTC), msg.NotBefore.UTC())
+		assert.Equal(t, "some-request-id", msg.RequestId)
+		assert.Equal(t, []string{
+			"ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/",
+			"https://example.com/my-web2-claim.json",
+		}, msg.Resources)
+		assert.Equal(t, fullMessage, msg.String())
+	})
+
+	t.Run("Minimal", func(t *testing.T) {
+		msg, err := siwe.ParseMessage(minimalMessage)
+		require.NoError(t, err)
+		assert.Empty(t, msg.Statement)
+		assert.Equal(t, uint64(10), msg.ChainId)
+		assert.Nil(t, msg.ExpirationTime)
+		assert.Nil(t, msg.NotBefore)
+		assert.Empty(t, msg.RequestId)
+		assert.Empty(t, msg.Resources)
+		assert.Equal(t, minimalMessage, msg.String())
+	})
+
+	t.Run("TimestampWithOffset", func(t *testing.T) {
+		msg, err := siwe.ParseMessage(strings.Replace(minimalMessage, "2021-09-30T16:25:24Z", "2021-09-30T18:25:24+02:00", 1))
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2021, 9, 30, 16, 25, 24, 0, time.UTC), msg.IssuedAt.UTC())
+		assert.Equal(t, minimalMessage, msg.String())
+	})
+
+	invalid := []struct {
+		Name    string
+		Message string
+	}{
+		{"Empty", ""},
+		{"MissingHeader", strings.Replace(minimalMessage, " wants you to sign in with your Ethereum account:", "", 1)},
+		{"MissingDomain", strings.Replace(minimalMessage, "service.org wants", " wants", 1)},
+		{"MissingAddress", strings.Replace(minimalMessage, "0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n", "", 1)},
+		{"MissingLineAfterStatement", strings.Replace(fullMessage, "tos\n\n", "tos\n", 1)},
+		{"MissingUri", strings.Replace(minimalMessage, "URI: https://service.org/login\n", "", 1)},
+		{"EmptyUri", strings.Replace(minimalMessage, "URI: https://service.org/login", "URI: ", 1)},
+		{"UnsupportedVersion", strings.Replace(minimalMessage, "Version: 1", "Version: 2", 1)},
+		{"ChainIdIsNotANumber", strings.Replace(minimalMessage, "Chain ID: 10", "Chain ID: ten", 1)},
+		{"ChainIdIsNegative", strings.Replace(minimalMessage, "Chain ID: 10", "Chain ID: -1", 1)},
+		{"ChainIdOverflows", strings.Replace(minimalMessage, "Chain ID: 10", "Chain ID: 18446744073709551616", 1)},
+		{"MissingNonce", strings.Replace(minimalMessage, "Nonce: 32891756\n", "", 1)},
+		{"IssuedAtIsInvalid", strings.Replace(minimalMessage, "2021-09-30T16:25:24Z", "2021-09-30 16:25:24", 1)},
+		{"ExpirationTimeIsInvalid", strings.Replace(fullMessage, "2021-09-30T16:30:24Z", "tomorrow", 1)},
+		{"NotBeforeIsInvalid", strings.Replace(fullMessage, "2021-09-30T16:26:24Z", "now", 1)},
+		{"FieldsOutOfOrder", strings.Replace(fullMessage, "Expiration Time: 2021-09-30T16:30:24Z\nNot Before: 2021-09-30T16:26:24Z", "Not Before: 2021-09-30T16:26:24Z\nExpiration Time: 2021-09-30T16:30:24Z", 1)},
+		{"ResourceIsNotAListEntry", fullMessage + "\nhttps://example.com"},
+		{"TrailingNewLine", minimalMessage + "\n"},
+		{"UnknownField", minimalMessage + "\nFoo: bar"},
+	}
+	for _, test := range invalid {
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := siwe.ParseMessage(test.Message)
+			assert.ErrorIs(t, err, siwe.ErrInvalidMessage)
+		})
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		expirationTime := time.Date(2021, 9, 30, 16, 30, 24, 0, time.UTC)
+		msg := siwe.Message{
+			Domain:         "localhost:4000",
+			Address:        "0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946",
+			Statement:      "Authentication request",
+			URI:            "http://localhost:4000",
+			Version:        siwe.Version,
+			ChainId:        1,
+			Nonce:          "abcdef",
+			IssuedAt:       time.Date(2021, 9, 30, 16, 25, 24, 0, time.UTC),
+			ExpirationTime: &expirationTime,
+			Resources:      []string{"https://example.com"},
+		}
+		parsed, err := siwe.ParseMessage(msg.String())
+		require.NoError(t, err)
+		assert.Equal(t, msg, parsed)
+	})
+}
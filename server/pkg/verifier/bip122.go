@@ -0,0 +1,81 @@
+package verifier
+
+import (
+	"context"
+	"encoding/base64"
+	"gatekeeper/pkg/bip137"
+	"gatekeeper/pkg/bip322"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Bip122Verifier verifies Bitcoin message signatures of single key wallets (P2PKH, P2WPKH and P2TR).
+// Legacy BIP-137 signatures are accepted for P2PKH and P2WPKH wallets, BIP-322 simple signatures for segwit wallets.
+type Bip122Verifier struct {
+	NetParams []*chaincfg.Params
+}
+
+// NewBip122Verifier accepts mainnet and testnet addresses
+func NewBip122Verifier() Bip122Verifier {
+	return Bip122Verifier{NetParams: []*chaincfg.Params{&chaincfg.MainNetParams, &chaincfg.TestNet3Params}}
+}
+
+func (v Bip122Verifier) ChainNamespace() ChainNamespace {
+	return ChainNamespace_Bip122
+}
+
+func (v Bip122Verifier) NormalizeAddress(walletAddress string) (string, bool) {
+	address, ok := v.decodeAddress(walletAddress)
+	if !ok {
+		return "", false
+	}
+	return address.EncodeAddress(), true
+}
+
+func (v Bip122Verifier) SignedData(message Message) ([]byte, error) {
+	if message.TypedData != nil {
+		return nil, ErrMessageNotSupported
+	}
+	return []byte(message.Text), nil
+}
+
+func (v Bip122Verifier) VerifySignature(_ context.Context, walletAddress string, message []byte, signatureBase64 string) (bool, error) {
+	address, ok := v.decodeAddress(walletAddress)
+	if !ok {
+		return false, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return false, nil
+	}
+
+	if len(signature) == bip137.SignatureLength && bip137.Verify(address, message, signature) {
+		return true, nil
+	}
+
+	witness, err := bip322.DecodeSimpleSignature(signature)
+	if err != nil {
+		return false, nil
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return false, nil
+	}
+	return bip322.VerifySimple(pkScript, message, witness), nil
+}
+
+func (v Bip122Verifier) decodeAddress(walletAddress string) (btcutil.Address, bool) {
+	for _, params := range v.NetParams {
+		address, err := btcutil.DecodeAddress(walletAddress, params)
+		if err != nil || !address.IsForNet(params) {
+			continue
+		}
+		switch address.(type) {
+		case *btcutil.AddressPubKeyHash, *btcutil.AddressWitnessPubKeyHash, *btcutil.AddressTaproot:
+			return address, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,78 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gatekeeper/pkg/eip1271"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Eip155Verifier verifies signatures of externally owned accounts, and of smart contract wallets when Caller is set
+type Eip155Verifier struct {
+	Caller eip1271.Caller
+}
+
+func NewEip155Verifier(caller eip1271.Caller) Eip155Verifier {
+	return Eip155Verifier{Caller: caller}
+}
+
+func (v Eip155Verifier) ChainNamespace() ChainNamespace {
+	return ChainNamespace_Eip155
+}
+
+func (v Eip155Verifier) NormalizeAddress(walletAddress string) (string, bool) {
+	if !common.IsHexAddress(walletAddress) {
+		return "", false
+	}
+	return common.HexToAddress(walletAddress).Hex(), true
+}
+
+// SignedData returns the EIP-712 hash of typed data, or the EIP-191 hash of text
+func (v Eip155Verifier) SignedData(message Message) ([]byte, error) {
+	if message.TypedData != nil {
+		// https://eips.ethereum.org/EIPS/eip-712
+		hash, _, err := apitypes.TypedDataAndHash(*message.TypedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash typed data: %w", err)
+		}
+		return hash, nil
+	}
+	// https://eips.ethereum.org/EIPS/eip-191
+	return crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message.Text)) + message.Text)), nil
+}
+
+func (v Eip155Verifier) VerifySignature(ctx context.Context, walletAddress string, hash []byte, signatureHex string) (bool, error) {
+	signature, err := hexutil.Decode(signatureHex)
+	if err != nil {
+		return false, nil
+	}
+
+	// Externally owned account
+	if len(signature) == crypto.SignatureLength {
+		signature := bytes.Clone(signature)
+		// https://eips.ethereum.org/EIPS/eip-155
+		if signature[64] == 27 || signature[64] == 28 {
+			signature[64] -= 27
+		}
+		publicKey, err := crypto.SigToPub(hash, signature)
+		if err == nil && crypto.PubkeyToAddress(*publicKey) == common.HexToAddress(walletAddress) {
+			return true, nil
+		}
+	}
+
+	// Smart contract wallet
+	if v.Caller == nil {
+		return false, nil
+	}
+	valid, err := eip1271.IsValidSignature(ctx, v.Caller, common.HexToAddress(walletAddress), hash, signature)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify smart contract wallet signature: %w", err)
+	}
+	return valid, nil
+}
@@ -0,0 +1,46 @@
+package verifier
+
+import (
+	"context"
+	"crypto/ed25519"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SolanaVerifier verifies ed25519 signatures of text messages
+//
+// https://docs.phantom.app/solana/signing-a-message
+type SolanaVerifier struct{}
+
+func NewSolanaVerifier() SolanaVerifier {
+	return SolanaVerifier{}
+}
+
+func (v SolanaVerifier) ChainNamespace() ChainNamespace {
+	return ChainNamespace_Solana
+}
+
+func (v SolanaVerifier) NormalizeAddress(walletAddress string) (string, bool) {
+	return walletAddress, len(base58.Decode(walletAddress)) == ed25519.PublicKeySize
+}
+
+func (v SolanaVerifier) SignedData(message Message) ([]byte, error) {
+	if message.TypedData != nil {
+		return nil, ErrMessageNotSupported
+	}
+	return []byte(message.Text), nil
+}
+
+func (v SolanaVerifier) VerifySignature(_ context.Context, walletAddress string, message []byte, signatureStr string) (bool, error) {
+	// Signatures are base58 encoded by convention, but hex encoding is also accepted
+	signature := base58.Decode(signatureStr)
+	if strings.HasPrefix(signatureStr, "0x") {
+		signature, _ = hexutil.Decode(signatureStr)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return false, nil
+	}
+	return ed25519.Verify(base58.Decode(walletAddress), message, signature), nil
+}
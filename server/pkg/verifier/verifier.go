@@ -0,0 +1,91 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// https://github.com/ChainAgnostic/namespaces
+type ChainNamespace string
+
+const (
+	ChainNamespace_Eip155 ChainNamespace = "eip155"
+	ChainNamespace_Solana ChainNamespace = "solana"
+	ChainNamespace_Bip122 ChainNamespace = "bip122"
+)
+
+var ErrMessageNotSupported = errors.New("message is not supported by the chain namespace")
+
+// Message is what the wallet was asked to sign
+type Message struct {
+	// Text signed with personal_sign like methods
+	Text string
+	// Typed data signed with eth_signTypedData_v4, takes precedence over Text
+	TypedData *apitypes.TypedData
+}
+
+// Verifier implements wallet signature verification of a chain namespace
+type Verifier interface {
+	ChainNamespace() ChainNamespace
+	// NormalizeAddress validates the wallet address and returns it in its canonical form
+	NormalizeAddress(walletAddress string) (string, bool)
+	// SignedData builds the data covered by the wallet signature of message
+	SignedData(message Message) ([]byte, error)
+	// VerifySignature checks the encoded signature of signedData was made by the wallet.
+	// Malformed signatures are reported as invalid, errors are only returned when verification could not run.
+	VerifySignature(ctx context.Context, walletAddress string, signedData []byte, signature string) (bool, error)
+}
+
+// VerifyMessage builds the signed data of message and verifies its signature
+func VerifyMessage(ctx context.Context, v Verifier, walletAddress string, message Message, signature string) (bool, error) {
+	signedData, err := v.SignedData(message)
+	if err != nil {
+		return false, err
+	}
+	return v.VerifySignature(ctx, walletAddress, signedData, signature)
+}
+
+// Registry holds the verifiers of the supported chain namespaces
+type Registry struct {
+	verifiers []Verifier
+}
+
+func NewRegistry(verifiers ...Verifier) *Registry {
+	r := &Registry{}
+	for _, v := range verifiers {
+		r.Register(v)
+	}
+	return r
+}
+
+// Register adds a verifier, replacing the one already registered for its chain namespace
+func (r *Registry) Register(v Verifier) {
+	for idx, registered := range r.verifiers {
+		if registered.ChainNamespace() == v.ChainNamespace() {
+			r.verifiers[idx] = v
+			return
+		}
+	}
+	r.verifiers = append(r.verifiers, v)
+}
+
+func (r *Registry) Get(namespace ChainNamespace) (Verifier, bool) {
+	for _, v := range r.verifiers {
+		if v.ChainNamespace() == namespace {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Detect returns the first registered verifier accepting the wallet address, with the normalized address
+func (r *Registry) Detect(walletAddress string) (Verifier, string, bool) {
+	for _, v := range r.verifiers {
+		if normalized, ok := v.NormalizeAddress(walletAddress); ok {
+			return v, normalized, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,179 @@
+package verifier_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"gatekeeper/pkg/verifier"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Accepts any wallet address as is
+type acceptAllVerifier struct {
+	namespace verifier.ChainNamespace
+}
+
+func (v acceptAllVerifier) ChainNamespace() verifier.ChainNamespace {
+	return v.namespace
+}
+
+func (v acceptAllVerifier) NormalizeAddress(walletAddress string) (string, bool) {
+	return walletAddress, true
+}
+
+func (v acceptAllVerifier) SignedData(message verifier.Message) ([]byte, error) {
+	return []byte(message.Text), nil
+}
+
+func (v acceptAllVerifier) VerifySignature(_ context.Context, _ string, _ []byte, _ string) (bool, error) {
+	return true, nil
+}
+
+// Verifiers in the registration order of the server
+func newVerifiers() []verifier.Verifier {
+	return []verifier.Verifier{
+		verifier.NewEip155Verifier(nil),
+		verifier.NewSolanaVerifier(),
+		verifier.NewBip122Verifier(),
+	}
+}
+
+// Chain namespaces of the verifiers accepting the wallet address
+func acceptingNamespaces(walletAddress string) []verifier.ChainNamespace {
+	var namespaces []verifier.ChainNamespace
+	for _, v := range newVerifiers() {
+		if _, ok := v.NormalizeAddress(walletAddress); ok {
+			namespaces = append(namespaces, v.ChainNamespace())
+		}
+	}
+	return namespaces
+}
+
+type walletAddress struct {
+	Name       string
+	Namespace  verifier.ChainNamespace
+	Address    string
+	Normalized string
+}
+
+func walletAddresses(t *testing.T) []walletAddress {
+	ethereumKey, err := crypto.ToECDSA(bytes.Repeat([]byte{0x01}, 32))
+	require.NoError(t, err)
+	ethereumAddress := crypto.PubkeyToAddress(ethereumKey.PublicKey).Hex()
+
+	solanaKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))
+	solanaAddress := base58.Encode(solanaKey.Public().(ed25519.PublicKey))
+
+	_, bitcoinKey := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{0x01}, 32))
+	pubKeyHash := btcutil.Hash160(bitcoinKey.SerializeCompressed())
+	p2pkh, err := btcutil.NewAddressPubKeyHash(pubKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	testnetP2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	p2tr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootKeyNoScript(bitcoinKey)), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	return []walletAddress{
+		{"Eip155", verifier.ChainNamespace_Eip155, ethereumAddress, ethereumAddress},
+		{"Eip155Lowercase", verifier.ChainNamespace_Eip155, strings.ToLower(ethereumAddress), ethereumAddress},
+		{"Solana", verifier.ChainNamespace_Solana, solanaAddress, solanaAddress},
+		{"SolanaZeroKey", verifier.ChainNamespace_Solana, base58.Encode(make([]byte, 32)), base58.Encode(make([]byte, 32))},
+		{"Bip122P2pkh", verifier.ChainNamespace_Bip122, p2pkh.EncodeAddress(), p2pkh.EncodeAddress()},
+		{"Bip122P2wpkh", verifier.ChainNamespace_Bip122, p2wpkh.EncodeAddress(), p2wpkh.EncodeAddress()},
+		{"Bip122P2wpkhUppercase", verifier.ChainNamespace_Bip122, strings.ToUpper(p2wpkh.EncodeAddress()), p2wpkh.EncodeAddress()},
+		{"Bip122TestnetP2wpkh", verifier.ChainNamespace_Bip122, testnetP2wpkh.EncodeAddress(), testnetP2wpkh.EncodeAddress()},
+		{"Bip122P2tr", verifier.ChainNamespace_Bip122, p2tr.EncodeAddress(), p2tr.EncodeAddress()},
+	}
+}
+
+func TestRegistryDetect(t *testing.T) {
+	registry := verifier.NewRegistry(newVerifiers()...)
+	for _, address := range walletAddresses(t) {
+		t.Run(address.Name, func(t *testing.T) {
+			v, normalized, ok := registry.Detect(address.Address)
+			require.True(t, ok)
+			assert.Equal(t, address.Namespace, v.ChainNamespace())
+			assert.Equal(t, address.Normalized, normalized)
+		})
+	}
+
+	t.Run("Unknown", func(t *testing.T) {
+		for _, address := range []string{
+			"",
+			"WalletAddress",
+			"0x1234",
+			// Base58 but not 32 bytes long
+			base58.Encode(make([]byte, 31)),
+			base58.Encode(make([]byte, 33)),
+			// Pay to script hash addresses are not supported
+			"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy",
+		} {
+			_, _, ok := registry.Detect(address)
+			assert.False(t, ok, address)
+		}
+	})
+
+	t.Run("FirstRegisteredWins", func(t *testing.T) {
+		registry := verifier.NewRegistry(
+			acceptAllVerifier{namespace: "test"},
+			verifier.NewEip155Verifier(nil),
+		)
+		v, _, ok := registry.Detect(walletAddresses(t)[0].Address)
+		require.True(t, ok)
+		assert.Equal(t, verifier.ChainNamespace("test"), v.ChainNamespace())
+
+		registry = verifier.NewRegistry(
+			verifier.NewEip155Verifier(nil),
+			acceptAllVerifier{namespace: "test"},
+		)
+		v, _, ok = registry.Detect(walletAddresses(t)[0].Address)
+		require.True(t, ok)
+		assert.Equal(t, verifier.ChainNamespace_Eip155, v.ChainNamespace())
+	})
+
+	t.Run("RegisterKeepsOrder", func(t *testing.T) {
+		registry := verifier.NewRegistry(
+			verifier.NewEip155Verifier(nil),
+			acceptAllVerifier{namespace: "test"},
+		)
+		registry.Register(acceptAllVerifier{namespace: verifier.ChainNamespace_Eip155})
+
+		v, ok := registry.Get(verifier.ChainNamespace_Eip155)
+		require.True(t, ok)
+		assert.Equal(t, acceptAllVerifier{namespace: verifier.ChainNamespace_Eip155}, v)
+
+		// The replacement is still detected before the verifier registered after it
+		v, normalized, ok := registry.Detect("WalletAddress")
+		require.True(t, ok)
+		assert.Equal(t, verifier.ChainNamespace_Eip155, v.ChainNamespace())
+		assert.Equal(t, "WalletAddress", normalized)
+	})
+}
+
+// Wallet addresses must be accepted by a single chain namespace, otherwise Detect depends on the registration order
+func TestAddressesAreNotAmbiguous(t *testing.T) {
+	for _, address := range walletAddresses(t) {
+		t.Run(address.Name, func(t *testing.T) {
+			assert.Equal(t, []verifier.ChainNamespace{address.Namespace}, acceptingNamespaces(address.Address))
+		})
+	}
+
+	// Hex addresses without the 0x prefix only use base58 characters when they have no zero digit
+	t.Run("Eip155WithoutPrefix", func(t *testing.T) {
+		address := strings.Repeat("ab", 20)
+		assert.Equal(t, []verifier.ChainNamespace{verifier.ChainNamespace_Eip155}, acceptingNamespaces(address))
+	})
+}